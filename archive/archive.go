@@ -1,6 +1,7 @@
 package archive
 
 import (
+	"context"
 	"database/sql"
 	"dhs/extractor/doc"
 	"dhs/util"
@@ -9,7 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 
@@ -17,8 +18,10 @@ import (
 )
 
 type Archive struct {
-	path string
-	doc  *doc.Doc
+	path   string
+	doc    *doc.Doc
+	ctx    context.Context
+	logger *slog.Logger
 }
 
 //go:embed metadoc.db
@@ -60,21 +63,22 @@ var DELETE_JOIN_SQL string
 //go:embed sql/joins_updated.sql
 var UPDATE_JOIN_SQL string
 
-func Open(path string, d ...*doc.Doc) *Archive {
+// Open opens the archive at path, which is created (from the template) when
+// it does not exist, and upgraded when it was created by an older version.
+func Open(path string, d ...*doc.Doc) (*Archive, error) {
 	_, err := os.Stat(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			data, err := DB_TEMPLATE.ReadFile("metadoc.db")
-			if err != nil {
-				log.Fatal(err.Error())
-			}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to open the %v archive: %w", path, err)
+		}
 
-			err = ioutil.WriteFile(path, data, 0644)
-			if err != nil {
-				log.Fatalf("Error writing to file: %v", err)
-			}
-		} else {
-			log.Fatal(err.Error())
+		data, err := DB_TEMPLATE.ReadFile("metadoc.db")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the archive template: %w", err)
+		}
+
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to create the %v archive: %w", path, err)
 		}
 	}
 
@@ -83,7 +87,27 @@ func Open(path string, d ...*doc.Doc) *Archive {
 		document = d[0]
 	}
 
-	return &Archive{path: path, doc: document}
+	a := &Archive{path: path, doc: document}
+	for _, migrate := range []func() error{a.migrateOutbox, a.migrateDeprecated} {
+		if err := migrate(); err != nil {
+			return nil, fmt.Errorf("failed to upgrade the %v archive: %w", path, err)
+		}
+	}
+
+	return a, nil
+}
+
+// SetLogger sets the logger of the archive (slog.Default by default).
+func (a *Archive) SetLogger(logger *slog.Logger) {
+	a.logger = logger
+}
+
+func (a *Archive) log() *slog.Logger {
+	if a.logger == nil {
+		return slog.Default()
+	}
+
+	return a.logger
 }
 
 func (a *Archive) Doc() *doc.Doc {
@@ -103,6 +127,20 @@ func (a *Archive) HasDoc() bool {
 	return true
 }
 
+// SetContext sets the context of the archive queries, which fail once it is
+// done.
+func (a *Archive) SetContext(ctx context.Context) {
+	a.ctx = ctx
+}
+
+func (a *Archive) context() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+
+	return a.ctx
+}
+
 func (a *Archive) AddSchema(name string) {
 	_, err := a.doc.GetSchema(name)
 	if err != nil {
@@ -126,7 +164,7 @@ func (a *Archive) Query(statement string) (*RecordSet, error) {
 	var rows *sql.Rows
 	sql := strings.ToUpper(strings.TrimSpace(statement))
 	if strings.Contains(sql, "SELECT ") {
-		rows, err = conn.Query(statement)
+		rows, err = conn.QueryContext(a.context(), statement)
 		if err != nil {
 			return &RecordSet{}, err
 		}
@@ -166,7 +204,7 @@ func (a *Archive) Query(statement string) (*RecordSet, error) {
 		return results, rows.Err()
 	}
 
-	_, err = conn.Exec(statement)
+	_, err = conn.ExecContext(a.context(), statement)
 	return &RecordSet{}, err
 }
 
@@ -224,7 +262,7 @@ func (a *Archive) ResetDatahub() {
 	for _, stmt := range sql {
 		_, err := a.Query(stmt)
 		if err != nil {
-			fmt.Println(err)
+			a.log().Error("failed to reset the archive", "error", err)
 		}
 	}
 }
@@ -240,7 +278,7 @@ func (a *Archive) ResetDatasource() {
 	for _, stmt := range sql {
 		_, err := a.Query(stmt)
 		if err != nil {
-			fmt.Println(err)
+			a.log().Error("failed to reset the archive", "error", err)
 		}
 	}
 }
@@ -463,9 +501,7 @@ func (a *Archive) DiffSets() (*Diff, error) {
 
 			d.Add(set, set.ID())
 		} else {
-			fmt.Printf("WARNING: Failed to identify set -> %v\n", err.Error())
-			j, _ := json.MarshalIndent(record, "", "  ")
-			fmt.Println(string(j))
+			a.log().Warn("Failed to identify set", "error", err, "record", record)
 		}
 
 		return nil
@@ -498,9 +534,7 @@ func (a *Archive) DiffSets() (*Diff, error) {
 
 			d.Delete(set, set.ID())
 		} else {
-			fmt.Printf("WARNING: %v\n", err.Error())
-			j, _ := json.MarshalIndent(record, "", "  ")
-			fmt.Println(string(j))
+			a.log().Warn("Failed to identify deleted set", "error", err, "record", record)
 		}
 
 		return nil
@@ -518,9 +552,13 @@ func (a *Archive) DiffSets() (*Diff, error) {
 				set.Id = record["id"].(string)
 			}
 
+			if changed(record, "differing_definition") {
+				set.Changed("definition")
+			}
+
 			d.Update(set)
 		} else {
-			fmt.Println(err)
+			a.log().Warn("Failed to identify updated set", "error", err, "record", record)
 		}
 
 		return nil
@@ -599,9 +637,7 @@ func (a *Archive) DiffItems(setdiff *Diff) (*Diff, error) {
 				// fmt.Println(string(j))
 			}
 		} else {
-			fmt.Printf("WARNING: Failed to identify item parent set (%v) -> %v\n", record["dataset_id"], err.Error())
-			j, _ := json.MarshalIndent(record, "", "  ")
-			fmt.Println(string(j))
+			a.log().Warn("Failed to identify item parent set", "set", record["dataset_id"], "error", err, "record", record)
 		}
 
 		return nil
@@ -679,9 +715,7 @@ func (a *Archive) DiffItems(setdiff *Diff) (*Diff, error) {
 					deleted_items = append(deleted_items, item.FQDN)
 				}
 			} else {
-				fmt.Printf("WARNING: Failed to identify item parent set (%v) -> %v\n", record["dataset_id"], err.Error())
-				j, _ := json.MarshalIndent(record, "", "  ")
-				fmt.Println(string(j))
+				a.log().Warn("Failed to identify item parent set", "set", record["dataset_id"], "error", err, "record", record)
 			}
 		}
 
@@ -690,7 +724,6 @@ func (a *Archive) DiffItems(setdiff *Diff) (*Diff, error) {
 
 	rs, err = a.Query(UPDATE_ITEM_SQL)
 	if err != nil {
-		fmt.Println(err)
 		return d, err
 	}
 
@@ -708,6 +741,7 @@ func (a *Archive) DiffItems(setdiff *Diff) (*Diff, error) {
 
 						if record["type_changed"].(int64) == 1 && record["type_database"] != nil {
 							item.Type = record["type_database"].(string)
+							item.Changed("udt_type")
 						}
 
 						if record["nullable_changed"].(int64) == 1 && record["nullable_database"] != nil {
@@ -716,17 +750,21 @@ func (a *Archive) DiffItems(setdiff *Diff) (*Diff, error) {
 							} else {
 								item.Nullable = false
 							}
+							item.Changed("nullable")
 						}
 
 						if record["example_changed"].(int64) == 1 && record["example_database"] != nil {
 							item.Example = record["example_database"].(string)
+							item.Changed("example")
 						}
 
 						if record["default_changed"].(int64) == 1 && record["default_database"] != nil {
 							item.Default = record["default_database"].(string)
+							item.Changed("default")
 						}
 
 						if (record["pk_changed"].(int64) == 1 && record["pk_database"] != nil) || (record["keyname_changed"].(int64) == 1 && record["keyname_database"] != nil) {
+							item.Changed("keys")
 							k := item.GetKey(record["key_nm"].(string))
 							if record["pk_changed"].(int64) == 1 {
 								if record["pk_database"].(int64) == 1 {
@@ -789,9 +827,7 @@ func (a *Archive) DiffItems(setdiff *Diff) (*Diff, error) {
 					// fmt.Println(string(j))
 				}
 			} else {
-				fmt.Printf("WARNING: Failed to identify item parent set (%v) -> %v\n", record["dataset_id"], err.Error())
-				j, _ := json.MarshalIndent(record, "", "  ")
-				fmt.Println(string(j))
+				a.log().Warn("Failed to identify item parent set", "set", record["dataset_id"], "error", err, "record", record)
 			}
 		}
 
@@ -820,7 +856,6 @@ func (a *Archive) DiffRelationships(diff *Diff) (*Diff, error) {
 
 	rs, err = a.Query(DELETE_RELATIONSHIP_SQL)
 	if err != nil {
-		fmt.Println(err)
 		return d, err
 	}
 
@@ -841,6 +876,14 @@ func (a *Archive) DiffRelationships(diff *Diff) (*Diff, error) {
 	rs.ForEach(func(record map[string]interface{}) error {
 		rel, err := a.getRelationship(record, diff)
 		if err == nil {
+			if changed(record, "update_changed") || changed(record, "delete_changed") {
+				rel.Changed("referential_integrity")
+			}
+
+			if changed(record, "match_changed") {
+				rel.Changed("match_type")
+			}
+
 			d.Update(rel)
 		}
 
@@ -928,9 +971,7 @@ func (a *Archive) DiffJoins(setdiff *Diff, diff *Diff) (*Diff, error) {
 				// 	}
 				// }
 
-				fmt.Println(err)
-				j, _ := json.MarshalIndent(record, "", "  ")
-				fmt.Println(string(j))
+				a.log().Warn("Failed to identify join", "error", err, "record", record)
 			}
 		}
 
@@ -977,8 +1018,7 @@ func (a *Archive) DiffJoins(setdiff *Diff, diff *Diff) (*Diff, error) {
 				d.Delete(join, join.ID())
 			}
 		} else {
-			fmt.Println("Error deleting join:", err)
-			util.Dump(record)
+			a.log().Warn("Failed to identify deleted join", "error", err, "record", record)
 		}
 
 		return nil
@@ -1039,8 +1079,7 @@ func (a *Archive) DiffJoins(setdiff *Diff, diff *Diff) (*Diff, error) {
 				return nil
 			}
 
-			fmt.Println("error updating join:", err)
-			util.Dump(record)
+			a.log().Warn("Failed to identify updated join", "error", err, "record", record)
 		}
 
 		return nil
@@ -1059,10 +1098,6 @@ func (a *Archive) getSet(record map[string]interface{}) (*doc.Set, error) {
 		return &doc.Set{}, errors.New("set not found")
 	}
 
-	if record["schema"] == nil {
-		util.Dump(record)
-	}
-
 	if record["schema"] == nil {
 		schemas := a.doc.GetSchemas()
 		if len(schemas) > 0 {
@@ -1100,8 +1135,7 @@ func (a *Archive) getRelationship(record map[string]interface{}, diff *Diff) (*d
 
 	set, err := a.getSet(record)
 	if err != nil {
-		fmt.Println(err)
-		util.Dump(record)
+		a.log().Warn("Failed to identify relationship set", "error", err, "record", record)
 
 		return &doc.Relationship{}, err
 	}
@@ -1140,6 +1174,19 @@ func (a *Archive) getRelationship(record map[string]interface{}, diff *Diff) (*d
 	return rel, nil
 }
 
+// changed reads a boolean "changed" column of a diff query, which SQLite
+// returns as an integer (or NULL when either value is NULL).
+func changed(record map[string]interface{}, column string) bool {
+	switch value := record[column].(type) {
+	case int64:
+		return value == 1
+	case bool:
+		return value
+	}
+
+	return false
+}
+
 func (a *Archive) LookupDatahubSet(name string) (*RecordSet, error) {
 	return a.Query(`
 		SELECT *
@@ -0,0 +1,20 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenReportsErrors(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing", "archive.db")); err == nil {
+		t.Error("opening an archive in a missing directory succeeded")
+	}
+
+	a, err := Open(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Outbox(); err != nil {
+		t.Errorf("the new archive cannot be read: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const CREATE_DEPRECATED_SQL = `
+	CREATE TABLE IF NOT EXISTS deprecated (
+		source TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		set_nm TEXT NOT NULL DEFAULT '',
+		nm TEXT NOT NULL,
+		target TEXT,
+		dt TEXT NOT NULL,
+		PRIMARY KEY (source, kind, set_nm, nm)
+	);
+`
+
+// Deprecation is a Datahub object that no longer exists in the data source
+// and was marked as deprecated instead of being deleted. It is purged once
+// the grace period that started at Since has elapsed.
+type Deprecation struct {
+	// Source is the Datahub ID of the data source.
+	Source string `json:"source"`
+	// Kind is "set", "item" or "relationship".
+	Kind string `json:"kind"`
+	// Set is the physical name of the parent set of an item.
+	Set string `json:"set,omitempty"`
+	// Name is the physical name of the object.
+	Name string `json:"name"`
+	// Target is the Datahub ID of the object.
+	Target string    `json:"target,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// Key identifies the object within its data source.
+func (dep *Deprecation) Key() string {
+	return DeprecationKey(dep.Kind, dep.Set, dep.Name)
+}
+
+func DeprecationKey(kind string, set string, name string) string {
+	return strings.ToLower(strings.TrimSpace(kind) + ":" + strings.TrimSpace(set) + "." + strings.TrimSpace(name))
+}
+
+// migrateDeprecated creates the deprecated table in archives created before
+// it existed.
+func (a *Archive) migrateDeprecated() error {
+	_, err := a.Query(CREATE_DEPRECATED_SQL)
+	return err
+}
+
+// Deprecations lists the deprecated objects of a data source by Key.
+func (a *Archive) Deprecations(source string) (map[string]*Deprecation, error) {
+	deps := make(map[string]*Deprecation)
+
+	rs, err := a.Query("SELECT * FROM deprecated WHERE source = '" + escape(source) + "';")
+	if err != nil {
+		return deps, err
+	}
+
+	err = rs.ForEach(func(record map[string]interface{}) error {
+		dep := &Deprecation{
+			Source: toString(record["source"]),
+			Kind:   toString(record["kind"]),
+			Set:    toString(record["set_nm"]),
+			Name:   toString(record["nm"]),
+			Target: toString(record["target"]),
+		}
+		dep.Since, _ = time.Parse(time.RFC3339, toString(record["dt"]))
+
+		deps[dep.Key()] = dep
+		return nil
+	})
+
+	return deps, err
+}
+
+// Deprecate records the start of the grace period of an object. An object
+// that is already deprecated keeps its original start.
+func (a *Archive) Deprecate(dep *Deprecation) error {
+	if dep.Since.IsZero() {
+		dep.Since = time.Now().UTC()
+	}
+
+	_, err := a.Query(fmt.Sprintf(
+		"INSERT OR IGNORE INTO deprecated (source, kind, set_nm, nm, target, dt) VALUES ('%s','%s','%s','%s','%s','%s');",
+		escape(dep.Source), escape(dep.Kind), escape(dep.Set), escape(dep.Name), escape(dep.Target), dep.Since.Format(time.RFC3339),
+	))
+
+	return err
+}
+
+// Undeprecate removes an object from the deprecated table, because it exists
+// in the data source again or because it was purged.
+func (a *Archive) Undeprecate(dep *Deprecation) error {
+	_, err := a.Query(fmt.Sprintf(
+		"DELETE FROM deprecated WHERE source = '%s' AND kind = '%s' AND set_nm = '%s' AND nm = '%s';",
+		escape(dep.Source), escape(dep.Kind), escape(dep.Set), escape(dep.Name),
+	))
+
+	return err
+}
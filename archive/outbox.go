@@ -0,0 +1,210 @@
+package archive
+
+import (
+	"dhs/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const CREATE_OUTBOX_SQL = `
+	CREATE TABLE IF NOT EXISTS outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source TEXT NOT NULL,
+		action TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		set_nm TEXT,
+		nm TEXT,
+		target TEXT,
+		payload TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		dt TEXT NOT NULL
+	);
+`
+
+// Operation is a Datahub change that could not be delivered (i.e. because
+// the Datahub was down for maintenance). Operations wait in the outbox
+// table until they are flushed, in the order they were queued.
+type Operation struct {
+	ID int64 `json:"id"`
+	// Source is the Datahub ID of the data source.
+	Source string `json:"source"`
+	// Action is "add", "update", "patch" (a partial update) or "delete".
+	Action string `json:"action"`
+	// Kind is "set", "item" or "relationship".
+	Kind string `json:"kind"`
+	// Set is the physical name of the set (or of the parent set of items).
+	// It is used to resolve the set ID when the operation is flushed.
+	Set string `json:"set,omitempty"`
+	// Name is the physical name of the object, for reporting.
+	Name string `json:"name,omitempty"`
+	// Target is the Datahub ID of the object (or of the parent set of items),
+	// when it was known.
+	Target   string                 `json:"target,omitempty"`
+	Payload  map[string]interface{} `json:"payload,omitempty"`
+	Attempts int                    `json:"attempts"`
+	Error    string                 `json:"error,omitempty"`
+	Created  time.Time              `json:"created"`
+}
+
+func (op *Operation) String() string {
+	label := op.Kind
+	if count := op.Count(); count != 1 || op.Name == util.EmptyString && op.Kind != "set" {
+		label = fmt.Sprintf("%v %v(s)", count, op.Kind)
+	}
+
+	switch {
+	case op.Name != util.EmptyString && op.Set != util.EmptyString && op.Kind != "set":
+		return fmt.Sprintf("%v %v %v.%v", op.Action, label, op.Set, op.Name)
+	case op.Name != util.EmptyString:
+		return fmt.Sprintf("%v %v %v", op.Action, label, op.Name)
+	case op.Set != util.EmptyString && op.Kind != "set":
+		return fmt.Sprintf("%v %v in %v", op.Action, label, op.Set)
+	case op.Set != util.EmptyString:
+		return fmt.Sprintf("%v %v %v", op.Action, label, op.Set)
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%v %v %v", op.Action, label, op.Target))
+}
+
+// Count is the number of objects changed by the operation.
+func (op *Operation) Count() int {
+	for _, key := range []string{"items", "relationships"} {
+		if list, ok := op.Payload[key].([]interface{}); ok {
+			return len(list)
+		}
+	}
+
+	return 1
+}
+
+// migrateOutbox creates the outbox table in archives created before it
+// existed.
+func (a *Archive) migrateOutbox() error {
+	_, err := a.Query(CREATE_OUTBOX_SQL)
+	return err
+}
+
+// Enqueue appends operations to the outbox.
+func (a *Archive) Enqueue(ops ...*Operation) error {
+	for _, op := range ops {
+		if op.Source == util.EmptyString || op.Action == util.EmptyString || op.Kind == util.EmptyString {
+			return errors.New("outbox operations require a source, action and kind")
+		}
+
+		if op.Created.IsZero() {
+			op.Created = time.Now().UTC()
+		}
+
+		payload := util.EmptyString
+		if op.Payload != nil {
+			j, err := json.Marshal(op.Payload)
+			if err != nil {
+				return err
+			}
+			payload = string(j)
+		}
+
+		_, err := a.Query(fmt.Sprintf(
+			"INSERT INTO outbox (source, action, kind, set_nm, nm, target, payload, attempts, error, dt) VALUES ('%s','%s','%s','%s','%s','%s','%s',%v,'%s','%s');",
+			escape(op.Source), escape(op.Action), escape(op.Kind), escape(op.Set), escape(op.Name), escape(op.Target), escape(payload), op.Attempts, escape(op.Error), op.Created.Format(time.RFC3339),
+		))
+		if err != nil {
+			return errors.New("failed to queue " + op.String() + " in the outbox: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Outbox lists the queued operations in the order they must be delivered.
+// When a source is specified, only the operations for that source are listed.
+func (a *Archive) Outbox(source ...string) ([]*Operation, error) {
+	ops := make([]*Operation, 0)
+
+	where := util.EmptyString
+	if len(source) > 0 && source[0] != util.EmptyString {
+		where = "WHERE source = '" + escape(source[0]) + "'"
+	}
+
+	rs, err := a.Query("SELECT * FROM outbox " + where + " ORDER BY id;")
+	if err != nil {
+		return ops, err
+	}
+
+	err = rs.ForEach(func(record map[string]interface{}) error {
+		op := &Operation{
+			ID:       toInt64(record["id"]),
+			Source:   toString(record["source"]),
+			Action:   toString(record["action"]),
+			Kind:     toString(record["kind"]),
+			Set:      toString(record["set_nm"]),
+			Name:     toString(record["nm"]),
+			Target:   toString(record["target"]),
+			Attempts: int(toInt64(record["attempts"])),
+			Error:    toString(record["error"]),
+		}
+		op.Created, _ = time.Parse(time.RFC3339, toString(record["dt"]))
+
+		if payload := toString(record["payload"]); payload != util.EmptyString {
+			if err := json.Unmarshal([]byte(payload), &op.Payload); err != nil {
+				return fmt.Errorf("invalid payload for outbox operation %v: %v", op.ID, err)
+			}
+		}
+
+		ops = append(ops, op)
+		return nil
+	})
+
+	return ops, err
+}
+
+// Dequeue removes a delivered (or obsolete) operation from the outbox.
+func (a *Archive) Dequeue(op *Operation) error {
+	_, err := a.Query(fmt.Sprintf("DELETE FROM outbox WHERE id = %v;", op.ID))
+	return err
+}
+
+// Deferred records a failed delivery attempt, leaving the operation queued.
+func (a *Archive) Deferred(op *Operation, reason error) error {
+	op.Attempts++
+	if reason != nil {
+		op.Error = reason.Error()
+	}
+
+	_, err := a.Query(fmt.Sprintf("UPDATE outbox SET attempts = %v, error = '%s' WHERE id = %v;", op.Attempts, escape(op.Error), op.ID))
+	return err
+}
+
+func escape(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return util.EmptyString
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+
+	return 0
+}
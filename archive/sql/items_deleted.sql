@@ -1,8 +1,9 @@
 SELECT dh.*, dhds.schema
 FROM dh_dataitem dh
   LEFT JOIN dh_dataset dhds ON dhds.physical_nm = dh.dataset_id
-WHERE dh.physical_nm NOT IN (
-  SELECT physical_nm
+WHERE NOT EXISTS (
+  SELECT 1
   FROM db_dataitem db
+  WHERE db.dataset_id = dh.dataset_id AND db.physical_nm = dh.physical_nm
 ) AND dhds.schema IS NOT NULL
 ORDER BY dh.physical_nm ;
\ No newline at end of file
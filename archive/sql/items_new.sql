@@ -1,8 +1,9 @@
 SELECT db.*, dbds.schema
 FROM db_dataitem db
   LEFT JOIN db_dataset dbds ON dbds.physical_nm = db.dataset_id
-WHERE db.physical_nm NOT IN (
-  SELECT physical_nm
+WHERE NOT EXISTS (
+  SELECT 1
   FROM dh_dataitem dh
+  WHERE dh.dataset_id = db.dataset_id AND dh.physical_nm = db.physical_nm
 )
 ORDER BY db.physical_nm ;
\ No newline at end of file
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Tables lists the tables of the archive template.
+var Tables = []string{"db_dataset", "db_dataitem", "db_relationship", "db_join", "dh_dataset", "dh_dataitem", "dh_relationship", "dh_join", "settings"}
+
+// Verify checks the integrity of an existing archive without modifying it:
+// the file must be a writable SQLite database that passes the SQLite
+// integrity check and has the tables of the template.
+func Verify(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow("PRAGMA integrity_check;").Scan(&result); err != nil {
+		return fmt.Errorf("not a valid archive: %w", err)
+	}
+
+	if result != "ok" {
+		return errors.New("the archive is corrupt: " + result)
+	}
+
+	missing := []string{}
+	for _, table := range Tables {
+		var name string
+		if err := conn.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?;", table).Scan(&name); err != nil {
+			missing = append(missing, table)
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.New("the archive has no " + strings.Join(missing, ", ") + " table(s)")
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"dhs/archive"
+	"dhs/extractor"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+)
+
+type Backup struct {
+	Config     string `name:"config" short:"c" type:"string" help:"Configuration file with the Datahub connection settings." default:"./dh-config.yml"`
+	Source     string `name:"source" short:"i" type:"string" help:"Name or ID of the Datahub data source to back up."`
+	DatahubURL string `name:"url" short:"u" help:"URL of the Datahub API"`
+	APIKey     string `name:"api_key" short:"k" help:"Optional API key to access the Datahub"`
+	Outfile    string `arg:"out" type:"path" help:"JSON file the backup is written to."`
+}
+
+func (b *Backup) Run(ctx *Context) error {
+	e := &Extractor{Config: b.Config, Source: b.Source, DatahubURL: b.DatahubURL, APIKey: b.APIKey}
+	if err := e.datahubSettings(); err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	if e.Source == util.EmptyString {
+		err := errors.New("the Datahub data source is required (--source or the configuration file)")
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	slog.Info("Backing up the data source...", "source", e.Source)
+	dh, err := e.connect(nil)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	backup, err := dh.Backup()
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	if err := ioutil.WriteFile(b.Outfile, backup.ToJSON(), 0644); err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	slog.Info("backed up the data source", "sets", len(extractor.GetAllSets(backup)), "items", len(extractor.GetAllItems(backup)), "relationships", len(extractor.GetAllRelationships(backup)), "file", b.Outfile)
+
+	return nil
+}
+
+type Restore struct {
+	Config       string `name:"config" short:"c" type:"string" help:"Configuration file with the Datahub connection settings." default:"./dh-config.yml"`
+	Source       string `name:"source" short:"i" type:"string" help:"Name or ID of the Datahub data source to restore into. Defaults to the data source in the backup."`
+	DatahubURL   string `name:"url" short:"u" help:"URL of the Datahub API"`
+	APIKey       string `name:"api_key" short:"k" help:"Optional API key to access the Datahub"`
+	CreateSource bool   `name:"create-source" help:"Create the Datahub data source when it does not exist."`
+	Prune        bool   `name:"prune" help:"Delete sets and relationships that are not in the backup."`
+	DryRun       bool   `name:"dryrun" type:"bool" default:"false" help:"Preview the changes without pushing them."`
+	Max          int    `name:"max" short:"m" default:"35" help:"The maximum number of changes to preview."`
+	Infile       string `arg:"in" type:"existingfile" help:"JSON backup file to restore."`
+}
+
+func (r *Restore) Run(ctx *Context) error {
+	data, err := ioutil.ReadFile(r.Infile)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	backup, err := doc.FromJSON(data)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	e := &Extractor{Config: r.Config, Source: r.Source, DatahubURL: r.DatahubURL, APIKey: r.APIKey}
+	if err := e.datahubSettings(); err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	if e.Source == util.EmptyString {
+		e.Source = backup.Source().Name.Physical
+	}
+
+	slog.Info("Restoring the backup...", "file", r.Infile, "source", e.Source)
+	cache, err := archive.Open(ARCHIVE_PATH)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	dh, err := e.connect(cache)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	if r.CreateSource {
+		dh.CreateMissingSource(backup.Source())
+	}
+
+	diffs, err := dh.Restore(backup, r.Prune)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	for i, datatype := range []string{"set", "item", "relationship"} {
+		dh.DryRun(diffs[i], r.Max, datatype)
+		if !r.DryRun {
+			dh.Commit(diffs[i])
+		}
+	}
+
+	if r.DryRun {
+		return nil
+	}
+
+	results := dh.Results()
+	slog.Info(fmt.Sprintf("restored %v change(s)", results.Succeeded), "succeeded", results.Succeeded)
+	if results.Queued > 0 {
+		slog.Warn(fmt.Sprintf("%v change(s) were queued in the outbox because the Datahub is unreachable. They are delivered by the flush command.", results.Queued), "queued", results.Queued)
+	}
+
+	if results.Failed > 0 {
+		err := fmt.Errorf("%v change(s) failed to restore", results.Failed)
+		slog.Error(err.Error())
+		return withExitCode(ExitPartial, err)
+	}
+
+	return nil
+}
+
+// datahubSettings applies the Datahub connection settings from the
+// configuration file (when it exists) and checks the Datahub URL is known. A
+// multi-source configuration provides its shared datahub block.
+func (e *Extractor) datahubSettings() error {
+	e.Config = configFile(e.Config)
+	if _, err := os.Stat(e.Config); err == nil {
+		cfg, err := LoadConfiguration(e.Config, e.profile())
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+
+		var src *ExtractorConfiguration
+		if cfg.Multi() {
+			src, err = cfg.resolve(&ExtractorConfiguration{})
+		} else {
+			src, err = cfg.Source(util.EmptyString)
+		}
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+
+		if err := src.apply(e); err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+	}
+
+	if e.DatahubURL == util.EmptyString {
+		return withExitCode(ExitConfig, errors.New("the Datahub URL is required (--url or the configuration file)"))
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package command
+
+import (
+	"dhs/pipeline"
+	"dhs/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sourceOutcome is the outcome of a source in a batch run.
+type sourceOutcome struct {
+	Name     string `json:"name"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	*pipeline.Report
+}
+
+// runAll syncs the selected sources of a multi-source configuration, at most
+// --parallel at a time, each with its own archive. It logs a summary and
+// exits with the most severe exit code of the sources.
+func (e *Extractor) runAll(ctx *Context) error {
+	if e.ConnectionString != util.EmptyString {
+		err := errors.New("--all and --source select the sources of a configuration file; they cannot be combined with a connection string")
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	e.Config = configFile(e.Config)
+	if _, err := os.Stat(e.Config); err != nil {
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	cfg, err := LoadConfiguration(e.Config, e.profile())
+	if err != nil {
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	if !cfg.Multi() {
+		err := fmt.Errorf("%v is not a multi-source configuration (it has no sources list)", e.Config)
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	names := e.Sources
+	if e.All {
+		names = cfg.Names()
+	}
+	if err := e.shared(cfg, names); err != nil {
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	parallel := e.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	slog.Info(fmt.Sprintf("Syncing %v source(s), %v at a time...", len(names), parallel), "sources", names)
+	start := time.Now()
+	outcomes := make([]*sourceOutcome, len(names))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			if err := ctx.Err(); err != nil {
+				outcomes[i] = &sourceOutcome{Name: name, ExitCode: ExitInterrupted, Error: "the sync was interrupted before it started"}
+				return
+			}
+			outcomes[i] = e.source(name).runSource(ctx)
+		}(i, name)
+	}
+	wg.Wait()
+
+	code := ExitSuccess
+	for _, outcome := range outcomes {
+		code = worst(code, outcome.ExitCode)
+		attrs := []any{"source_name", outcome.Name, "exit_code", outcome.ExitCode}
+		if outcome.Report != nil {
+			attrs = append(attrs, "changes", outcome.Changes)
+		}
+
+		if outcome.Error != util.EmptyString {
+			slog.Error(fmt.Sprintf("%v: %v", outcome.Name, outcome.Error), append(attrs, "error", outcome.Error)...)
+		} else {
+			slog.Info(fmt.Sprintf("%v: synced", outcome.Name), attrs...)
+		}
+	}
+	slog.Info(fmt.Sprintf("Synced %v source(s) in %s", len(names), time.Since(start)), "exit_code", code, "elapsed", time.Since(start))
+
+	if e.Report != util.EmptyString {
+		report := map[string]interface{}{
+			"sources":   outcomes,
+			"exit_code": code,
+		}
+
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if err := ioutil.WriteFile(e.Report, data, 0644); err != nil {
+			slog.Error("failed to write the report", "file", e.Report, "error", err)
+		}
+	}
+
+	if code == ExitSuccess {
+		return nil
+	}
+
+	if count := failures(outcomes); count > 0 {
+		return &ExitError{Code: code, Err: fmt.Errorf("the sync of %v source(s) did not succeed", count)}
+	}
+
+	return &ExitError{Code: code}
+}
+
+// shared verifies the sources exist and do not write the same files: the
+// archive and the extraction dump of the configuration are not suffixed, so
+// each source must name its own.
+func (e *Extractor) shared(cfg *Configuration, names []string) error {
+	archives, outfiles := make(map[string]string), make(map[string]string)
+	for _, name := range names {
+		src, err := cfg.lookup(name)
+		if err != nil {
+			return err
+		}
+
+		if e.Archive == util.EmptyString && src.Archive != util.EmptyString {
+			if other, exists := archives[filepath.Clean(src.Archive)]; exists {
+				return fmt.Errorf("the %v and %v sources share the %v archive; each source needs its own", other, name, src.Archive)
+			}
+			archives[filepath.Clean(src.Archive)] = name
+		}
+
+		if e.Outfile == util.EmptyString && src.Outfile != util.EmptyString {
+			if other, exists := outfiles[filepath.Clean(src.Outfile)]; exists {
+				return fmt.Errorf("the %v and %v sources share the %v outfile; each source needs its own", other, name, src.Outfile)
+			}
+			outfiles[filepath.Clean(src.Outfile)] = name
+		}
+	}
+
+	return nil
+}
+
+// source returns a copy of the command line settings for a source. The files
+// given on the command line are used by every source, so the name of the
+// source is appended to their names, i.e. --archive sync.db is sync-sales.db
+// for the sales source, and --replay replays the cassette --record wrote.
+func (e *Extractor) source(name string) *Extractor {
+	run := *e
+	run.Name = name
+	run.Sources, run.All, run.Report = nil, false, util.EmptyString
+	run.Archive, run.Outfile = suffixed(e.Archive, name), suffixed(e.Outfile, name)
+	run.Record, run.Replay = suffixed(e.Record, name), suffixed(e.Replay, name)
+	run.Only = append([]string{}, e.Only...)
+	if e.Only == nil {
+		run.Only = nil
+	}
+
+	return &run
+}
+
+func (e *Extractor) runSource(ctx *Context) *sourceOutcome {
+	result, code, err := e.execute(ctx)
+	outcome := &sourceOutcome{Name: e.Name, ExitCode: code}
+	if result != nil {
+		outcome.Report = result.Report(err)
+	}
+
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+
+	return outcome
+}
+
+// worst returns the most severe of two exit codes: any failure is more severe
+// than changes, which are more severe than a success.
+func worst(a int, b int) int {
+	failure := func(code int) bool {
+		return code != ExitSuccess && code != ExitChanges
+	}
+
+	switch {
+	case failure(a) && failure(b):
+		if b > a {
+			return b
+		}
+		return a
+	case failure(a):
+		return a
+	case failure(b):
+		return b
+	case a == ExitChanges || b == ExitChanges:
+		return ExitChanges
+	}
+
+	return ExitSuccess
+}
+
+func failures(outcomes []*sourceOutcome) int {
+	count := 0
+	for _, outcome := range outcomes {
+		if outcome.ExitCode != ExitSuccess && outcome.ExitCode != ExitChanges {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceSuffixesTheFiles(t *testing.T) {
+	e := &Extractor{Archive: "./sync.db", Outfile: "dump.json", Record: "traffic.yaml", Replay: "traffic.yaml", All: true}
+	run := e.source("sales")
+
+	if run.Archive != "./sync-sales.db" || run.Outfile != "dump-sales.json" || run.Record != "traffic-sales.yaml" || run.Replay != "traffic-sales.yaml" {
+		t.Errorf("archive = %v, outfile = %v, record = %v, replay = %v", run.Archive, run.Outfile, run.Record, run.Replay)
+	}
+
+	if run := (&Extractor{}).source("sales"); run.Archive != "" || run.Outfile != "" || run.Record != "" || run.Replay != "" {
+		t.Errorf("archive = %q, outfile = %q, record = %q, replay = %q, want the defaults", run.Archive, run.Outfile, run.Record, run.Replay)
+	}
+}
+
+func TestRunAllRejectsSharedFiles(t *testing.T) {
+	config := writeConfig(t, `
+datahub:
+  url: http://datahub.invalid
+sources:
+  - name: sales
+    type: postgresql
+    host: sales-db
+    database: sales
+    datahub_source: sales
+    archive: shared.db
+  - name: hr
+    type: postgresql
+    host: hr-db
+    database: hr
+    datahub_source: hr
+    archive: ./shared.db
+`)
+
+	err := (&Extractor{Config: config, All: true}).runAll(&Context{})
+	var exit *ExitError
+	if !errors.As(err, &exit) || exit.Code != ExitConfig || !strings.Contains(err.Error(), "share the") {
+		t.Errorf("err = %v, want a configuration error", err)
+	}
+}
+
+func TestRunAllReplaysTheCassetteOfEachSource(t *testing.T) {
+	_, ts := mockDatahub(t, "sales", "hr")
+	config := writeConfig(t, `
+datahub:
+  url: `+ts.URL+`
+sources:
+  - name: sales
+    type: postgresql
+    host: sales-db
+    database: sales
+    datahub_source: sales
+  - name: hr
+    type: postgresql
+    host: hr-db
+    database: hr
+    datahub_source: hr
+`)
+
+	dir := t.TempDir()
+	db := &fake{schemas: map[string][]string{"public": {"users", "orders"}}}
+	ctx := &Context{Context: context.Background()}
+
+	record := &Extractor{Config: config, All: true, Parallel: 2, Archive: filepath.Join(dir, "record.db"), Record: filepath.Join(dir, "traffic.json"), db: db}
+	if err := record.runAll(ctx); err != nil {
+		t.Fatalf("the recorded sync failed: %v", err)
+	}
+
+	for _, name := range []string{"traffic-sales.json", "traffic-hr.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("the cassette was not recorded: %v", err)
+		}
+	}
+
+	// The Datahub is gone: the syncs only succeed when each source replays
+	// its own cassette.
+	ts.Close()
+	replay := &Extractor{Config: config, All: true, Parallel: 2, Archive: filepath.Join(dir, "replay.db"), Replay: filepath.Join(dir, "traffic.json"), db: db}
+	if err := replay.runAll(ctx); err != nil {
+		t.Errorf("the replayed sync failed: %v", err)
+	}
+}
@@ -1,33 +1,426 @@
 package command
 
 import (
+	"dhs/extractor"
+	"dhs/extractor/datahub"
 	"dhs/util"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+type AuthConfiguration struct {
+	Type                string   `yaml:"type" json:"type,omitempty"`
+	User                string   `yaml:"user" json:"user,omitempty"`
+	Password            string   `yaml:"password" json:"password,omitempty"`
+	PasswordFile        string   `yaml:"password_file" json:"password_file,omitempty"`
+	PasswordCommand     string   `yaml:"password_command" json:"password_command,omitempty"`
+	TokenURL            string   `yaml:"token_url" json:"token_url,omitempty"`
+	ClientID            string   `yaml:"client_id" json:"client_id,omitempty"`
+	ClientSecret        string   `yaml:"client_secret" json:"client_secret,omitempty"`
+	ClientSecretFile    string   `yaml:"client_secret_file" json:"client_secret_file,omitempty"`
+	ClientSecretCommand string   `yaml:"client_secret_command" json:"client_secret_command,omitempty"`
+	Scopes              []string `yaml:"scopes" json:"scopes,omitempty"`
+	Audience            string   `yaml:"audience" json:"audience,omitempty"`
+	TokenCache          string   `yaml:"token_cache" json:"token_cache,omitempty"`
+}
+
+type TLSConfiguration struct {
+	CAFile     string `yaml:"ca_file" json:"ca_file,omitempty"`
+	CertFile   string `yaml:"cert_file" json:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file" json:"key_file,omitempty"`
+	ServerName string `yaml:"server_name" json:"server_name,omitempty"`
+	Insecure   bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+type ProxyConfiguration struct {
+	URL     string   `yaml:"url" json:"url,omitempty"`
+	NoProxy []string `yaml:"no_proxy" json:"no_proxy,omitempty"`
+}
+
+// FilterConfiguration leaves sets and items out of the sync by name, with
+// globs or /regular expressions/ (see extractor.Filter).
+type FilterConfiguration struct {
+	IncludeSets       []string `yaml:"include_sets" json:"include_sets,omitempty"`
+	ExcludeSets       []string `yaml:"exclude_sets" json:"exclude_sets,omitempty"`
+	IncludeItems      []string `yaml:"include_items" json:"include_items,omitempty"`
+	ExcludeItems      []string `yaml:"exclude_items" json:"exclude_items,omitempty"`
+	ExcludePartitions bool     `yaml:"exclude_partitions" json:"exclude_partitions,omitempty"`
+}
+
+// Filter compiles the filter.
+func (c *FilterConfiguration) Filter() (*extractor.Filter, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	f := &extractor.Filter{
+		IncludeSets:       c.IncludeSets,
+		ExcludeSets:       c.ExcludeSets,
+		IncludeItems:      c.IncludeItems,
+		ExcludeItems:      c.ExcludeItems,
+		ExcludePartitions: c.ExcludePartitions,
+	}
+
+	return f, f.Compile()
+}
+
+// MappingConfiguration maps the database schemas to the Datahub: it renames
+// the schemas, prefixes the set names and sends schemas to other Datahub data
+// sources (see extractor.Mapping).
+type MappingConfiguration struct {
+	SetPrefix string                       `yaml:"set_prefix" json:"set_prefix,omitempty"`
+	Schemas   []SchemaMappingConfiguration `yaml:"schemas" json:"schemas,omitempty"`
+}
+
+// SchemaMappingConfiguration maps the schemas matching a glob or a /regular
+// expression/.
+type SchemaMappingConfiguration struct {
+	Schema    string `yaml:"schema" json:"schema"`
+	Name      string `yaml:"name" json:"name,omitempty"`
+	SetPrefix string `yaml:"set_prefix" json:"set_prefix,omitempty"`
+	Source    string `yaml:"datahub_source" json:"datahub_source,omitempty"`
+}
+
+// Mapping compiles the mapping for a Datahub data source: source is the
+// default data source, and target the data source being synced (empty for the
+// default one). The schemas sent to the default data source by name keep
+// their default route.
+func (c *MappingConfiguration) Mapping(source string, target string) (*extractor.Mapping, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	m := &extractor.Mapping{SetPrefix: c.SetPrefix, Target: target}
+	for _, rule := range c.Schemas {
+		dest := rule.Source
+		if strings.EqualFold(dest, source) {
+			dest = util.EmptyString
+		}
+
+		m.Rules = append(m.Rules, &extractor.MappingRule{
+			Schema:    rule.Schema,
+			Name:      rule.Name,
+			SetPrefix: rule.SetPrefix,
+			Source:    dest,
+		})
+	}
+
+	return m, m.Compile()
+}
+
 type ExtractorConfiguration struct {
 	yamlfile   string
-	Type       string   `yaml:"type"`
-	Host       string   `yaml:"host"`
-	Database   string   `yaml:"database"`
-	Schemas    []string `yaml:"schemas"`
-	User       string   `yaml:"user"`
-	Password   string   `yaml:"password"`
-	Connstr    string   `yaml:"connection_string"`
-	Expand     []string `yaml:"expand_json"`
-	ExpandFast bool     `yaml:"expand_fast"`
-	URL        string   `yaml:"datahub_url"`
-	Source     string   `yaml:"datahub_source"`
-	Outfile    string   `yaml:"outfile"`
-	DryRun     bool     `yaml:"dryrun"`
-	System     string   `yaml:"system_id"`
-	APIKey     string   `yaml:"api_key"`
-	Max        int      `yaml:"max"`
-	Debug      bool     `yaml:"debug"`
+	Name       string               `yaml:"name"`
+	Type       string               `yaml:"type"`
+	Host       string               `yaml:"host"`
+	Database   string               `yaml:"database"`
+	Schemas    []string             `yaml:"schemas"`
+	User       string               `yaml:"user"`
+	Password   string               `yaml:"password"`
+	PassFile   string               `yaml:"password_file"`
+	PassCmd    string               `yaml:"password_command"`
+	Connstr    string               `yaml:"connection_string"`
+	Expand     []string             `yaml:"expand_json"`
+	ExpandFast bool                 `yaml:"expand_fast"`
+	URL        string               `yaml:"datahub_url"`
+	Source     string               `yaml:"datahub_source"`
+	Create     bool                 `yaml:"create_source"`
+	Outfile    string               `yaml:"outfile"`
+	DryRun     bool                 `yaml:"dryrun"`
+	System     string               `yaml:"system_id"`
+	APIKey     string               `yaml:"api_key"`
+	APIKeyFile string               `yaml:"api_key_file"`
+	APIKeyCmd  string               `yaml:"api_key_command"`
+	Auth       AuthConfiguration    `yaml:"datahub_auth"`
+	TLS        TLSConfiguration     `yaml:"datahub_tls"`
+	Proxy      ProxyConfiguration   `yaml:"datahub_proxy"`
+	Max        int                  `yaml:"max"`
+	BatchSize  int                  `yaml:"batch_size"`
+	Only       []string             `yaml:"only"`
+	Deletion   string               `yaml:"deletion_policy"`
+	Grace      string               `yaml:"grace_period"`
+	MaxDelete  int                  `yaml:"max_deletions"`
+	MaxPercent float64              `yaml:"max_delete_percent"`
+	Timeouts   map[string]string    `yaml:"timeouts"`
+	Filters    FilterConfiguration  `yaml:"filters"`
+	Mapping    MappingConfiguration `yaml:"mapping"`
+	Archive    string               `yaml:"archive"`
+	Debug      bool                 `yaml:"debug"`
+}
+
+// DatahubConfiguration is the Datahub block shared by the sources of a
+// multi-source configuration. The sources can override each setting.
+type DatahubConfiguration struct {
+	URL        string             `yaml:"url"`
+	APIKey     string             `yaml:"api_key"`
+	APIKeyFile string             `yaml:"api_key_file"`
+	APIKeyCmd  string             `yaml:"api_key_command"`
+	Auth       AuthConfiguration  `yaml:"auth"`
+	TLS        TLSConfiguration   `yaml:"tls"`
+	Proxy      ProxyConfiguration `yaml:"proxy"`
+	BatchSize  int                `yaml:"batch_size"`
+	System     string             `yaml:"system_id"`
+}
+
+// Configuration is the content of a configuration file: either a single
+// source (the original format) or a shared datahub block and a list of named
+// sources:
+//
+//	datahub:
+//	  url: https://datahub/api
+//	  api_key: ...
+//	sources:
+//	  - name: sales
+//	    type: postgresql
+//	    host: sales-db
+//	    database: sales
+//	    datahub_source: sales
+type Configuration struct {
+	Datahub DatahubConfiguration     `yaml:"datahub"`
+	Sources []ExtractorConfiguration `yaml:"sources"`
+	path    string
+}
+
+// LoadConfiguration reads a configuration file, in YAML or JSON, with a
+// profile (none when empty). A single-source file is a configuration with one
+// (usually unnamed) source. Unknown settings and invalid values are reported
+// in a ConfigError.
+//
+// Profiles override the settings of the file, i.e. for each environment. A
+// profile can extend another one:
+//
+//	profiles:
+//	  staging:
+//	    host: staging-db
+//	    datahub_source: staging
+//	  prod:
+//	    extends: staging
+//	    host: prod-db
+func LoadConfiguration(path string, profile string) (*Configuration, error) {
+	return loadConfiguration(path, profile, true)
+}
+
+// loadConfiguration reads a configuration file, and resolves its environment
+// variables unless the structure is only checked (the variables are then
+// left as written).
+func loadConfiguration(path string, profile string, resolve bool) (*Configuration, error) {
+	yamldata, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(yamldata, &document); err != nil {
+		return nil, &ConfigError{File: path, Problems: []string{strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+
+	if resolve {
+		if err := interpolate(&document); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, &ConfigError{File: path, Problems: []string{"the configuration must be a mapping of settings"}}
+	}
+
+	root := document.Content[0]
+	profiles := mapping(root, "profiles")
+	root = without(root, "profiles")
+
+	cfg := &Configuration{path: path}
+	multi := mapping(root, "sources") != nil || mapping(root, "datahub") != nil
+	var settings reflect.Type = reflect.TypeOf(ExtractorConfiguration{})
+	if multi {
+		settings = reflect.TypeOf(Configuration{})
+	}
+
+	problems := []string{}
+	checkKeys(root, settings, util.EmptyString, &problems)
+	for _, name := range profileNames(profiles) {
+		checkKeys(without(mapping(profiles, name), "extends"), settings, "profiles."+name, &problems)
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{File: path, Problems: problems}
+	}
+
+	if profile != util.EmptyString {
+		if root, err = applyProfile(root, profiles, profile); err != nil {
+			return nil, &ConfigError{File: path, Problems: []string{err.Error()}}
+		}
+	}
+
+	if multi {
+		err = root.Decode(cfg)
+	} else {
+		src := ExtractorConfiguration{}
+		err = root.Decode(&src)
+		cfg.Sources = []ExtractorConfiguration{src}
+	}
+	if err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			return nil, &ConfigError{File: path, Problems: typeErr.Errors}
+		}
+		return nil, &ConfigError{File: path, Problems: []string{err.Error()}}
+	}
+
+	names := []string{}
+	for i, src := range cfg.Sources {
+		if multi && src.Name == util.EmptyString {
+			problems = append(problems, fmt.Sprintf("source #%v needs a name", i+1))
+		}
+
+		if src.Name != util.EmptyString && util.InSlice[string](src.Name, names) {
+			problems = append(problems, fmt.Sprintf("duplicate source %q", src.Name))
+		}
+		names = append(names, src.Name)
+
+		problems = append(problems, cfg.inherit(&src).validate()...)
+	}
+
+	if multi && len(cfg.Sources) == 0 {
+		problems = append(problems, "no sources")
+	}
+
+	if len(problems) > 0 {
+		return nil, &ConfigError{File: path, Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// Profiles lists the profiles of a configuration file.
+func Profiles(path string) ([]string, error) {
+	yamldata, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(yamldata, &document); err != nil || len(document.Content) == 0 {
+		return nil, err
+	}
+
+	return profileNames(mapping(document.Content[0], "profiles")), nil
+}
+
+// Multi reports whether the configuration lists named sources.
+func (cfg *Configuration) Multi() bool {
+	return len(cfg.Sources) > 1 || cfg.Sources[0].Name != util.EmptyString
+}
+
+// Names lists the sources of the configuration.
+func (cfg *Configuration) Names() []string {
+	names := []string{}
+	for _, src := range cfg.Sources {
+		names = append(names, src.Name)
+	}
+
+	return names
+}
+
+// Source returns the configuration of a source, with the shared datahub
+// settings and the secrets resolved. The name can be omitted when there is a
+// single source.
+func (cfg *Configuration) Source(name string) (*ExtractorConfiguration, error) {
+	src, err := cfg.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.resolve(src)
+}
+
+// lookup returns a copy of the configuration of a source, as written.
+func (cfg *Configuration) lookup(name string) (*ExtractorConfiguration, error) {
+	if name == util.EmptyString && len(cfg.Sources) == 1 {
+		src := cfg.Sources[0]
+		src.yamlfile = cfg.path
+		return &src, nil
+	}
+
+	if name == util.EmptyString {
+		return nil, fmt.Errorf("the configuration has %v sources (%v); select one with --source or sync them all with --all", len(cfg.Sources), strings.Join(cfg.Names(), ", "))
+	}
+
+	for _, src := range cfg.Sources {
+		if src.Name == name {
+			src.yamlfile = cfg.path
+			return &src, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the configuration has no source named %q (expected %v)", name, strings.Join(cfg.Names(), ", "))
+}
+
+func (cfg *Configuration) resolve(src *ExtractorConfiguration) (*ExtractorConfiguration, error) {
+	src = cfg.inherit(src)
+	if err := src.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// inherit applies the shared datahub settings the source does not override.
+func (cfg *Configuration) inherit(src *ExtractorConfiguration) *ExtractorConfiguration {
+	shared := cfg.Datahub
+	if src.URL == util.EmptyString {
+		src.URL = shared.URL
+	}
+
+	if src.APIKey == util.EmptyString && src.APIKeyFile == util.EmptyString && src.APIKeyCmd == util.EmptyString {
+		src.APIKey, src.APIKeyFile, src.APIKeyCmd = shared.APIKey, shared.APIKeyFile, shared.APIKeyCmd
+	}
+
+	if reflect.ValueOf(src.Auth).IsZero() {
+		src.Auth = shared.Auth
+	}
+
+	if reflect.ValueOf(src.TLS).IsZero() {
+		src.TLS = shared.TLS
+	}
+
+	if reflect.ValueOf(src.Proxy).IsZero() {
+		src.Proxy = shared.Proxy
+	}
+
+	if src.BatchSize == util.EmptyInt {
+		src.BatchSize = shared.BatchSize
+	}
+
+	if src.System == util.EmptyString {
+		src.System = shared.System
+	}
+
+	return src
+}
+
+// DefaultConfig is the default configuration file. ./dh-config.yaml or
+// ./dh-config.json is used instead when it does not exist.
+const DefaultConfig = "./dh-config.yml"
+
+func configFile(path string) string {
+	if path != DefaultConfig {
+		return path
+	}
+
+	for _, candidate := range []string{DefaultConfig, "./dh-config.yaml", "./dh-config.json"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return path
 }
 
 func NewConfig(path string) *ExtractorConfiguration {
@@ -51,23 +444,41 @@ func (c ExtractorConfiguration) ConnectionString() string {
 	return util.EncodeURL(strings.ToLower(c.Type) + "://" + c.User + ":" + c.Password + "@" + c.Host + "/" + c.Database)
 }
 
+// Apply fills the settings of the extractor that are not set on the command
+// line with the configuration of its source (see Extractor.Name).
 func (c ExtractorConfiguration) Apply(e *Extractor) error {
-	yamldata, err := ioutil.ReadFile(c.yamlfile)
+	cfg, err := LoadConfiguration(c.yamlfile, e.profile())
 	if err != nil {
 		return err
 	}
 
-	if err := yaml.Unmarshal(yamldata, &c); err != nil {
-		fmt.Printf("invalid configuration: %s", err.Error())
+	src, err := cfg.Source(e.Name)
+	if err != nil {
 		return err
 	}
 
+	return src.apply(e)
+}
+
+func (c *ExtractorConfiguration) apply(e *Extractor) error {
 	e.ConnectionString = c.ConnectionString()
 
 	if e.APIKey == util.EmptyString {
 		e.APIKey = c.APIKey
 	}
 
+	if e.Auth == nil {
+		e.Auth = &c.Auth
+	}
+
+	if e.TLS == nil {
+		e.TLS = &c.TLS
+	}
+
+	if e.Proxy == nil {
+		e.Proxy = &c.Proxy
+	}
+
 	if e.Schemas == nil {
 		e.Schemas = c.Schemas
 	}
@@ -92,6 +503,10 @@ func (c ExtractorConfiguration) Apply(e *Extractor) error {
 		e.Source = c.Source
 	}
 
+	if e.CreateSource == util.EmptyBool && c.Create != util.EmptyBool {
+		e.CreateSource = c.Create
+	}
+
 	if e.SkipViewExpand == util.EmptyBool {
 		e.SkipViewExpand = c.ExpandFast
 	}
@@ -104,13 +519,119 @@ func (c ExtractorConfiguration) Apply(e *Extractor) error {
 		e.Debug = c.Debug
 	}
 
-	if e.Max != util.EmptyInt && c.Max != util.EmptyInt && c.Max > 0 {
-		e.Max = c.Max
+	if e.BatchSize == util.EmptyInt {
+		e.BatchSize = c.BatchSize
+	}
+
+	if e.Only == nil {
+		e.Only = c.Only
 	}
 
-	if e.Max < 1 {
-		e.Max = 35
+	if e.DeletionPolicy == util.EmptyString {
+		e.DeletionPolicy = c.Deletion
+	}
+
+	if e.GracePeriod == util.EmptyString {
+		e.GracePeriod = c.Grace
+	}
+
+	if e.MaxDeletions == util.EmptyInt {
+		e.MaxDeletions = c.MaxDelete
+	}
+
+	if e.MaxDeletePercent == 0 {
+		e.MaxDeletePercent = c.MaxPercent
+	}
+
+	if e.Timeouts == nil {
+		e.Timeouts = c.Timeouts
+	}
+
+	if e.Filters == nil {
+		e.Filters = &c.Filters
+	}
+
+	if e.Mapping == nil {
+		e.Mapping = &c.Mapping
+	}
+
+	if e.Archive == util.EmptyString {
+		e.Archive = c.Archive
+	}
+
+	if e.Max == util.EmptyInt {
+		e.Max = c.Max
 	}
 
 	return nil
 }
+
+// Authenticator creates the Datahub authentication provider described by the
+// configuration. A nil provider means the defaults of datahub.New apply.
+func (a *AuthConfiguration) Authenticator(root string, apikey string) (datahub.Authenticator, error) {
+	uri, err := url.Parse(util.EncodeURL(root))
+	if err != nil {
+		return nil, err
+	}
+	uri.User = nil
+
+	authtype := strings.ToLower(strings.TrimSpace(a.Type))
+	if authtype == util.EmptyString {
+		if a.ClientID != util.EmptyString {
+			authtype = "oauth2"
+		} else if a.User != util.EmptyString {
+			authtype = "basic"
+		} else {
+			return nil, nil
+		}
+	}
+
+	switch authtype {
+	case "api_key", "apikey":
+		if apikey == util.EmptyString {
+			return nil, errors.New("datahub_auth: the api_key authentication type requires an api_key")
+		}
+
+		return datahub.NewAPIKeyAuth(apikey), nil
+	case "basic", "jwt":
+		if a.User == util.EmptyString {
+			return nil, errors.New("datahub_auth: the basic authentication type requires a user")
+		}
+
+		auth := datahub.NewBasicAuth(uri.String(), a.User, a.Password)
+		auth.CacheFile = a.TokenCache
+		return auth, nil
+	case "oauth2", "client_credentials":
+		if a.TokenURL == util.EmptyString || a.ClientID == util.EmptyString || a.ClientSecret == util.EmptyString {
+			return nil, errors.New("datahub_auth: the oauth2 authentication type requires a token_url, client_id and client_secret")
+		}
+
+		auth := datahub.NewClientCredentialsAuth(a.TokenURL, a.ClientID, a.ClientSecret, a.Scopes...)
+		auth.Audience = a.Audience
+		auth.CacheFile = a.TokenCache
+		return auth, nil
+	}
+
+	return nil, errors.New("datahub_auth: unrecognized authentication type \"" + a.Type + "\" (expected api_key, basic or oauth2)")
+}
+
+// Transport creates the HTTP transport used for Datahub requests.
+func (t *TLSConfiguration) Transport(proxy *ProxyConfiguration) (*http.Transport, error) {
+	var opts *datahub.TLSOptions
+	if t != nil {
+		opts = &datahub.TLSOptions{
+			CAFile:             t.CAFile,
+			CertFile:           t.CertFile,
+			KeyFile:            t.KeyFile,
+			ServerName:         t.ServerName,
+			InsecureSkipVerify: t.Insecure,
+		}
+	}
+
+	var proxyopts *datahub.ProxyOptions
+	if proxy != nil {
+		proxyopts = &datahub.ProxyOptions{URL: proxy.URL, NoProxy: proxy.NoProxy}
+	}
+
+	return datahub.NewTransport(opts, proxyopts)
+}
@@ -0,0 +1,185 @@
+package command
+
+import (
+	"bufio"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigCommand groups the configuration commands.
+type ConfigCommand struct {
+	Validate ValidateConfig `cmd:"validate" help:"Check a configuration file: unknown settings, invalid values and profiles"`
+}
+
+type ValidateConfig struct {
+	Config  string `name:"config" short:"c" type:"string" help:"Configuration file to check." default:"./dh-config.yml"`
+	Secrets bool   `name:"secrets" help:"Also read the secrets (environment variables, files and commands)."`
+}
+
+// Run checks the configuration file with --profile, or without a profile and
+// with each of its profiles. The environment variables are only resolved with
+// --secrets, so a file can be checked where they are not set. The outcome is
+// printed on the standard output.
+func (v *ValidateConfig) Run(ctx *Context) error {
+	path := configFile(v.Config)
+	profiles := []string{Root.Profile}
+	if Root.Profile == util.EmptyString {
+		names, err := Profiles(path)
+		if err != nil {
+			fmt.Printf("✗ %v: %v\n", path, err)
+			return withExitCode(ExitConfig, err)
+		}
+		profiles = append(profiles, names...)
+	}
+
+	var failure error
+	for _, profile := range profiles {
+		label := path
+		if profile != util.EmptyString {
+			label = fmt.Sprintf("%v (profile %v)", path, profile)
+		}
+
+		cfg, err := loadConfiguration(path, profile, v.Secrets)
+		if err != nil {
+			failure = err
+			fmt.Printf("✗ %v\n", label)
+
+			var configErr *ConfigError
+			if errors.As(err, &configErr) {
+				for _, problem := range configErr.Problems {
+					fmt.Printf("    %v\n", problem)
+				}
+			} else {
+				fmt.Printf("    %v\n", err)
+			}
+			continue
+		}
+
+		fmt.Printf("✓ %v\n", label)
+		for _, name := range cfg.Names() {
+			src, err := cfg.lookup(name)
+			if err == nil && v.Secrets {
+				_, err = cfg.Source(name)
+			}
+
+			prefix := util.EmptyString
+			if name != util.EmptyString {
+				prefix = name + ": "
+			}
+
+			if err != nil {
+				failure = err
+				fmt.Printf("    %v%v\n", prefix, err)
+				continue
+			}
+
+			for _, setting := range cfg.inherit(src).missing() {
+				fmt.Printf("    %vwarning: %v is not set (it must be given on the command line)\n", prefix, setting)
+			}
+		}
+	}
+
+	if failure != nil {
+		return withExitCode(ExitConfig, failure)
+	}
+
+	return nil
+}
+
+type Init struct {
+	Config string `name:"config" short:"c" type:"path" help:"Configuration file to write." default:"./dh-config.yml"`
+	Force  bool   `name:"force" help:"Overwrite the configuration file when it exists."`
+}
+
+// Run asks for the main settings and writes a starter configuration file.
+// Secrets are referenced as environment variables.
+func (i *Init) Run(ctx *Context) error {
+	if _, err := os.Stat(i.Config); err == nil && !i.Force {
+		err := fmt.Errorf("%v already exists (use --force to overwrite it)", i.Config)
+		fmt.Println(err)
+		return withExitCode(ExitConfig, err)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	ask := func(question string, defaultValue string) string {
+		if defaultValue != util.EmptyString {
+			fmt.Printf("%v [%v]: ", question, defaultValue)
+		} else {
+			fmt.Printf("%v: ", question)
+		}
+
+		answer, err := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err == io.EOF && answer == util.EmptyString {
+			fmt.Println()
+		}
+
+		if answer == util.EmptyString {
+			return defaultValue
+		}
+
+		return answer
+	}
+
+	dbtype := ask("Database type (postgresql or greenplum)", "postgresql")
+	host := ask("Database host and port", "localhost:5432")
+	database := ask("Database name", "postgres")
+	user := ask("Database user", "postgres")
+	password := ask("Environment variable with the database password", "DB_PASSWORD")
+	schemas := ask("Schemas to sync (comma-separated)", "public")
+	url := ask("Datahub URL", "http://localhost:8080")
+	source := ask("Datahub data source", database)
+	apikey := ask("Environment variable with the Datahub API key (- for none)", "DATAHUB_API_KEY")
+	policy := ask("Deletion policy (delete, deprecate or ignore)", "deprecate")
+
+	list := []string{}
+	for _, schema := range strings.Split(schemas, ",") {
+		if schema = strings.TrimSpace(schema); schema != util.EmptyString {
+			list = append(list, quote(schema))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# dh-util configuration (see dh-util config validate).\n")
+	fmt.Fprintf(&b, "type: %v\n", quote(dbtype))
+	fmt.Fprintf(&b, "host: %v\n", quote(host))
+	fmt.Fprintf(&b, "database: %v\n", quote(database))
+	fmt.Fprintf(&b, "user: %v\n", quote(user))
+	fmt.Fprintf(&b, "password: ${%v}\n", password)
+	fmt.Fprintf(&b, "schemas: [%v]\n", strings.Join(list, ", "))
+	fmt.Fprintf(&b, "\ndatahub_url: %v\n", quote(url))
+	fmt.Fprintf(&b, "datahub_source: %v\n", quote(source))
+	if apikey != "-" && apikey != util.EmptyString {
+		fmt.Fprintf(&b, "api_key: ${%v}\n", apikey)
+	}
+	fmt.Fprintf(&b, "deletion_policy: %v\n", quote(policy))
+	fmt.Fprintf(&b, "\n# Profiles override the settings above: dh-util --profile prod sync\n")
+	fmt.Fprintf(&b, "profiles:\n  prod:\n    host: %v\n", quote(host))
+
+	if err := os.WriteFile(i.Config, []byte(b.String()), 0600); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("\nWrote %v. Next steps:\n", i.Config)
+	fmt.Printf("  export %v=...\n", password)
+	if apikey != "-" && apikey != util.EmptyString {
+		fmt.Printf("  export %v=...\n", apikey)
+	}
+	fmt.Printf("  dh-util config validate --config %v\n", i.Config)
+	fmt.Printf("  dh-util sync --config %v --dryrun\n", i.Config)
+
+	return nil
+}
+
+// quote writes a value as a YAML scalar.
+func quote(value string) string {
+	data, _ := yaml.Marshal(value)
+	return strings.TrimSpace(string(data))
+}
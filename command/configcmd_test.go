@@ -0,0 +1,30 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateConfigLeavesTheVariables(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "dh-config.yml")
+	data := `type: postgresql
+host: localhost:5432
+database: postgres
+user: postgres
+password: ${DHS_TEST_UNDEFINED}
+datahub_url: http://datahub.invalid
+datahub_source: public
+`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&ValidateConfig{Config: config}).Run(&Context{}); err != nil {
+		t.Errorf("the structure is valid: %v", err)
+	}
+
+	if err := (&ValidateConfig{Config: config, Secrets: true}).Run(&Context{}); err == nil {
+		t.Error("--secrets resolves the undefined variable")
+	}
+}
@@ -1,15 +1,33 @@
 package command
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"strings"
 )
 
+// ExitInterrupted is the exit code of a command stopped by SIGINT or SIGTERM.
+const ExitInterrupted = 130
+
+// Context is passed to every command. Its context.Context is cancelled when
+// the process receives SIGINT or SIGTERM.
 type Context struct {
+	context.Context
 	Debug bool
+	// Level is the level of the default logger (see Logging).
+	Level *slog.LevelVar
 	meta  map[string]interface{}
 }
 
+// Debugging lowers the level of the default logger to debug.
+func (c *Context) Debugging() {
+	c.Debug = true
+	if c.Level != nil {
+		c.Level.Set(slog.LevelDebug)
+	}
+}
+
 func (c *Context) Set(name string, value interface{}) {
 	if c.meta == nil {
 		c.meta = make(map[string]interface{})
@@ -0,0 +1,164 @@
+package command
+
+import (
+	"context"
+	"dhs/archive"
+	"dhs/extractor"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DoctorTimeout limits the duration of the database checks of a source.
+var DoctorTimeout = 30 * time.Second
+
+type Doctor struct {
+	Config           string   `name:"config" short:"c" type:"string" help:"Configuration file in YAML or JSON (ignored when a connection string is supplied)." default:"./dh-config.yml"`
+	Schemas          []string `name:"schemas" short:"s" type:"string" help:"List of source schemas to check."`
+	Source           string   `name:"datahub_source" short:"i" type:"string" help:"Name or ID of the Datahub data source."`
+	DatahubURL       string   `name:"url" short:"u" help:"URL of the Datahub API"`
+	APIKey           string   `name:"api_key" short:"k" help:"Optional API key to access the Datahub"`
+	Sources          []string `name:"source" help:"Check these sources of a multi-source configuration file."`
+	All              bool     `name:"all" help:"Check every source of a multi-source configuration file."`
+	Archive          string   `name:"archive" type:"path" help:"SQLite archive to check (default ./datahub-sync.db, or ./datahub-sync-<source>.db for the sources of a multi-source configuration)."`
+	ConnectionString string   `arg:"conn" optional:"" help:"The source connection string"`
+}
+
+// Run checks everything a sync needs before it starts (the configuration,
+// the database privileges, the Datahub credentials and permissions, and the
+// archive), and prints a checklist with the fixes of the failed checks.
+func (d *Doctor) Run(ctx *Context) error {
+	e := &Extractor{
+		Config:           d.Config,
+		Schemas:          d.Schemas,
+		Source:           d.Source,
+		DatahubURL:       d.DatahubURL,
+		APIKey:           d.APIKey,
+		Archive:          d.Archive,
+		ConnectionString: d.ConnectionString,
+	}
+
+	if !d.All && len(d.Sources) == 0 {
+		code := d.diagnose(ctx, e)
+		return d.outcome(code)
+	}
+
+	e.Config = configFile(e.Config)
+	cfg, err := LoadConfiguration(e.Config, e.profile())
+	if err != nil {
+		fmt.Printf("✗ load the configuration: %v\n", err)
+		return withExitCode(ExitConfig, err)
+	}
+
+	names := d.Sources
+	if d.All {
+		names = cfg.Names()
+	}
+
+	code := ExitSuccess
+	for i, name := range names {
+		if i > 0 {
+			fmt.Println()
+		}
+		code = worst(code, d.diagnose(ctx, e.source(name)))
+	}
+
+	return d.outcome(code)
+}
+
+// diagnose prints the checklist of a source and returns the exit code of the
+// most severe failure: ExitConfig, ExitExtraction (database), ExitDatahub or
+// ExitFailure (archive).
+func (d *Doctor) diagnose(ctx *Context, e *Extractor) int {
+	if e.Name != util.EmptyString {
+		fmt.Printf("Source %v\n", e.Name)
+	}
+
+	code := ExitSuccess
+	report := func(failure int, checks ...*extractor.Check) {
+		for _, check := range checks {
+			printCheck(check)
+			if check.Failed() {
+				code = worst(code, failure)
+			}
+		}
+	}
+
+	configuration := &extractor.Check{Name: "load the configuration", Detail: "connection string"}
+	if e.ConnectionString == util.EmptyString {
+		configuration.Detail = configFile(e.Config)
+	}
+	configuration.Err = e.configure()
+	if configuration.Err == nil {
+		scheme := strings.ToLower(strings.Split(e.ConnectionString, ":")[0])
+		if !util.InSlice[string](scheme, []string{"postgres", "postgresql", "greenplum"}) {
+			configuration.Err = fmt.Errorf("unsupported connection string scheme %q (expected postgresql or greenplum)", scheme)
+		}
+	}
+	if configuration.Err != nil {
+		configuration.Hint = "run dh-util config validate, or check the connection string"
+		report(ExitConfig, configuration)
+		return code
+	}
+	report(ExitConfig, configuration)
+
+	if checker, ok := e.extractor().(extractor.Checker); ok {
+		timeout, cancel := context.WithTimeout(ctx, DoctorTimeout)
+		report(ExitExtraction, checker.Check(timeout)...)
+		cancel()
+	}
+
+	dh, err := e.client(nil)
+	if err != nil {
+		report(ExitConfig, &extractor.Check{Name: "configure the Datahub client", Err: err, Hint: "check the datahub_auth, datahub_tls and datahub_proxy settings"})
+	} else {
+		dh.SetContext(ctx)
+		report(ExitDatahub, dh.Check()...)
+	}
+
+	path := e.archive()
+	check := &extractor.Check{Name: "open the archive", Detail: path}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		check.Detail = path + " (created by the first sync)"
+	} else if check.Err = archive.Verify(path); check.Err != nil {
+		check.Hint = "deliver or list the queued changes first (dh-util flush), then move the archive aside so the next sync rebuilds it"
+	}
+	report(ExitFailure, check)
+
+	return code
+}
+
+func (d *Doctor) outcome(code int) error {
+	if code == ExitSuccess {
+		fmt.Println("\nEverything is ready to sync.")
+		return nil
+	}
+
+	fmt.Println("\nSome checks failed.")
+	return withExitCode(code, errors.New("some checks failed"))
+}
+
+// printCheck prints a line of the checklist: ✓ passed, ! warning and ✗
+// failed, followed by the fix.
+func printCheck(check *extractor.Check) {
+	mark, message := "✓", check.Detail
+	if check.Err != nil {
+		mark, message = "✗", check.Err.Error()
+		if check.Warning {
+			mark = "!"
+		}
+	}
+
+	if message != util.EmptyString {
+		fmt.Printf("  %v %v: %v\n", mark, check.Name, message)
+	} else {
+		fmt.Printf("  %v %v\n", mark, check.Name)
+	}
+
+	if check.Err != nil && check.Hint != util.EmptyString {
+		fmt.Printf("      fix: %v\n", check.Hint)
+	}
+}
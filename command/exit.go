@@ -0,0 +1,88 @@
+package command
+
+import (
+	"context"
+	"dhs/pipeline"
+	"errors"
+	"fmt"
+)
+
+// Exit codes, so schedulers and orchestrators can branch on the outcome of a
+// command.
+const (
+	ExitSuccess = 0
+	// ExitFailure is any other failure.
+	ExitFailure = 1
+	// ExitConfig is an invalid configuration or command line.
+	ExitConfig = 2
+	// ExitExtraction is a failure to read or stash the source metadata.
+	ExitExtraction = 3
+	// ExitDatahub is a failure to reach, read or plan against the Datahub.
+	ExitDatahub = 4
+	// ExitPartial is a commit where some changes failed or were queued in
+	// the outbox.
+	ExitPartial = 5
+	// ExitChanges is a successful sync that committed (or, in a dry run,
+	// found) changes. It is only used with --detailed-exitcode.
+	ExitChanges = 6
+)
+
+// ExitError is an error with the exit code of the process.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %v", e.Code)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return err
+	}
+
+	return &ExitError{Code: code, Err: err}
+}
+
+// ExitCode returns the exit code of the process for the error returned by a
+// command.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+
+	var stageErr *pipeline.StageError
+	if errors.As(err, &stageErr) {
+		switch stageErr.Stage {
+		case pipeline.Extract, pipeline.Stash:
+			return ExitExtraction
+		default:
+			return ExitDatahub
+		}
+	}
+
+	return ExitFailure
+}
@@ -0,0 +1,93 @@
+package command
+
+import (
+	"dhs/archive"
+	"dhs/util"
+	"fmt"
+	"log/slog"
+)
+
+type Flush struct {
+	Config     string `name:"config" short:"c" type:"string" help:"Configuration file with the Datahub connection settings." default:"./dh-config.yml"`
+	Source     string `name:"datahub_source" short:"i" type:"string" help:"Only flush the changes queued for this Datahub data source (name or ID)."`
+	DatahubURL string `name:"url" short:"u" help:"URL of the Datahub API"`
+	APIKey     string `name:"api_key" short:"k" help:"Optional API key to access the Datahub"`
+	List       bool   `name:"list" short:"l" help:"List the queued changes without delivering them."`
+	Archive    string `name:"archive" type:"path" default:"./datahub-sync.db" help:"SQLite archive holding the outbox (./datahub-sync-<source>.db for the sources of a multi-source configuration)."`
+}
+
+func (f *Flush) Run(ctx *Context) error {
+	cache, err := archive.Open(f.Archive)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	ops, err := cache.Outbox()
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	if len(ops) == 0 {
+		slog.Info("The outbox is empty.")
+		return nil
+	}
+
+	if f.List {
+		fmt.Printf("%v change(s) queued in the outbox:\n", len(ops))
+		for _, op := range ops {
+			fmt.Printf("  #%v  %v  %v (source %v, %v attempt(s))\n", op.ID, op.Created.Format("2006-01-02 15:04:05"), op, op.Source, op.Attempts)
+			if op.Error != util.EmptyString {
+				fmt.Printf("        last error: %v\n", op.Error)
+			}
+		}
+		return nil
+	}
+
+	e := &Extractor{Config: f.Config, Source: f.Source, DatahubURL: f.DatahubURL, APIKey: f.APIKey}
+	if err := e.datahubSettings(); err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	// Without a specific source, every source with queued changes is flushed.
+	sources := []string{f.Source}
+	if f.Source == util.EmptyString {
+		sources = []string{}
+		for _, op := range ops {
+			if !util.InSlice[string](op.Source, sources) {
+				sources = append(sources, op.Source)
+			}
+		}
+	}
+
+	var failure error
+	for _, source := range sources {
+		e.Source = source
+		slog.Info("Flushing the outbox...", "source", source)
+
+		dh, err := e.connect(cache)
+		if err == nil {
+			dh.SetContext(ctx)
+			err = dh.Flush()
+		}
+
+		if err == nil && dh.Results().Failed > 0 {
+			err = withExitCode(ExitPartial, fmt.Errorf("%v queued change(s) were rejected by the Datahub", dh.Results().Failed))
+		}
+
+		if err != nil {
+			slog.Error(err.Error())
+			failure = err
+		} else {
+			slog.Info(fmt.Sprintf("delivered %v change(s)", dh.Results().Succeeded), "source", source, "succeeded", dh.Results().Succeeded)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return failure
+}
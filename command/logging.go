@@ -0,0 +1,56 @@
+package command
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Logging configures the logs of every command. They are written to the
+// standard error unless a log file is specified.
+type Logging struct {
+	Format string `name:"log-format" enum:"text,json" default:"text" help:"Log format: text (key=value pairs) or json."`
+	Level  string `name:"log-level" enum:"debug,info,warn,error" default:"info" help:"Minimum level of the logs: debug, info, warn or error."`
+	File   string `name:"log-file" type:"path" help:"Append the logs to this file instead of the standard error."`
+}
+
+// Logger creates the logger described by the flags. Its level is controlled
+// by level, so commands can lower it (i.e. --debug). The returned closer
+// closes the log file.
+func (l *Logging) Logger(level *slog.LevelVar) (*slog.Logger, io.Closer, error) {
+	switch strings.ToLower(l.Level) {
+	case "debug":
+		level.Set(slog.LevelDebug)
+	case "warn":
+		level.Set(slog.LevelWarn)
+	case "error":
+		level.Set(slog.LevelError)
+	default:
+		level.Set(slog.LevelInfo)
+	}
+
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if l.File != "" {
+		file, err := os.OpenFile(l.File, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = file
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(l.Format) == "json" {
+		return slog.New(slog.NewJSONHandler(out, options)), out, nil
+	}
+
+	return slog.New(slog.NewTextHandler(out, options)), out, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
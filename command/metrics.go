@@ -0,0 +1,134 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metrics are the Prometheus metrics of the serve command, by source. They
+// are exposed in the Prometheus text format.
+type metrics struct {
+	mu      sync.Mutex
+	sources map[string]*sourceMetrics
+}
+
+type sourceMetrics struct {
+	runs        map[string]int
+	duration    time.Duration
+	lastRun     time.Time
+	lastSuccess time.Time
+	nextRun     time.Time
+	exitCode    int
+	changed     int
+	apiErrors   int
+	running     bool
+}
+
+func newMetrics(sources ...string) *metrics {
+	m := &metrics{sources: make(map[string]*sourceMetrics)}
+	for _, source := range sources {
+		m.source(source)
+	}
+
+	return m
+}
+
+// source returns the metrics of a source. The caller holds the lock.
+func (m *metrics) source(name string) *sourceMetrics {
+	if _, ok := m.sources[name]; !ok {
+		m.sources[name] = &sourceMetrics{runs: make(map[string]int)}
+	}
+
+	return m.sources[name]
+}
+
+func (m *metrics) update(name string, fn func(s *sourceMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(m.source(name))
+}
+
+// outcome names the exit code of a sync in the dhs_sync_runs_total metric.
+func outcome(code int) string {
+	switch code {
+	case ExitSuccess, ExitChanges:
+		return "success"
+	case ExitConfig:
+		return "config_error"
+	case ExitExtraction:
+		return "extraction_error"
+	case ExitDatahub:
+		return "datahub_error"
+	case ExitPartial:
+		return "partial"
+	case ExitInterrupted:
+		return "interrupted"
+	default:
+		return "error"
+	}
+}
+
+func (m *metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.sources))
+	for name := range m.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var written int64
+	write := func(format string, args ...interface{}) {
+		n, _ := fmt.Fprintf(w, format, args...)
+		written += int64(n)
+	}
+
+	metric := func(name string, help string, kind string, value func(s *sourceMetrics) float64) {
+		write("# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+		for _, source := range names {
+			write("%v{source=%q} %v\n", name, source, strconv.FormatFloat(value(m.sources[source]), 'f', -1, 64))
+		}
+	}
+
+	write("# HELP dhs_sync_runs_total Number of syncs by outcome.\n# TYPE dhs_sync_runs_total counter\n")
+	for _, source := range names {
+		outcomes := make([]string, 0, len(m.sources[source].runs))
+		for outcome := range m.sources[source].runs {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+
+		for _, outcome := range outcomes {
+			write("dhs_sync_runs_total{source=%q,outcome=%q} %v\n", source, outcome, m.sources[source].runs[outcome])
+		}
+	}
+
+	metric("dhs_sync_duration_seconds", "Duration of the last sync.", "gauge", func(s *sourceMetrics) float64 { return s.duration.Seconds() })
+	metric("dhs_sync_last_run_timestamp_seconds", "Time the last sync finished (0 before the first sync).", "gauge", func(s *sourceMetrics) float64 { return unix(s.lastRun) })
+	metric("dhs_sync_last_success_timestamp_seconds", "Time the last successful sync finished (0 before the first success).", "gauge", func(s *sourceMetrics) float64 { return unix(s.lastSuccess) })
+	metric("dhs_sync_next_run_timestamp_seconds", "Time the next scheduled sync starts (0 when it is not scheduled).", "gauge", func(s *sourceMetrics) float64 { return unix(s.nextRun) })
+	metric("dhs_sync_last_exit_code", "Exit code of the last sync.", "gauge", func(s *sourceMetrics) float64 { return float64(s.exitCode) })
+	metric("dhs_sync_running", "Whether a sync is running (1) or not (0).", "gauge", func(s *sourceMetrics) float64 {
+		if s.running {
+			return 1
+		}
+		return 0
+	})
+	metric("dhs_sync_objects_changed_total", "Number of sets, items and relationships changed in the Datahub.", "counter", func(s *sourceMetrics) float64 { return float64(s.changed) })
+	metric("dhs_sync_api_errors_total", "Number of Datahub errors: changes the Datahub rejected and syncs that failed to reach or read it.", "counter", func(s *sourceMetrics) float64 { return float64(s.apiErrors) })
+
+	return written, nil
+}
+
+func unix(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixNano()) / 1e9
+}
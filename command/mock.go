@@ -0,0 +1,43 @@
+package command
+
+import (
+	"dhs/extractor/datahub/datahubtest"
+	"log/slog"
+)
+
+type MockServer struct {
+	Address  string   `name:"address" short:"a" default:"localhost:8080" help:"Address the mock Datahub listens on."`
+	State    string   `name:"state" short:"f" type:"string" help:"JSON file used to load and persist the mock catalog. The catalog is kept in memory when omitted."`
+	Sources  []string `name:"source" short:"i" type:"string" help:"Data source(s) to create in the mock catalog on startup."`
+	APIKey   string   `name:"api_key" short:"k" help:"Require this API key (as a bearer token) on every request."`
+	User     string   `name:"user" help:"Require these credentials (basic auth) to obtain a token from /token."`
+	Password string   `name:"password" help:"Password for the --user credentials."`
+	ClientID string   `name:"client_id" help:"Require these OAuth2 client credentials to obtain a token from /oauth/token."`
+	Secret   string   `name:"client_secret" help:"Client secret for the --client_id credentials."`
+}
+
+func (m *MockServer) Run(ctx *Context) error {
+	server, err := datahubtest.New(m.State)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	server.APIKey = m.APIKey
+	server.User = m.User
+	server.Password = m.Password
+	server.ClientID = m.ClientID
+	server.ClientSecret = m.Secret
+
+	for _, name := range m.Sources {
+		src := server.AddSource(name)
+		slog.Info("created source", "source", src.Name.Physical, "id", src.ID)
+	}
+
+	slog.Info("Mock Datahub listening", "url", "http://"+m.Address)
+	if m.State != "" {
+		slog.Info("persisting the catalog", "file", m.State)
+	}
+
+	return server.ListenAndServe(m.Address)
+}
@@ -4,6 +4,16 @@ package command
 import "github.com/alecthomas/kong"
 
 var Root struct {
-	Sync    Extractor        `cmd:"sync" short:"s" help:"Synchronize metadata from a data source with the Datahub"`
-	Version kong.VersionFlag `name:"version" short:"v" help:"Display the version of the application."`
+	Sync       Extractor        `cmd:"sync" short:"s" help:"Synchronize metadata from a data source with the Datahub"`
+	Doctor     Doctor           `cmd:"doctor" help:"Check the configuration, database privileges, Datahub credentials and archive before a sync"`
+	Flush      Flush            `cmd:"flush" help:"Deliver the changes queued in the outbox while the Datahub was unreachable"`
+	Backup     Backup           `cmd:"backup" help:"Back up a Datahub data source (sets, items, relationships and their documentation) to a JSON file"`
+	Restore    Restore          `cmd:"restore" help:"Restore a backup into a Datahub data source"`
+	Config     ConfigCommand    `cmd:"config" help:"Check the configuration"`
+	Init       Init             `cmd:"init" help:"Write a starter configuration file interactively"`
+	Serve      Serve            `cmd:"serve" help:"Sync sources on cron schedules and serve Prometheus metrics, health checks and sync triggers"`
+	MockServer MockServer       `cmd:"mock-server" help:"Run a local, in-memory mock of the Datahub API for testing and demos"`
+	Version    kong.VersionFlag `name:"version" short:"v" help:"Display the version of the application."`
+	Profile    string           `name:"profile" env:"DH_PROFILE" help:"Apply this profile of the configuration file (i.e. dev, staging or prod)."`
+	Logging    Logging          `embed:""`
 }
@@ -0,0 +1,229 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"dhs/util"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SecretCommandTimeout limits the duration of the password_command helpers.
+var SecretCommandTimeout = 30 * time.Second
+
+const redacted = "REDACTED"
+
+var variable = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate replaces the ${VAR} references of the values of a configuration
+// file with the environment variables. ${VAR:-default} provides a default
+// value, and $$ is a literal $. A reference to an undefined variable without
+// a default is an error. The file is parsed first, so the values can hold any
+// character and the comments are left alone.
+func interpolate(node *yaml.Node) error {
+	var missing []string
+	substitute(node, &missing)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid configuration: undefined environment variable(s) %v", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// substitute interpolates the scalar values of a node, but not the keys of
+// the mappings.
+func substitute(node *yaml.Node, missing *[]string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			substitute(node.Content[i], missing)
+		}
+	case yaml.ScalarNode:
+		value := variable.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+
+			groups := variable.FindStringSubmatch(match)
+			if value, ok := os.LookupEnv(groups[1]); ok {
+				return value
+			}
+
+			if groups[2] != util.EmptyString {
+				return groups[3]
+			}
+
+			if !util.InSlice[string](groups[1], *missing) {
+				*missing = append(*missing, groups[1])
+			}
+			return match
+		})
+
+		if value != node.Value {
+			node.Value = value
+			// The type of a plain value is the type of the variable, i.e.
+			// port: ${DB_PORT} is a number.
+			if node.Style == 0 {
+				node.Tag = util.EmptyString
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			substitute(child, missing)
+		}
+	}
+}
+
+// shell runs a helper command with the shell of the platform.
+func shell(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// secret resolves a secret setting, which is given by value, read from a file
+// (name_file) or printed by a helper command (name_command), i.e. a vault CLI.
+func secret(name string, value string, file string, command string) (string, error) {
+	set := 0
+	for _, option := range []string{value, file, command} {
+		if option != util.EmptyString {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return "", fmt.Errorf("invalid configuration: set only one of %v, %v_file and %v_command", name, name, name)
+	}
+
+	switch {
+	case file != util.EmptyString:
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("%v_file: %w", name, err)
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case command != util.EmptyString:
+		ctx, cancel := context.WithTimeout(context.Background(), SecretCommandTimeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := shell(ctx, command)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			if output := strings.TrimSpace(stderr.String()); output != util.EmptyString {
+				return "", fmt.Errorf("%v_command failed: %w: %v", name, err, output)
+			}
+			return "", fmt.Errorf("%v_command failed: %w", name, err)
+		}
+
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	}
+
+	return value, nil
+}
+
+// resolveSecrets reads the secrets given as files or commands.
+func (c *ExtractorConfiguration) resolveSecrets() error {
+	var err error
+	if c.Password, err = secret("password", c.Password, c.PassFile, c.PassCmd); err != nil {
+		return err
+	}
+
+	if c.APIKey, err = secret("api_key", c.APIKey, c.APIKeyFile, c.APIKeyCmd); err != nil {
+		return err
+	}
+
+	return c.Auth.resolveSecrets()
+}
+
+func (a *AuthConfiguration) resolveSecrets() error {
+	var err error
+	if a.Password, err = secret("datahub_auth: password", a.Password, a.PasswordFile, a.PasswordCommand); err != nil {
+		return err
+	}
+
+	if a.ClientSecret, err = secret("datahub_auth: client_secret", a.ClientSecret, a.ClientSecretFile, a.ClientSecretCommand); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LogValue redacts the secrets when the settings are logged (i.e. --debug).
+func (e *Extractor) LogValue() slog.Value {
+	settings := *e
+	if settings.APIKey != util.EmptyString {
+		settings.APIKey = redacted
+	}
+	settings.ConnectionString = redactPassword(settings.ConnectionString)
+
+	if settings.Auth != nil {
+		auth := *settings.Auth
+		if auth.Password != util.EmptyString {
+			auth.Password = redacted
+		}
+		if auth.ClientSecret != util.EmptyString {
+			auth.ClientSecret = redacted
+		}
+		settings.Auth = &auth
+	}
+
+	if settings.Proxy != nil {
+		proxy := *settings.Proxy
+		proxy.URL = redactPassword(proxy.URL)
+		settings.Proxy = &proxy
+	}
+
+	// The settings are logged as a map, so the JSON and text handlers show
+	// the values rather than the pointers.
+	values := map[string]interface{}{}
+	data, _ := json.Marshal(settings)
+	json.Unmarshal(data, &values)
+
+	return slog.AnyValue(values)
+}
+
+// passwordParameter matches the password parameters of a URL query, i.e.
+// ?sslmode=require&password=secret.
+var passwordParameter = regexp.MustCompile(`(?i)([?&][a-z_]*password=)[^&#]*`)
+
+// redactPassword hides the password of a URL, i.e. a connection string, given
+// as user information or as a query parameter.
+func redactPassword(uri string) string {
+	uri = passwordParameter.ReplaceAllString(uri, "${1}"+redacted)
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		// Hide everything between the scheme and the host.
+		base, at := strings.Index(uri, "://"), strings.LastIndex(uri, "@")
+		if base >= 0 && at > base {
+			return uri[:base+3] + redacted + uri[at:]
+		}
+
+		return uri
+	}
+
+	if parsed.User == nil {
+		return uri
+	}
+
+	if _, ok := parsed.User.Password(); !ok {
+		return uri
+	}
+	parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+
+	return parsed.String()
+}
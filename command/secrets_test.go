@@ -0,0 +1,77 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigurationInterpolatesValues(t *testing.T) {
+	t.Setenv("DHS_TEST_PASSWORD", "*s3cr3t: #1 {x}")
+	t.Setenv("DHS_TEST_HOST", "db.local")
+	t.Setenv("DHS_TEST_MAX", "25")
+
+	config := filepath.Join(t.TempDir(), "dh-config.yml")
+	data := `# ${DHS_TEST_UNDEFINED} is only mentioned in a comment
+type: postgresql
+host: ${DHS_TEST_HOST}
+database: ${DHS_TEST_DATABASE:-catalog}
+user: "$$user"
+password: ${DHS_TEST_PASSWORD} # the database password
+max: ${DHS_TEST_MAX}
+datahub_url: http://datahub.invalid
+datahub_source: public
+`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfiguration(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := cfg.Sources[0]
+	if src.Password != "*s3cr3t: #1 {x}" || src.Host != "db.local" || src.Database != "catalog" || src.User != "$user" || src.Max != 25 {
+		t.Errorf("password = %q, host = %q, database = %q, user = %q, max = %v", src.Password, src.Host, src.Database, src.User, src.Max)
+	}
+}
+
+func TestLoadConfigurationReportsUndefinedVariables(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "dh-config.yml")
+	if err := os.WriteFile(config, []byte("type: postgresql\nhost: ${DHS_TEST_UNDEFINED}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfiguration(config, ""); err == nil || !strings.Contains(err.Error(), "DHS_TEST_UNDEFINED") {
+		t.Errorf("err = %v, want the undefined variable", err)
+	}
+}
+
+func TestSecretCommand(t *testing.T) {
+	value, err := secret("password", "", "", "echo s3cr3t")
+	if err != nil || value != "s3cr3t" {
+		t.Errorf("value = %q, err = %v", value, err)
+	}
+}
+
+func TestRedactPassword(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"postgres://user:secret@db:5432/catalog", "postgres://user:REDACTED@db:5432/catalog"},
+		{"postgres://user@db/catalog", "postgres://user@db/catalog"},
+		{"postgres://db/catalog?user=dhs&password=secret", "postgres://db/catalog?user=dhs&password=REDACTED"},
+		{"postgres://db/catalog?Password=secret&sslmode=require", "postgres://db/catalog?Password=REDACTED&sslmode=require"},
+		{"postgres://db/catalog?sslmode=verify-full&sslpassword=secret", "postgres://db/catalog?sslmode=verify-full&sslpassword=REDACTED"},
+		{"http://proxy:3128", "http://proxy:3128"},
+	}
+
+	for _, test := range tests {
+		if got := redactPassword(test.uri); got != test.want {
+			t.Errorf("redactPassword(%q) = %q, want %q", test.uri, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package command
+
+import (
+	"context"
+	"dhs/pipeline"
+	"dhs/schedule"
+	"dhs/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Serve struct {
+	Config  string `name:"config" short:"c" type:"string" help:"Configuration file listing the sources to sync and their schedules." default:"./dh-serve.yml"`
+	Address string `name:"address" short:"a" help:"Address of the HTTP server with the /metrics, /healthz and /sync/<source> endpoints (default: the listen setting or localhost:9090)."`
+}
+
+// ServeConfiguration lists the sources synced by the serve command.
+type ServeConfiguration struct {
+	Listen  string            `yaml:"listen"`
+	Sources []ScheduledSource `yaml:"sources"`
+}
+
+// ScheduledSource is a source synced by the serve command: the sync
+// configuration file (like the sync command's --config), the source of a
+// multi-source configuration (default: the name), the profile (default:
+// --profile) and the cron schedule. A source without a schedule is only
+// synced on demand.
+type ScheduledSource struct {
+	Name     string `yaml:"name"`
+	Config   string `yaml:"config"`
+	Source   string `yaml:"source"`
+	Profile  string `yaml:"profile"`
+	Schedule string `yaml:"schedule"`
+	Report   string `yaml:"report"`
+	schedule schedule.Schedule
+}
+
+// LoadServeConfiguration reads and checks the serve configuration file.
+func LoadServeConfiguration(path string) (*ServeConfiguration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := interpolate(&document); err != nil {
+		return nil, err
+	}
+
+	cfg := &ServeConfiguration{}
+	if len(document.Content) > 0 {
+		if err := document.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+
+	if len(cfg.Sources) == 0 {
+		return nil, errors.New("invalid configuration: no sources to serve")
+	}
+
+	names := []string{}
+	for i := range cfg.Sources {
+		src := &cfg.Sources[i]
+		if src.Name == util.EmptyString || strings.Contains(src.Name, "/") {
+			return nil, fmt.Errorf("invalid configuration: source #%v needs a name (without /)", i+1)
+		}
+
+		if util.InSlice[string](src.Name, names) {
+			return nil, fmt.Errorf("invalid configuration: duplicate source %q", src.Name)
+		}
+		names = append(names, src.Name)
+
+		if src.Config == util.EmptyString {
+			return nil, fmt.Errorf("invalid configuration: source %q needs a config file", src.Name)
+		}
+
+		if src.Schedule != util.EmptyString {
+			if src.schedule, err = schedule.Parse(src.Schedule); err != nil {
+				return nil, fmt.Errorf("invalid configuration: source %q: %w", src.Name, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Run syncs the sources on their schedules until the process is interrupted.
+// The sources sync in parallel, unless they share an archive.
+func (s *Serve) Run(ctx *Context) error {
+	cfg, err := LoadServeConfiguration(s.Config)
+	if err != nil {
+		slog.Error(err.Error())
+		return withExitCode(ExitConfig, err)
+	}
+
+	address := s.Address
+	if address == util.EmptyString {
+		address = cfg.Listen
+	}
+	if address == util.EmptyString {
+		address = "localhost:9090"
+	}
+
+	d := &daemon{
+		ctx:      ctx,
+		sources:  make(map[string]*ScheduledSource),
+		pending:  make(map[string]bool),
+		archives: make(map[string]*sync.Mutex),
+	}
+	names := []string{}
+	for i := range cfg.Sources {
+		d.sources[cfg.Sources[i].Name] = &cfg.Sources[i]
+		names = append(names, cfg.Sources[i].Name)
+	}
+	d.metrics = newMetrics(names...)
+
+	server := &http.Server{Addr: address, Handler: d.handler()}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	slog.Info("serving", "url", "http://"+address, "sources", len(cfg.Sources))
+
+	for _, src := range cfg.Sources {
+		if src.schedule != nil {
+			go d.schedule(src.Name)
+		}
+	}
+
+	select {
+	case err := <-failed:
+		slog.Error(err.Error())
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting triggers, then wait for the sync in flight (which
+	// queues its remaining changes in the outbox).
+	slog.Info("shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(shutdown)
+	d.running.Wait()
+
+	return nil
+}
+
+type daemon struct {
+	ctx     *Context
+	sources map[string]*ScheduledSource
+	metrics *metrics
+	// running tracks the syncs in flight. pending lists the sources that
+	// are syncing or waiting to sync, and archives serializes the syncs of
+	// each archive (both guarded by state).
+	running  sync.WaitGroup
+	state    sync.Mutex
+	pending  map[string]bool
+	archives map[string]*sync.Mutex
+}
+
+// schedule triggers the syncs of a source on its schedule.
+func (d *daemon) schedule(name string) {
+	for {
+		next := d.sources[name].schedule.Next(time.Now())
+		if next.IsZero() {
+			slog.Warn("the schedule never runs", "source", name, "schedule", d.sources[name].Schedule)
+			return
+		}
+		d.metrics.update(name, func(s *sourceMetrics) { s.nextRun = next })
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-d.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !d.trigger(name) {
+			slog.Warn("skipping the scheduled sync: the previous sync is still running", "source", name)
+		}
+	}
+}
+
+// trigger starts a sync of the source in the background, unless one is
+// already running or waiting.
+func (d *daemon) trigger(name string) bool {
+	d.state.Lock()
+	defer d.state.Unlock()
+
+	if d.pending[name] || d.ctx.Err() != nil {
+		return false
+	}
+	d.pending[name] = true
+
+	d.running.Add(1)
+	go d.sync(name)
+
+	return true
+}
+
+func (d *daemon) sync(name string) {
+	defer d.running.Done()
+	defer func() {
+		d.state.Lock()
+		delete(d.pending, name)
+		d.state.Unlock()
+	}()
+
+	src := d.sources[name]
+	e := &Extractor{Config: src.Config, Name: src.Source, Profile: src.Profile, Parallel: 1, Report: src.Report}
+	if cfg, err := LoadConfiguration(src.Config, e.profile()); err == nil && cfg.Multi() && e.Name == util.EmptyString {
+		e.Name = src.Name
+	}
+
+	lock := d.archive(e)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if d.ctx.Err() != nil {
+		return
+	}
+
+	d.metrics.update(name, func(s *sourceMetrics) { s.running = true })
+	slog.Info("starting the sync", "source", name)
+
+	start := time.Now()
+	result, code := d.execute(name, e)
+	elapsed := time.Since(start)
+
+	d.metrics.update(name, func(s *sourceMetrics) {
+		s.running = false
+		s.runs[outcome(code)]++
+		s.duration = elapsed
+		s.lastRun = time.Now()
+		s.exitCode = code
+		if code == ExitSuccess || code == ExitChanges {
+			s.lastSuccess = s.lastRun
+		}
+		if code == ExitDatahub {
+			s.apiErrors++
+		}
+		if result != nil && result.Committed != nil {
+			s.changed += result.Committed.Succeeded
+			s.apiErrors += result.Committed.Failed
+		}
+	})
+	slog.Info("the sync finished", "source", name, "exit_code", code, "elapsed", elapsed)
+}
+
+// archive returns the lock of the archive of a sync: two syncs cannot use an
+// archive at the same time.
+func (d *daemon) archive(e *Extractor) *sync.Mutex {
+	run := *e
+	run.configure()
+	path, err := filepath.Abs(run.archive())
+	if err != nil {
+		path = run.archive()
+	}
+
+	d.state.Lock()
+	defer d.state.Unlock()
+
+	if d.archives[path] == nil {
+		d.archives[path] = &sync.Mutex{}
+	}
+
+	return d.archives[path]
+}
+
+// execute runs a sync. A crash (i.e. on an unexpected Datahub response) fails
+// the run instead of stopping the daemon.
+func (d *daemon) execute(name string, e *Extractor) (result *pipeline.Result, code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error(fmt.Sprintf("the sync crashed: %v", r), "source", name, "stack", string(debug.Stack()))
+			result, code = nil, ExitFailure
+		}
+	}()
+
+	result, code, _ = e.execute(d.ctx)
+	return result, code
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		d.metrics.WriteTo(w)
+	})
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/sync/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/sync/")
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			respond(w, http.StatusMethodNotAllowed, name, "method not allowed")
+			return
+		}
+
+		if _, ok := d.sources[name]; !ok {
+			respond(w, http.StatusNotFound, name, "unknown source")
+			return
+		}
+
+		if !d.trigger(name) {
+			respond(w, http.StatusConflict, name, "a sync is already running or waiting")
+			return
+		}
+
+		respond(w, http.StatusAccepted, name, "started")
+	})
+
+	return mux
+}
+
+func respond(w http.ResponseWriter, status int, source string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"source": source, "status": message})
+}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDaemonSurvivesACrashingSync(t *testing.T) {
+	d := &daemon{ctx: &Context{Context: context.Background()}}
+
+	// The extractor of an unknown database panics.
+	e := &Extractor{
+		ConnectionString: "unknown://localhost/db",
+		DatahubURL:       "http://datahub.invalid",
+		Source:           "public",
+		Archive:          filepath.Join(t.TempDir(), "archive.db"),
+	}
+	result, code := d.execute("db", e)
+
+	if result != nil || code != ExitFailure {
+		t.Errorf("result = %v, exit code = %v, want a failure", result, code)
+	}
+}
+
+func TestDaemonSerializesTheSyncsOfAnArchive(t *testing.T) {
+	single := func(archive string) string {
+		return writeConfig(t, "type: postgresql\nhost: db\ndatabase: sales\ndatahub_url: http://datahub.invalid\ndatahub_source: sales\n"+archive)
+	}
+	d := &daemon{archives: make(map[string]*sync.Mutex)}
+
+	// Both files use ./datahub-sync.db.
+	if d.archive(&Extractor{Config: single("")}) != d.archive(&Extractor{Config: single("")}) {
+		t.Error("two syncs can use the default archive at the same time")
+	}
+
+	dir := t.TempDir()
+	sales := d.archive(&Extractor{Config: single("archive: " + filepath.Join(dir, "sales.db"))})
+	hr := d.archive(&Extractor{Config: single("archive: " + filepath.Join(dir, "hr.db"))})
+	if sales == hr {
+		t.Error("the syncs of different archives do not run in parallel")
+	}
+}
@@ -1,449 +1,493 @@
 package command
 
 import (
+	"context"
 	"dhs/archive"
 	"dhs/extractor"
 	"dhs/extractor/datahub"
+	"dhs/extractor/doc"
 	"dhs/extractor/postgresql"
+	"dhs/pipeline"
 	"dhs/util"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
+// ARCHIVE_PATH is the SQLite archive used to diff the source and the Datahub.
+// It also holds the outbox of changes waiting to be delivered.
+const ARCHIVE_PATH = "./datahub-sync.db"
+
 type Extractor struct {
-	Config string `name:"config" short:"c" type:"string" help:"Specify a JSON configuration file (ignores connection string when supplied). A file called dh-config.json will be auto-recognized if it exists." default:"./dh-config.yml" json:"config_file"`
-	// Extract          []string `name:"extract" short:"x" type:"string" default:"source,datahub" enum:"source,datahub" help:"Determines what to extract, source (database/source) and/or Datahub metadata."`
-	Schemas          []string `name:"schemas" short:"s" type:"string" help:"List of source schemas to extract." json:"config_schema"`
-	Outfile          string   `name:"outfile" short:"o" type:"string" help:"Dump the extraction to a JSON file." json:"output_file"`
-	Expand           []string `name:"expand_json" short:"e" type:"string" help:"When configured, these JSON fields are expanded so each key is treated as a unique item." json:"expand_json"`
-	SkipViewExpand   bool     `name:"expand_fast" short:"f" type:"bool" default:"false" help:"Speed up JSON expansion process by ignoring views" json:"expand_fast"`
-	Source           string   `name:"datahub_source" short:"i" type:"string" help:"Name or ID of the Datahub data source." json:"source"`
-	DryRun           bool     `name:"dryrun" type:"bool" default:"false" help:"Pull data but do not push deltas." json:"dry_run"`
-	DatahubURL       string   `name:"url" short:"u" help:"URL of the Datahub API" json:"datahub_url"`
-	Max              int      `name:"max" short:"m" default:"35" help:"The maximum number of updates to preview (dry run)." json:"max"`
-	System           string   `name:"system" short:"j" help:"The system/job ID where status messages are logged." json:"datahub_job_id"`
-	APIKey           string   `name:"api_key" short:"k" help:"Optional API key to access the Datahub" json:"api_key"`
-	Debug            bool     `name:"debug" short:"d" help:"Turn on debugging"`
-	RelsOnly         bool     `name:"onlyrelationships" short:"r" help:"Only sync relationships"`
-	ConnectionString string   `arg:"conn" optional:"" help:"The source connection string used to extract metadata from the data store" json:"db_connection_string"`
+	Config           string                `name:"config" short:"c" type:"string" help:"Configuration file in YAML or JSON (ignored when a connection string is supplied). ./dh-config.yml, ./dh-config.yaml or ./dh-config.json is used by default." default:"./dh-config.yml" json:"config_file"`
+	Schemas          []string              `name:"schemas" short:"s" type:"string" help:"List of source schemas to extract." json:"config_schema"`
+	Outfile          string                `name:"outfile" short:"o" type:"string" help:"Dump the extraction to a JSON file." json:"output_file"`
+	Expand           []string              `name:"expand_json" short:"e" type:"string" help:"When configured, these JSON fields are expanded so each key is treated as a unique item." json:"expand_json"`
+	SkipViewExpand   bool                  `name:"expand_fast" short:"f" type:"bool" default:"false" help:"Speed up JSON expansion process by ignoring views" json:"expand_fast"`
+	Source           string                `name:"datahub_source" short:"i" type:"string" help:"Name or ID of the Datahub data source." json:"source"`
+	DryRun           bool                  `name:"dryrun" type:"bool" default:"false" help:"Pull data but do not push deltas." json:"dry_run"`
+	DatahubURL       string                `name:"url" short:"u" help:"URL of the Datahub API" json:"datahub_url"`
+	Max              int                   `name:"max" short:"m" help:"The maximum number of updates to preview (default 35)." json:"max"`
+	BatchSize        int                   `name:"batch_size" help:"The number of sets, items or relationships sent in each bulk request (default 500)." json:"batch_size"`
+	DeletionPolicy   string                `name:"deletion_policy" help:"What happens to Datahub objects that no longer exist in the source: delete (default), deprecate or ignore." json:"deletion_policy"`
+	GracePeriod      string                `name:"grace_period" help:"How long deprecated objects are kept before they are deleted, i.e. 30d or 12h (default: never)." json:"grace_period"`
+	MaxDeletions     int                   `name:"max_deletions" help:"Abort when the sync would delete more than this number of objects (default: unlimited)." json:"max_deletions"`
+	MaxDeletePercent float64               `name:"max_delete_percent" help:"Abort when the sync would delete more than this percentage of the sets or items (default 50, -1 is unlimited)." json:"max_delete_percent"`
+	Adopt            bool                  `name:"adopt" help:"Tag and update Datahub objects without the managed_by marker (i.e. created by earlier versions) that exist in the source." json:"adopt"`
+	AllowMassDelete  bool                  `name:"allow-mass-delete" help:"Sync even when the deletions exceed the mass-deletion thresholds." json:"allow_mass_delete"`
+	Timeouts         map[string]string     `name:"timeout" help:"Limit the duration of a stage (extract, stash, diff, plan or commit), i.e. --timeout extract=10m --timeout commit=30m." json:"timeouts"`
+	System           string                `name:"system" short:"j" help:"The system/job ID where status messages are logged." json:"datahub_job_id"`
+	APIKey           string                `name:"api_key" short:"k" help:"Optional API key to access the Datahub" json:"api_key"`
+	Debug            bool                  `name:"debug" short:"d" help:"Turn on debugging"`
+	Sources          []string              `name:"source" help:"Sync these sources of a multi-source configuration file." json:"sources,omitempty"`
+	All              bool                  `name:"all" help:"Sync every source of a multi-source configuration file." json:"all,omitempty"`
+	Parallel         int                   `name:"parallel" default:"4" help:"The maximum number of sources synced at the same time (--all or --source)." json:"parallel"`
+	Archive          string                `name:"archive" type:"path" help:"SQLite archive used to diff the source and the Datahub (default ./datahub-sync.db, or ./datahub-sync-<source>.db for the sources of a multi-source configuration)." json:"archive"`
+	Report           string                `name:"report" type:"path" help:"Write a JSON report of the sync (durations, counts, diffs, commit results, warnings and exit code) to this file." json:"report"`
+	DetailedExitCode bool                  `name:"detailed-exitcode" help:"Exit with code 6 when the sync committed (or, in a dry run, found) changes." json:"detailed_exitcode"`
+	Only             []string              `name:"only" help:"Only sync these elements: sets, items, relationships, stats and/or views (default: everything)." json:"only"`
+	RelsOnly         bool                  `name:"onlyrelationships" short:"r" help:"Only sync relationships (same as --only relationships)."`
+	CreateSource     bool                  `name:"create-source" help:"Create the Datahub data source when it does not exist." json:"create_source"`
+	Record           string                `name:"record" type:"path" help:"Record all Datahub HTTP traffic (with credentials redacted) to a cassette file." json:"record"`
+	Replay           string                `name:"replay" type:"path" help:"Serve Datahub responses from a recorded cassette file instead of the network." json:"replay"`
+	Name             string                `kong:"-" json:"name,omitempty"`
+	Profile          string                `kong:"-" json:"profile,omitempty"`
+	Filters          *FilterConfiguration  `kong:"-" json:"filters,omitempty"`
+	Mapping          *MappingConfiguration `kong:"-" json:"mapping,omitempty"`
+	Route            string                `kong:"-" json:"route,omitempty"`
+	Auth             *AuthConfiguration    `kong:"-" json:"datahub_auth,omitempty"`
+	TLS              *TLSConfiguration     `kong:"-" json:"datahub_tls,omitempty"`
+	Proxy            *ProxyConfiguration   `kong:"-" json:"datahub_proxy,omitempty"`
+	ConnectionString string                `arg:"conn" optional:"" help:"The source connection string used to extract metadata from the data store" json:"db_connection_string"`
+	// db replaces the extractor of the connection string (i.e. in tests).
+	db extractor.Extractor
+	// extracted is the metadata of a route, extracted by the sync of the
+	// data source.
+	extracted *doc.Doc
 }
 
 func (e *Extractor) Run(ctx *Context) error {
+	if e.All || len(e.Sources) > 0 {
+		return e.runAll(ctx)
+	}
+
+	_, code, err := e.execute(ctx)
+	if code == ExitSuccess {
+		return nil
+	}
+
+	return &ExitError{Code: code, Err: err}
+}
+
+// execute syncs the data source and writes the report. When the mapping sends
+// schemas to other Datahub data sources, they are synced next from the same
+// extraction, each with its own archive and report (see Extractor.Route). It
+// returns the result (nil when the sync did not start), the exit code and the
+// error: the most severe of the data sources.
+func (e *Extractor) execute(ctx *Context) (*pipeline.Result, int, error) {
+	result, code, err := e.executeRoute(ctx)
+	for _, route := range e.routes() {
+		if ctx.Err() != nil {
+			code = worst(code, ExitInterrupted)
+			break
+		}
+
+		run := *e
+		run.Route = route
+		if result != nil {
+			run.extracted = result.Routes[route]
+		}
+		r, c, rerr := run.executeRoute(ctx)
+		code = worst(code, c)
+		if rerr != nil {
+			err = errors.Join(err, fmt.Errorf("%v: %w", route, rerr))
+		}
+
+		if result == nil {
+			result = r
+		} else if r != nil && r.Committed != nil && result.Committed != nil {
+			result.Committed.Add(r.Committed)
+		}
+	}
+
+	return result, code, err
+}
+
+// routes lists the other Datahub data sources the schemas are mapped to. The
+// configuration errors are reported by the sync itself.
+func (e *Extractor) routes() []string {
+	if e.Route != util.EmptyString {
+		return nil
+	}
+
+	run := *e
+	if err := run.configure(); err != nil {
+		return nil
+	}
+
+	mapping, err := run.Mapping.Mapping(run.Source, util.EmptyString)
+	if err != nil {
+		return nil
+	}
+
+	return mapping.Sources()
+}
+
+// executeRoute syncs the data source (or a route) and writes its report.
+func (e *Extractor) executeRoute(ctx *Context) (*pipeline.Result, int, error) {
 	start := time.Now()
+	result, err := e.run(ctx)
+	e.log().Info(fmt.Sprintf("Total Duration: %s", time.Since(start)), "elapsed", time.Since(start))
+
+	code := e.exitCode(result, err)
+	if e.Report != util.EmptyString {
+		if result == nil {
+			result = &pipeline.Result{Source: e.datahubSource(), Started: start, Finished: time.Now(), DryRun: e.DryRun}
+		}
+
+		report := struct {
+			*pipeline.Report
+			ExitCode int `json:"exit_code"`
+		}{result.Report(err), code}
+
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if werr := ioutil.WriteFile(e.routed(e.Report), data, 0644); werr != nil {
+			e.log().Error("failed to write the report", "file", e.routed(e.Report), "error", werr)
+		}
+	}
+
+	return result, code, err
+}
+
+// run syncs the data source. The result is nil when the sync did not start.
+func (e *Extractor) run(ctx *Context) (*pipeline.Result, error) {
+	if err := e.configure(); err != nil {
+		e.log().Error(err.Error())
+		return nil, err
+	}
 
 	// Open the archive
-	cache := archive.Open("./datahub-sync.db")
+	cache, err := archive.Open(e.archive())
+	if err != nil {
+		e.log().Error(err.Error())
+		return nil, err
+	}
+
+	if e.Debug {
+		ctx.Debugging()
+	}
+	e.log().Debug("configuration applied", "configuration", e)
+
+	if e.RelsOnly {
+		e.Only = append(e.Only, "relationships")
+	}
+
+	selection, err := extractor.NewSelection(e.Only...)
+	if err != nil {
+		e.log().Error(err.Error())
+		return nil, withExitCode(ExitConfig, err)
+	}
+
+	filter, err := e.Filters.Filter()
+	if err != nil {
+		e.log().Error(err.Error())
+		return nil, withExitCode(ExitConfig, err)
+	}
+
+	mapping, err := e.Mapping.Mapping(e.Source, e.Route)
+	if err != nil {
+		e.log().Error(err.Error())
+		return nil, withExitCode(ExitConfig, err)
+	}
 
-	// if util.InSlice[string]("source", e.Extract) {
-	fmt.Println("Now extracting from source...\n")
+	remote := e.extractor()
+	remote.SetDebugging(e.Debug)
+
+	timeouts, err := e.timeouts()
+	if err != nil {
+		e.log().Error(err.Error())
+		return nil, withExitCode(ExitConfig, err)
+	}
+
+	dh, err := e.connect(cache)
+	if err != nil {
+		e.log().Error(err.Error())
+		return nil, err
+	}
+	defer func() {
+		if err := dh.Close(); err != nil {
+			e.log().Warn(err.Error())
+		}
+	}()
+
+	e.log().Info("Now syncing the data source with the Datahub...", "source", e.datahubSource(), "dry_run", e.DryRun)
+	result, err := pipeline.Run(ctx, pipeline.Options{
+		Extractor:       remote,
+		Doc:             e.extracted,
+		Datahub:         dh,
+		Archive:         cache,
+		Selection:       selection,
+		Mapping:         mapping,
+		Filter:          filter,
+		Expand:          e.Expand,
+		ExpandFast:      e.SkipViewExpand,
+		Outfile:         e.Outfile,
+		CreateSource:    e.CreateSource,
+		Fingerprint:     datahub.Fingerprint(e.ConnectionString),
+		Adopt:           e.Adopt,
+		AllowMassDelete: e.AllowMassDelete,
+		DryRun:          e.DryRun,
+		Max:             e.Max,
+		System:          e.System,
+		Schemas:         e.Schemas,
+		Timeouts:        timeouts,
+		Logger:          e.log(),
+	})
+
+	if dh.Results().Queued > 0 {
+		e.log().Warn(fmt.Sprintf("%v change(s) were queued in the outbox because the Datahub is unreachable or the sync was stopped. They are delivered by the next sync or by the flush command.", dh.Results().Queued), "queued", dh.Results().Queued)
+	}
+
+	return result, err
+}
+
+// configure applies the configuration file, unless a connection string is
+// supplied, and the defaults.
+func (e *Extractor) configure() error {
 	if e.ConnectionString == "" {
+		e.Config = configFile(e.Config)
 		_, err := os.Stat(e.Config)
 		if err != nil {
 			if os.IsNotExist(err) {
 				err = errors.New("configuration/connection string not found")
 			}
-			fmt.Println(err)
-			return err
+			return withExitCode(ExitConfig, err)
 		}
 
 		cfg := NewConfig(e.Config)
 		err = cfg.Apply(e)
 		if err != nil {
-			fmt.Println(err)
-			return err
+			return withExitCode(ExitConfig, err)
 		}
 	}
 
-	if e.Debug {
-		fmt.Println("  configuration applied")
-		util.Dump(e)
-		fmt.Println("  setting up extractor...")
+	if e.Max < 1 {
+		e.Max = 35
 	}
 
-	remote := e.extractor()
-	// remote.ApplySchemas(e.Schemas...)
+	return nil
+}
 
-	if e.Debug {
-		remote.SetDebugging(true)
-		fmt.Println("  extractor setup complete")
+// exitCode is the exit code of a sync: an interrupted sync exits with
+// ExitInterrupted, a sync that committed some changes but failed or queued
+// others with ExitPartial, and a failed stage with the code of the stage. With
+// --detailed-exitcode, a sync with changes exits with ExitChanges.
+func (e *Extractor) exitCode(result *pipeline.Result, err error) int {
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
 	}
 
-	var end_json time.Duration
-	var end_sqlite time.Duration
-	var end_expand time.Duration
+	if result != nil && result.Committed != nil && (result.Committed.Failed > 0 || result.Committed.Queued > 0) {
+		return ExitPartial
+	}
 
-	start_extract := time.Now()
+	var stageErr *pipeline.StageError
+	if err != nil && result != nil && !errors.As(err, &stageErr) {
+		// The sync completed, but some changes were dropped (i.e. the
+		// deletions of an invalid deletion plan).
+		return ExitPartial
+	}
 
-	if e.Debug {
-		fmt.Println("  begin extraction...")
+	if err != nil {
+		return ExitCode(err)
 	}
 
-	dh, dherr := datahub.New(e.DatahubURL, e.Source, cache, e.APIKey)
-	if dherr != nil {
-		fmt.Println(dherr.Error())
-		os.Exit(1)
+	if e.DetailedExitCode && result != nil && result.Changes() > 0 {
+		return ExitChanges
 	}
 
-	elements := []string{}
-	if e.RelsOnly {
-		fmt.Println("Extract rels from datasource")
-		rels, err := remote.ExtractRelationships()
-		if err != nil {
-			fmt.Println(err)
-			return err
-		}
+	return ExitSuccess
+}
 
-		fmt.Println("Extract rels from datahub")
-		err = dh.PopulateSources()
-		if err != nil {
-			fmt.Println(err)
-			return err
-		}
+// log returns the logger of the sync, which names the source of a
+// multi-source configuration and the route.
+func (e *Extractor) log() *slog.Logger {
+	logger := slog.Default()
+	if e.Name != util.EmptyString {
+		logger = logger.With("source_name", e.Name)
+	}
 
-		id := dh.Source()
-		uri := "/catalog/relationships/source/" + id
-		cd, body, err := dh.Get(uri)
-		if err != nil {
-			return err
-		}
+	if e.Route != util.EmptyString {
+		logger = logger.With("route", e.Route)
+	}
 
-		if cd != 200 {
-			fmt.Println(string(body))
-			return errors.New(string(body))
-		}
+	return logger
+}
 
-		var data map[string]interface{}
-		err = json.Unmarshal(body, &data)
-		if err != nil {
-			return err
-		}
+// datahubSource is the Datahub data source being synced: the route, or the
+// configured data source.
+func (e *Extractor) datahubSource() string {
+	if e.Route != util.EmptyString {
+		return e.Route
+	}
 
-		outdated := []string{}
-		for _, rel := range data["relationships"].([]interface{}) {
-			if _, ok := rels[rel.(map[string]interface{})["name"].(map[string]interface{})["physical"].(string)]; !ok {
-				outdated = append(outdated, rel.(map[string]interface{})["id"].(string))
-			}
-		}
+	return e.Source
+}
 
-		if len(outdated) > 0 {
-			uri := "/catalog/relationships"
+// archive is the path of the archive: each source of a multi-source
+// configuration has its own archive, so they can sync in parallel, and so
+// does each route.
+func (e *Extractor) archive() string {
+	if e.Archive != util.EmptyString {
+		return e.routed(e.Archive)
+	}
 
-			cd, _, err := dh.Delete(uri, map[string]interface{}{
-				"relationships": outdated,
-			})
+	if e.Name != util.EmptyString {
+		return e.routed(fmt.Sprintf("./datahub-sync-%v.db", e.Name))
+	}
 
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
+	return e.routed(ARCHIVE_PATH)
+}
 
-			if cd != 200 {
-				fmt.Printf("Error: HTTP Code %v received when attempting to delete relationships\n", cd)
-			} else {
-				fmt.Printf("  deleted %v relationship(s)\n", len(outdated))
-			}
-		}
+// routed is the path of a file (archive or report) for the route: the name
+// of the route is appended to the name of the file, i.e.
+// ./datahub-sync-analytics.db.
+func (e *Extractor) routed(path string) string {
+	return suffixed(path, e.Route)
+}
 
-		if len(rels) > 0 {
-			relbody := make([]interface{}, 0)
-			for _, item := range rels {
-				relbody = append(relbody, item)
-			}
+// suffixed appends a name to the name of a file, before its extension.
+func suffixed(path string, name string) string {
+	if name == util.EmptyString || path == util.EmptyString {
+		return path
+	}
 
-			cd, _, err = dh.Put("/catalog/relationships", map[string]interface{}{
-				"relationships": relbody,
-			})
+	ext := filepath.Ext(path)
 
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
 
-			if cd != 200 {
-				fmt.Printf("Error: HTTP Code %v received when attempting to delete relationships\n", cd)
-			} else {
-				fmt.Printf("  created/updated %v relationship(s)\n", len(rels))
-			}
+// timeouts parses the stage timeouts.
+func (e *Extractor) timeouts() (map[pipeline.Stage]time.Duration, error) {
+	return parseTimeouts(e.Timeouts)
+}
+
+func parseTimeouts(values map[string]string) (map[pipeline.Stage]time.Duration, error) {
+	timeouts := make(map[pipeline.Stage]time.Duration)
+	for name, value := range values {
+		stage := pipeline.Stage(strings.ToLower(strings.TrimSpace(name)))
+		if !util.InSlice[pipeline.Stage](stage, pipeline.Stages) {
+			return timeouts, fmt.Errorf("invalid timeout stage %q (expected extract, stash, diff, plan or commit)", name)
 		}
 
-		os.Exit(0)
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout < 0 {
+			return timeouts, fmt.Errorf("invalid %v timeout %q (expected a duration such as 90s or 10m)", name, value)
+		}
+		timeouts[stage] = timeout
 	}
 
-	doc, err := remote.Extract(elements...)
+	return timeouts, nil
+}
+
+// profile is the configuration profile: the profile of the source (serve
+// command) or --profile.
+func (e *Extractor) profile() string {
+	if e.Profile != util.EmptyString {
+		return e.Profile
+	}
+
+	return Root.Profile
+}
+
+// connect creates the Datahub client, with the configured authentication,
+// TLS and proxy settings, and verifies the connection (with the recorded
+// response when replaying a cassette).
+func (e *Extractor) connect(cache *archive.Archive) (*datahub.Datahub, error) {
+	dh, err := e.client(cache)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return dh, err
 	}
 
-	if e.Debug {
-		fmt.Println("  setting up document...")
+	if err := dh.CheckConnection(); err != nil {
+		return dh, withExitCode(ExitDatahub, err)
 	}
-	cache.SetDoc(doc)
 
-	end_extract := time.Since(start_extract)
-	fmt.Printf("Source Extraction: %s\n", end_extract)
+	return dh, nil
+}
 
-	if len(e.Expand) > 0 && (util.InSlice[string]("views", elements) || util.InSlice[string]("entities", elements)) {
-		if e.Debug {
-			fmt.Println("  enabling JSON field expansion functions...")
-		}
-		start_expand := time.Now()
-		remote.ExpandJSONFields(doc, e.SkipViewExpand, e.Expand...)
-		end_expand = time.Since(start_expand)
-		fmt.Printf("JSON Expansion: %s\n", end_expand)
-	}
-
-	if len(elements) > 0 {
-		switch strings.ToLower(filepath.Ext(e.Outfile)) {
-		// JSON
-		case ".json":
-			start_json := time.Now()
-			if e.Debug {
-				fmt.Println("  writing metadoc to JSON file...")
-			}
-			util.DumpFile(e.Outfile, doc.ToJSON())
-			end_json = time.Since(start_json)
-			fmt.Printf("Created %s in %s\n", e.Outfile, end_json)
-			// case ".xml":
-		}
+// client creates the Datahub client without connecting to the Datahub. With
+// --record, every request is recorded, including the connection check and the
+// authentication.
+func (e *Extractor) client(cache *archive.Archive) (*datahub.Datahub, error) {
+	dh, err := datahub.New(e.DatahubURL, e.datahubSource(), cache, e.APIKey)
+	if err != nil {
+		return dh, withExitCode(ExitConfig, err)
 	}
+	dh.SetBatchSize(e.BatchSize)
 
-	start_sqlite := time.Now()
+	grace, err := datahub.ParseGracePeriod(e.GracePeriod)
+	if err != nil {
+		return dh, withExitCode(ExitConfig, err)
+	}
+	if err := dh.SetDeletionPolicy(e.DeletionPolicy, grace); err != nil {
+		return dh, withExitCode(ExitConfig, err)
+	}
+	dh.SetDeletionLimits(e.MaxDeletions, e.MaxDeletePercent)
 
-	if util.InSlice[string]("entities", elements) || util.InSlice[string]("relationships", elements) {
-		if e.Debug {
-			fmt.Println("  extracting data set metadata from source...")
-		}
-		sets := extractor.GetAllSets(doc)
-		fmt.Printf("  stashing %v set(s)...\n", len(sets))
-		err = cache.UpsertSets("source", sets)
+	if e.Auth != nil {
+		auth, err := e.Auth.Authenticator(e.DatahubURL, e.APIKey)
 		if err != nil {
-			fmt.Println(err)
+			return dh, withExitCode(ExitConfig, err)
 		}
 
-		if e.Debug {
-			fmt.Println("  extracting data item metadata from source...")
-		}
-		items := extractor.GetAllItems(doc)
-		fmt.Printf("  stashing %v item(s)...\n", len(items))
-		err = cache.UpsertItems("source", items)
-		if err != nil {
-			fmt.Println(err)
+		if auth != nil {
+			dh.SetAuth(auth)
 		}
 	}
 
-	if util.InSlice[string]("relationships", elements) {
-		if e.Debug {
-			fmt.Println("  extracting data relationship metadata from source...")
-		}
-		rels := extractor.GetAllRelationships(doc)
-		fmt.Printf("  stashing %v relationship(s)...\n", len(rels))
-		err = cache.UpsertRelationships("source", rels)
+	if e.Replay != "" {
+		replayer, err := datahub.NewReplayer(e.Replay)
 		if err != nil {
-			fmt.Println(err)
+			return dh, withExitCode(ExitConfig, err)
 		}
-	}
+		slog.Info("replaying Datahub traffic", "file", e.Replay)
+		dh.SetTransport(replayer)
 
-	end_sqlite = time.Since(start_sqlite)
-	fmt.Printf("Created cache in %s\n", end_sqlite)
-	// }
-
-	// if util.InSlice[string]("datahub", e.Extract) {
-	fmt.Println("\nNow extracting from Datahub...")
-	start_datahub := time.Now()
-
-	if dherr == nil {
-		if len(elements) == 1 && elements[0] == "relationships" {
-			for _, schema := range e.Schemas {
-				dh.GetDoc().ApplySchemaByName(schema)
-				util.Dump(dh.GetDoc().GetSchemas())
-				os.Exit(1)
-				// diff := archive.CreateDiff()
-
-				// err = dh.PopulateRelationships(diff)
-				// if err == nil {
-				rels := extractor.GetAllRelationships(dh.GetDoc())
-				fmt.Printf("  stashing %v relationship(s)...\n", len(rels))
-
-				// err = cache.UpsertRelationships("datahub", rels)
-				// if err == nil {
-				// 	if e.Debug {
-				// 		fmt.Println("  diffing data relationships...")
-				// 	}
-				// 	reldiff, err := cache.DiffRelationships(diff)
-				// 	if err == nil {
-				// 		if e.Debug {
-				// 			fmt.Println("  diffing individual relationship joins...")
-				// 		}
-				// 		joindiff, err := cache.DiffJoins(diff, reldiff)
-				// 		if err == nil {
-				// 			fmt.Printf("\nNow syncing with the Datahub...\n")
-				// 			if e.DryRun {
-				// 				if e.Debug {
-				// 					fmt.Println("  running dry run...")
-				// 				}
-				// 				dh.DryRun(reldiff, e.Max, "relationship")
-				// 				fmt.Println("")
-				// 				dh.DryRun(joindiff, e.Max, "join")
-				// 			} else {
-				// 				if e.Debug {
-				// 					fmt.Println("  syncing...")
-				// 				}
-				// 				dh.DryRun(reldiff, e.Max, "relationship")
-				// 				dh.Commit(reldiff)
-				// 				cache.ResetDatahub()
-				// 				cache.ResetDatasource()
-				// 			}
-				// 		} else {
-				// 			fmt.Println(err)
-				// 		}
-				// 	} else {
-				// 		fmt.Println(err)
-				// 	}
-				// } else {
-				// 	fmt.Println(err)
-				// }
-				// } else {
-				// 	fmt.Println(err)
-				// }
-			}
-		} else {
-			if e.Debug {
-				fmt.Println("  populating datahub sources...")
-			}
-			err = dh.PopulateSources()
-			if err != nil {
-				fmt.Println(err)
-			} else {
-				sets := extractor.GetAllSets(dh.GetDoc())
-				fmt.Printf("  stashing %v set(s)...\n", len(sets))
-				err := cache.UpsertSets("datahub", sets)
-				if err != nil {
-					fmt.Println(err)
-				} else {
-					if e.Debug {
-						fmt.Println("  diffing sets...")
-					}
-					diff, err := cache.DiffSets()
-
-					if err == nil {
-						if e.Debug {
-							fmt.Println("  populating data items...")
-						}
-						err = dh.PopulateItems(diff)
-						if err == nil {
-							items := extractor.GetAllItems(dh.GetDoc())
-							fmt.Printf("  stashing %v item(s)...\n", len(items))
-							err := cache.UpsertItems("datahub", items)
-							if err == nil {
-								if e.Debug {
-									fmt.Println("  diffing data items...")
-								}
-								itemdiff, err := cache.DiffItems(diff)
-								if err == nil {
-									if e.Debug {
-										fmt.Println("  populating datahub relationships...")
-									}
-									err = dh.PopulateRelationships(diff)
-									if err == nil {
-										rels := extractor.GetAllRelationships(dh.GetDoc())
-										fmt.Printf("  stashing %v relationship(s)...\n", len(rels))
-
-										err = cache.UpsertRelationships("datahub", rels)
-										if err == nil {
-											if e.Debug {
-												fmt.Println("  diffing data relationships...")
-											}
-											reldiff, err := cache.DiffRelationships(diff)
-											if err == nil {
-												if e.Debug {
-													fmt.Println("  diffing individual relationship joins...")
-												}
-												joindiff, err := cache.DiffJoins(diff, reldiff)
-												if err == nil {
-													fmt.Printf("\nNow syncing with the Datahub...\n")
-													if e.DryRun {
-														if e.Debug {
-															fmt.Println("  running dry run...")
-														}
-														dh.DryRun(diff, e.Max)
-														fmt.Println("")
-														dh.DryRun(itemdiff, e.Max, "item")
-														fmt.Println("")
-														dh.DryRun(reldiff, e.Max, "relationship")
-														fmt.Println("")
-														dh.DryRun(joindiff, e.Max, "join")
-													} else {
-														if e.Debug {
-															fmt.Println("  syncing...")
-														}
-														dh.DryRun(diff, e.Max)
-														dh.Commit(diff)
-														fmt.Println("")
-														dh.DryRun(itemdiff, e.Max, "item")
-														dh.Commit(itemdiff)
-														fmt.Println("")
-														dh.DryRun(reldiff, e.Max, "relationship")
-														dh.Commit(reldiff)
-														cache.ResetDatahub()
-														cache.ResetDatasource()
-													}
-												} else {
-													fmt.Println(err)
-												}
-											} else {
-												fmt.Println(err)
-											}
-										} else {
-											fmt.Println(err)
-										}
-									} else {
-										fmt.Println(err)
-									}
-								} else {
-									fmt.Println(err)
-								}
-							} else {
-								fmt.Println(err)
-							}
-						} else {
-							fmt.Println(err)
-						}
-					} else {
-						fmt.Println(err)
-					}
-				}
-			}
-		}
-	} else {
-		fmt.Println(dherr.Error())
+		return dh, nil
 	}
 
-	end_datahub := time.Since(start_datahub)
-	fmt.Printf("Datahub Extraction: %s\n", end_datahub)
-	// }
-
-	end := time.Since(start)
+	transport, err := e.TLS.Transport(e.Proxy)
+	if err != nil {
+		return dh, withExitCode(ExitConfig, err)
+	}
+	dh.SetTransport(transport)
 
-	fmt.Printf("Total Duration: %s\n", end)
+	if e.Record != "" {
+		slog.Info("recording Datahub traffic", "file", e.Record)
+		dh.SetTransport(datahub.NewRecorder(e.Record, transport))
+	}
 
-	return nil
+	return dh, nil
 }
 
 func (e *Extractor) extractor() extractor.Extractor {
+	if e.db != nil {
+		return e.db
+	}
+
 	schema := strings.Split(e.ConnectionString, ":")[0]
 
 	if strings.ToLower(schema) == "postgres" || strings.ToLower(schema) == "greenplum" {
 		schema = "postgresql"
 	}
 
-	var empty extractor.Extractor
-
 	switch strings.ToLower(schema) {
 	case "postgresql":
 		return postgresql.New(e.ConnectionString, e.Schemas)
@@ -452,6 +496,4 @@ func (e *Extractor) extractor() extractor.Extractor {
 	default:
 		panic(schema + " extractor not found")
 	}
-
-	return empty
 }
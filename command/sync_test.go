@@ -0,0 +1,159 @@
+package command
+
+import (
+	"context"
+	"dhs/extractor/datahub"
+	"dhs/extractor/datahub/datahubtest"
+	"dhs/extractor/doc"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fake is a database extractor serving in-memory schemas of tables with an
+// id column.
+type fake struct {
+	schemas map[string][]string
+	// extractions counts the calls to Extract.
+	extractions int
+	mu          sync.Mutex
+}
+
+func (f *fake) Extract(_ context.Context, _ ...string) (*doc.Doc, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.extractions++
+
+	d := doc.New(&doc.Source{Name: doc.Name{Physical: "db"}})
+	for name, tables := range f.schemas {
+		schema := d.ApplySchema(&doc.Schema{Name: doc.Name{Physical: name}, Sets: map[string]*doc.Set{}})
+		for _, table := range tables {
+			set := schema.UpsertSet(&doc.Set{Name: doc.Name{Physical: table, Logical: table}, Type: "TABLE", Items: map[string]*doc.Item{}})
+			set.UpsertItem(&doc.Item{Name: doc.Name{Physical: "id"}, Type: "int4", FQDN: name + "." + table + ".id"})
+		}
+	}
+
+	return d, nil
+}
+
+func (f *fake) SetConnectionString(string) error { return nil }
+func (f *fake) ExtractRelationships(context.Context, ...string) (map[string]interface{}, error) {
+	return nil, nil
+}
+func (f *fake) Type() string                                                { return "fake" }
+func (f *fake) ExpandJSONFields(context.Context, *doc.Doc, bool, ...string) {}
+func (f *fake) SetDebugging(bool)                                           {}
+func (f *fake) SetLogger(*slog.Logger)                                      {}
+func (f *fake) ApplySchemas(...string)                                      {}
+
+// mockDatahub serves a mock Datahub with the data sources.
+func mockDatahub(t *testing.T, sources ...string) (*datahubtest.Server, *httptest.Server) {
+	t.Helper()
+
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range sources {
+		server.AddSource(source)
+	}
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	return server, ts
+}
+
+// writeConfig writes a configuration file in the temporary directory of the
+// test.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+
+	config := filepath.Join(t.TempDir(), "dh-config.yml")
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestRecordIncludesTheConnection(t *testing.T) {
+	server, ts := mockDatahub(t, "public")
+	server.ClientID, server.ClientSecret = "dhs", "s3cr3t"
+
+	dir := t.TempDir()
+	cassette := filepath.Join(dir, "cassette.json")
+	run := func(archive string, record string, replay string) error {
+		e := &Extractor{
+			ConnectionString: "postgresql://dhs@db/sales",
+			DatahubURL:       ts.URL,
+			Source:           "public",
+			Auth:             &AuthConfiguration{Type: "oauth2", TokenURL: ts.URL + "/oauth/token", ClientID: "dhs", ClientSecret: "s3cr3t"},
+			Archive:          filepath.Join(dir, archive),
+			Record:           record,
+			Replay:           replay,
+			db:               &fake{schemas: map[string][]string{"public": {"users"}}},
+		}
+		_, err := e.run(&Context{Context: context.Background()})
+		return err
+	}
+
+	if err := run("record.db", cassette, ""); err != nil {
+		t.Fatalf("the recorded sync failed: %v", err)
+	}
+
+	c, err := datahub.LoadCassette(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != len(server.Requests()) {
+		t.Errorf("recorded %v interactions for %v requests: %v", len(c.Interactions), len(server.Requests()), server.Requests())
+	}
+	if len(c.Interactions) < 2 || c.Interactions[0].Request.Method != "GET" || c.Interactions[1].Request.Method != "POST" || !strings.HasSuffix(c.Interactions[1].Request.URL, "/oauth/token") {
+		t.Errorf("the cassette does not start with the connection check and the authentication")
+	}
+
+	// The connection check is served by the cassette.
+	ts.Close()
+	if err := run("replay.db", "", cassette); err != nil {
+		t.Errorf("the replayed sync failed: %v", err)
+	}
+}
+
+func TestRoutesShareTheExtraction(t *testing.T) {
+	server, ts := mockDatahub(t, "sales", "audit")
+
+	db := &fake{schemas: map[string][]string{"public": {"users", "orders"}, "audit_2024": {"events"}}}
+	e := &Extractor{
+		ConnectionString: "postgresql://dhs@db/sales",
+		DatahubURL:       ts.URL,
+		Source:           "sales",
+		Archive:          filepath.Join(t.TempDir(), "datahub-sync.db"),
+		Mapping:          &MappingConfiguration{Schemas: []SchemaMappingConfiguration{{Schema: "audit_*", Source: "audit"}}},
+		db:               db,
+	}
+	if _, code, err := e.execute(&Context{Context: context.Background()}); code != ExitSuccess {
+		t.Fatalf("the sync exited with %v: %v", code, err)
+	}
+
+	if db.extractions != 1 {
+		t.Errorf("the database was extracted %v times, want once", db.extractions)
+	}
+
+	for source, want := range map[string]string{"sales": "orders,users", "audit": "events"} {
+		sets := make([]string, 0)
+		for _, set := range server.Source(source).Sets {
+			sets = append(sets, set.Name.Physical)
+		}
+		sort.Strings(sets)
+
+		if got := strings.Join(sets, ","); got != want {
+			t.Errorf("%v sets = %v, want %v", source, got, want)
+		}
+	}
+}
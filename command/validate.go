@@ -0,0 +1,336 @@
+package command
+
+import (
+	"dhs/extractor"
+	"dhs/extractor/datahub"
+	"dhs/util"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError lists the problems of a configuration file.
+type ConfigError struct {
+	File     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("invalid configuration %v: %v", e.File, e.Problems[0])
+	}
+
+	return fmt.Sprintf("invalid configuration %v (%v problems): %v", e.File, len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// checkKeys reports the keys of a YAML document that do not match a setting
+// of the type t (i.e. typos), with their line.
+func checkKeys(node *yaml.Node, t reflect.Type, path string, problems *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			checkKeys(child, t, path, problems)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		settings := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name != util.EmptyString && name != "-" {
+				settings[name] = t.Field(i).Type
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			setting, ok := settings[key.Value]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("line %v: unknown setting %q%v%v", key.Line, key.Value, where(path), suggest(key.Value, settings)))
+				continue
+			}
+
+			checkKeys(value, setting, join(path, key.Value), problems)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+
+		for i, item := range node.Content {
+			checkKeys(item, t.Elem(), fmt.Sprintf("%v[%v]", path, i), problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKeys(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value), problems)
+		}
+	}
+}
+
+func join(path string, key string) string {
+	if path == util.EmptyString {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func where(path string) string {
+	if path == util.EmptyString {
+		return util.EmptyString
+	}
+
+	return " in " + path
+}
+
+// suggest returns the closest setting to a misspelt key.
+func suggest(key string, settings map[string]reflect.Type) string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, distance := util.EmptyString, 3
+	for _, name := range names {
+		if d := levenshtein(key, name); d < distance {
+			best, distance = name, d
+		}
+	}
+
+	if best == util.EmptyString {
+		return util.EmptyString
+	}
+
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+// mapping returns the value of a key in a YAML mapping.
+func mapping(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// without returns a copy of a YAML mapping without some keys.
+func without(node *yaml.Node, keys ...string) *yaml.Node {
+	result := *node
+	result.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !util.InSlice[string](node.Content[i].Value, keys) {
+			result.Content = append(result.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+
+	return &result
+}
+
+// merge overlays a YAML mapping on another: nested mappings are merged, and
+// other values (including lists) are replaced.
+func merge(base *yaml.Node, overlay *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		return overlay
+	}
+
+	result := *base
+	result.Content = append([]*yaml.Node{}, base.Content...)
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(result.Content); j += 2 {
+			if result.Content[j].Value == key.Value {
+				result.Content[j+1] = merge(result.Content[j+1], value)
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			result.Content = append(result.Content, key, value)
+		}
+	}
+
+	return &result
+}
+
+// applyProfile merges a profile, and the profiles it extends, into the
+// document.
+func applyProfile(root *yaml.Node, profiles *yaml.Node, name string) (*yaml.Node, error) {
+	chain := []*yaml.Node{}
+	seen := []string{}
+	for current := name; current != util.EmptyString; {
+		if util.InSlice[string](current, seen) {
+			return nil, fmt.Errorf("the profiles %v extend each other", strings.Join(append(seen, current), " -> "))
+		}
+		seen = append(seen, current)
+
+		profile := mapping(profiles, current)
+		if profile == nil {
+			return nil, fmt.Errorf("unknown profile %q (expected %v)", current, strings.Join(profileNames(profiles), ", "))
+		}
+
+		if profile.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %v: the %v profile must be a mapping of settings", profile.Line, current)
+		}
+
+		chain = append([]*yaml.Node{without(profile, "extends")}, chain...)
+		current = util.EmptyString
+		if extends := mapping(profile, "extends"); extends != nil {
+			current = extends.Value
+		}
+	}
+
+	for _, profile := range chain {
+		root = merge(root, profile)
+	}
+
+	return root, nil
+}
+
+func profileNames(profiles *yaml.Node) []string {
+	names := []string{}
+	if profiles == nil || profiles.Kind != yaml.MappingNode {
+		return names
+	}
+
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		names = append(names, profiles.Content[i].Value)
+	}
+
+	return names
+}
+
+// validate checks the values of the settings. Missing settings are not
+// problems, since they can be given on the command line.
+func (c *ExtractorConfiguration) validate() []string {
+	problems := []string{}
+	prefix := util.EmptyString
+	if c.Name != util.EmptyString {
+		prefix = c.Name + ": "
+	}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, prefix+fmt.Sprintf(format, args...))
+	}
+
+	if c.Connstr != util.EmptyString {
+		scheme := strings.ToLower(strings.Split(c.Connstr, ":")[0])
+		if !util.InSlice[string](scheme, []string{"postgres", "postgresql", "greenplum"}) {
+			problem("unsupported connection_string scheme %q (expected postgresql or greenplum)", scheme)
+		}
+	} else if c.Type != util.EmptyString && !util.InSlice[string](strings.ToLower(c.Type), []string{"postgres", "postgre", "postgresql", "greenplum"}) {
+		problem("unsupported type %q (expected postgresql or greenplum)", c.Type)
+	}
+
+	if c.URL != util.EmptyString {
+		if uri, err := url.Parse(util.EncodeURL(c.URL)); err != nil || (uri.Scheme != "http" && uri.Scheme != "https") {
+			problem("invalid datahub_url %q (expected an http or https URL)", c.URL)
+		}
+	}
+
+	if c.Deletion != util.EmptyString && !util.InSlice[string](strings.ToLower(c.Deletion), []string{datahub.DeletePolicy, datahub.DeprecatePolicy, datahub.IgnorePolicy}) {
+		problem("invalid deletion_policy %q (expected %v, %v or %v)", c.Deletion, datahub.DeletePolicy, datahub.DeprecatePolicy, datahub.IgnorePolicy)
+	}
+
+	if _, err := datahub.ParseGracePeriod(c.Grace); err != nil {
+		problem("grace_period: %v", err)
+	}
+
+	if _, err := parseTimeouts(c.Timeouts); err != nil {
+		problem("timeouts: %v", err)
+	}
+
+	if _, err := extractor.NewSelection(c.Only...); err != nil {
+		problem("only: %v", err)
+	}
+
+	for name, value := range map[string]int{"max": c.Max, "batch_size": c.BatchSize, "max_deletions": c.MaxDelete} {
+		if value < 0 {
+			problem("%v must not be negative", name)
+		}
+	}
+
+	if c.MaxPercent < -1 || c.MaxPercent > 100 {
+		problem("max_delete_percent must be between 0 and 100 (or -1 for unlimited)")
+	}
+
+	if _, err := c.Filters.Filter(); err != nil {
+		problem("filters: %v", err)
+	}
+
+	if _, err := c.Mapping.Mapping(c.Source, util.EmptyString); err != nil {
+		problem("mapping: %v", err)
+	}
+
+	authtype := strings.ToLower(c.Auth.Type)
+	if authtype != util.EmptyString && !util.InSlice[string](authtype, []string{"api_key", "apikey", "basic", "jwt", "oauth2", "client_credentials"}) {
+		problem("unrecognized datahub_auth type %q (expected api_key, basic or oauth2)", c.Auth.Type)
+	}
+
+	return problems
+}
+
+// missing lists the settings required to sync that the configuration of a
+// source does not provide.
+func (c *ExtractorConfiguration) missing() []string {
+	missing := []string{}
+	if c.Connstr == util.EmptyString && (c.Type == util.EmptyString || c.Host == util.EmptyString || c.Database == util.EmptyString) {
+		missing = append(missing, "connection_string (or type, host and database)")
+	}
+
+	if c.URL == util.EmptyString {
+		missing = append(missing, "datahub_url")
+	}
+
+	if c.Source == util.EmptyString {
+		missing = append(missing, "datahub_source")
+	}
+
+	return missing
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"dhs/command"
 	"dhs/util"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alecthomas/kong"
 )
@@ -16,8 +20,22 @@ var (
 )
 
 func main() {
+	// On SIGINT or SIGTERM, the requests in flight finish and the remaining
+	// changes are queued in the outbox. A second signal kills the process.
+	interrupt, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		slog.Warn("Interrupted: finishing the requests in flight (interrupt again to quit immediately)...")
+		cancel()
+	}()
+
 	cmd := &command.Context{
-		Debug: false,
+		Context: interrupt,
+		Debug:   false,
+		Level:   new(slog.LevelVar),
 	}
 
 	if len(os.Args) < 2 {
@@ -34,7 +52,21 @@ func main() {
 		kong.Name(name),
 		kong.Description(description+"\nv"+version),
 		kong.UsageOnError(),
+		// Invalid command lines exit with the configuration error code.
+		kong.Exit(func(code int) {
+			if code != command.ExitSuccess {
+				code = command.ExitConfig
+			}
+			os.Exit(code)
+		}),
 	)
 
-	ctx.Run(cmd)
+	logger, closer, err := root.Logging.Logger(cmd.Level)
+	ctx.FatalIfErrorf(err)
+	slog.SetDefault(logger)
+
+	err = ctx.Run(cmd)
+	closer.Close()
+
+	os.Exit(command.ExitCode(err))
 }
@@ -0,0 +1,25 @@
+package extractor
+
+import "context"
+
+// Check is the outcome of a preflight check (see the doctor command). A
+// check passes when Err is nil; otherwise Hint explains how to fix it. A
+// Warning does not prevent a sync, but may affect what it syncs.
+type Check struct {
+	Name    string
+	Detail  string
+	Err     error
+	Hint    string
+	Warning bool
+}
+
+// Failed determines whether the check found a problem that prevents a sync.
+func (c *Check) Failed() bool {
+	return c.Err != nil && !c.Warning
+}
+
+// Checker is implemented by the extractors able to verify their connection
+// and privileges before a sync.
+type Checker interface {
+	Check(ctx context.Context) []*Check
+}
@@ -0,0 +1,323 @@
+package datahub
+
+import (
+	"crypto/sha256"
+	"dhs/util"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tokens are refreshed this long before they expire, so a request is never
+// sent with a token that expires in flight.
+const tokenExpirySkew = 60 * time.Second
+
+// Authenticator supplies the bearer token sent with every Datahub request.
+type Authenticator interface {
+	// Token returns a valid token, obtaining or refreshing it when necessary.
+	Token(client *http.Client) (string, error)
+	// Invalidate discards the current token (i.e. after a 401 response).
+	// It returns false when the token cannot be renewed.
+	Invalidate() bool
+}
+
+// APIKeyAuth sends a static API key as the bearer token.
+type APIKeyAuth struct {
+	key string
+}
+
+func NewAPIKeyAuth(key string) *APIKeyAuth {
+	return &APIKeyAuth{key: key}
+}
+
+func (a *APIKeyAuth) Token(client *http.Client) (string, error) {
+	return a.key, nil
+}
+
+func (a *APIKeyAuth) Invalidate() bool {
+	return false
+}
+
+// BasicAuth exchanges a user name and password for a JWT using the Datahub
+// /token endpoint. The credentials are sent as a basic authorization header,
+// never as part of the URL.
+type BasicAuth struct {
+	// CacheFile, when set, persists the JWT between runs.
+	CacheFile string
+	root      string
+	user      string
+	password  string
+	cache     tokenCache
+}
+
+func NewBasicAuth(root string, user string, password string) *BasicAuth {
+	return &BasicAuth{root: strings.TrimRight(root, "/"), user: user, password: password}
+}
+
+func (a *BasicAuth) Token(client *http.Client) (string, error) {
+	a.cache.mu.Lock()
+	defer a.cache.mu.Unlock()
+
+	if token, ok := a.cache.get(a.CacheFile, a.root+"|"+a.user); ok {
+		return token, nil
+	}
+
+	slog.Info("authenticating with the Datahub service...")
+	req, err := http.NewRequest("GET", a.root+"/token", nil)
+	if err != nil {
+		return util.EmptyString, err
+	}
+	req.SetBasicAuth(a.user, a.password)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return util.EmptyString, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return util.EmptyString, err
+	}
+
+	if res.StatusCode != 200 {
+		slog.Error("access denied", "status", res.StatusCode)
+		return util.EmptyString, errors.New("access denied")
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return util.EmptyString, err
+	}
+
+	token, ok := data["jwt"].(string)
+	if !ok || token == util.EmptyString {
+		return util.EmptyString, errors.New("the Datahub /token response does not contain a JWT")
+	}
+
+	a.cache.set(a.CacheFile, a.root+"|"+a.user, token, jwtExpiry(token))
+
+	return token, nil
+}
+
+func (a *BasicAuth) Invalidate() bool {
+	a.cache.mu.Lock()
+	defer a.cache.mu.Unlock()
+
+	a.cache.clear(a.CacheFile)
+	return true
+}
+
+// ClientCredentialsAuth obtains an access token from an OAuth2 identity
+// provider using the client credentials grant.
+type ClientCredentialsAuth struct {
+	// CacheFile, when set, persists the access token between runs.
+	CacheFile string
+	// Audience is sent with the token request when the IdP requires it.
+	Audience     string
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	cache        tokenCache
+}
+
+func NewClientCredentialsAuth(tokenURL string, clientID string, clientSecret string, scopes ...string) *ClientCredentialsAuth {
+	return &ClientCredentialsAuth{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+	}
+}
+
+func (a *ClientCredentialsAuth) Token(client *http.Client) (string, error) {
+	a.cache.mu.Lock()
+	defer a.cache.mu.Unlock()
+
+	if token, ok := a.cache.get(a.CacheFile, a.tokenURL+"|"+a.clientID); ok {
+		return token, nil
+	}
+
+	slog.Info("requesting an OAuth2 access token...")
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.scopes) > 0 {
+		form.Set("scope", strings.Join(a.scopes, " "))
+	}
+	if a.Audience != util.EmptyString {
+		form.Set("audience", a.Audience)
+	}
+
+	req, err := http.NewRequest("POST", a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return util.EmptyString, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return util.EmptyString, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return util.EmptyString, err
+	}
+
+	var data map[string]interface{}
+	json.Unmarshal(body, &data)
+
+	if res.StatusCode != 200 {
+		msg := fmt.Sprintf("OAuth2 token request failed (HTTP %v)", res.StatusCode)
+		if data["error"] != nil {
+			msg = msg + ": " + fmt.Sprintf("%v", data["error"])
+			if data["error_description"] != nil {
+				msg = msg + " - " + fmt.Sprintf("%v", data["error_description"])
+			}
+		}
+		return util.EmptyString, errors.New(msg)
+	}
+
+	token, ok := data["access_token"].(string)
+	if !ok || token == util.EmptyString {
+		return util.EmptyString, errors.New("the OAuth2 token response does not contain an access token")
+	}
+
+	var expires time.Time
+	if seconds, ok := data["expires_in"].(float64); ok && seconds > 0 {
+		expires = time.Now().Add(time.Duration(seconds) * time.Second)
+	} else {
+		expires = jwtExpiry(token)
+	}
+
+	a.cache.set(a.CacheFile, a.tokenURL+"|"+a.clientID, token, expires)
+
+	return token, nil
+}
+
+func (a *ClientCredentialsAuth) Invalidate() bool {
+	a.cache.mu.Lock()
+	defer a.cache.mu.Unlock()
+
+	a.cache.clear(a.CacheFile)
+	return true
+}
+
+// tokenCache holds a token in memory and, optionally, in a file. Cached
+// tokens are keyed by a hash of the identity that requested them, so a cache
+// file is never reused for a different Datahub or client.
+type tokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type cachedToken struct {
+	Identity string    `json:"identity"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+func (c *tokenCache) valid() bool {
+	if c.token == util.EmptyString {
+		return false
+	}
+
+	return c.expires.IsZero() || time.Now().Add(tokenExpirySkew).Before(c.expires)
+}
+
+func (c *tokenCache) get(file string, identity string) (string, bool) {
+	if c.valid() {
+		return c.token, true
+	}
+
+	if file == util.EmptyString {
+		return util.EmptyString, false
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return util.EmptyString, false
+	}
+
+	var cached cachedToken
+	if json.Unmarshal(data, &cached) != nil || cached.Identity != hashIdentity(identity) {
+		return util.EmptyString, false
+	}
+
+	c.token = cached.Token
+	c.expires = cached.Expires
+
+	if !c.valid() {
+		c.token = util.EmptyString
+		return util.EmptyString, false
+	}
+
+	return c.token, true
+}
+
+func (c *tokenCache) set(file string, identity string, token string, expires time.Time) {
+	c.token = token
+	c.expires = expires
+
+	if file == util.EmptyString {
+		return
+	}
+
+	j, _ := json.Marshal(cachedToken{Identity: hashIdentity(identity), Token: token, Expires: expires})
+	if err := ioutil.WriteFile(file, j, 0600); err != nil {
+		slog.Warn("failed to cache the token", "file", file, "error", err)
+	}
+}
+
+func (c *tokenCache) clear(file string) {
+	c.token = util.EmptyString
+	c.expires = time.Time{}
+
+	if file != util.EmptyString {
+		ioutil.WriteFile(file, []byte("{}"), 0600)
+	}
+}
+
+func hashIdentity(identity string) string {
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:])
+}
+
+// jwtExpiry reads the exp claim of a JWT. A zero time is returned when the
+// token is not a JWT or has no expiry.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims map[string]interface{}
+	if json.Unmarshal(payload, &claims) != nil {
+		return time.Time{}
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,144 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"strings"
+)
+
+// Backup reads the complete data source (sets, items, keys, relationships,
+// descriptions, metadata and attributes) into a document. The document does
+// not contain Datahub IDs, so it can be restored into another data source or
+// Datahub instance.
+func (dh *Datahub) Backup() (*doc.Doc, error) {
+	if err := dh.PopulateSources(); err != nil {
+		return dh.doc, err
+	}
+
+	if err := dh.PopulateItems(archive.CreateDiff()); err != nil {
+		return dh.doc, err
+	}
+
+	if err := dh.PopulateRelationships(archive.CreateDiff()); err != nil {
+		return dh.doc, err
+	}
+
+	return dh.doc, nil
+}
+
+// Restore determines the changes required to make the data source match a
+// backup, returning the set, item and relationship diffs (in the order they
+// must be committed). Objects that already exist are updated, so curated
+// descriptions are overwritten by the backup. When prune is true, sets and
+// relationships that are not in the backup are deleted.
+func (dh *Datahub) Restore(backup *doc.Doc, prune bool) ([]*archive.Diff, error) {
+	sets := archive.CreateDiff()
+	items := archive.CreateDiff()
+	rels := archive.CreateDiff()
+
+	current, err := dh.Backup()
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := current.GetSchemas()
+	if len(schemas) == 0 {
+		return nil, errors.New("the " + dh.source + " data source could not be read")
+	}
+	target := schemas[0]
+
+	stub, _ := dh.sourcedata["stub"].(string)
+	if stub == util.EmptyString {
+		stub = strings.ToLower(current.Source().Name.Physical)
+	}
+
+	restored := map[string]bool{}
+	relationships := map[string]bool{}
+
+	for _, schema := range backup.GetSchemas() {
+		for _, set := range schema.Sets {
+			restored[set.ID()] = true
+
+			existing, err := target.GetSet(set.Name.Physical)
+			if err == nil && existing.Id != util.EmptyString {
+				set.Id = existing.Id
+				sets.Update(set, set.ID())
+
+				for _, item := range set.Items {
+					if curr, err := existing.GetItem(item.Name.Physical); err == nil {
+						item.Id = curr.Id
+					}
+					items.Update(item, set.ID()+"."+item.ID())
+				}
+			} else {
+				sets.Add(set, set.ID())
+
+				for _, item := range set.Items {
+					items.Add(item, set.ID()+"."+item.ID())
+				}
+			}
+		}
+
+		for _, rel := range schema.Relationships {
+			relationships[rel.ID()] = true
+
+			// The relationship items are identified by stub, which includes
+			// the data source stub.
+			for _, join := range rel.Items {
+				restub(join.Parent, stub)
+				restub(join.Child, stub)
+			}
+
+			if existing, exists := target.Relationships[rel.ID()]; exists {
+				rel.Id = existing.Id
+				rels.Update(rel, rel.ID())
+			} else {
+				rels.Add(rel, rel.ID())
+			}
+		}
+	}
+
+	if prune {
+		for _, set := range target.Sets {
+			if !restored[set.ID()] {
+				sets.Delete(set, set.ID())
+			}
+		}
+
+		for _, rel := range target.Relationships {
+			if relationships[rel.ID()] || rel.Id == util.EmptyString {
+				continue
+			}
+
+			// Relationships of deleted sets are removed with the set.
+			removed := false
+			for _, join := range rel.Items {
+				if !restored[strings.ToLower(join.Parent.Set)] || !restored[strings.ToLower(join.Child.Set)] {
+					removed = true
+				}
+			}
+
+			if !removed {
+				rels.Delete(rel, rel.ID())
+			}
+		}
+	}
+
+	return []*archive.Diff{sets, items, rels}, nil
+}
+
+func restub(ri *doc.RelItem, stub string) {
+	if ri == nil || ri.Schema == stub {
+		return
+	}
+
+	prefix := strings.ToLower(ri.Schema) + "."
+	if strings.HasPrefix(strings.ToLower(ri.FQDN), prefix) {
+		ri.FQDN = stub + "." + ri.FQDN[len(prefix):]
+	} else {
+		ri.FQDN = stub + "." + ri.Set + "." + ri.Item
+	}
+	ri.Schema = stub
+}
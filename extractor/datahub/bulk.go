@@ -0,0 +1,172 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"encoding/json"
+	"strings"
+)
+
+// DefaultBatchSize is the number of sets, items or relationships sent in a
+// single bulk request.
+const DefaultBatchSize = 500
+
+// SetBatchSize changes the number of objects sent in a single bulk request.
+// A size of 1 disables the bulk set endpoint.
+func (dh *Datahub) SetBatchSize(size int) {
+	dh.batch = size
+}
+
+func (dh *Datahub) batchSize() int {
+	if dh.batch <= 0 {
+		return DefaultBatchSize
+	}
+
+	return dh.batch
+}
+
+// chunk splits a list into batches of (at most) size elements.
+func chunk[T any](list []T, size int) [][]T {
+	batches := make([][]T, 0, len(list)/size+1)
+	for start := 0; start < len(list); start += size {
+		end := start + size
+		if end > len(list) {
+			end = len(list)
+		}
+		batches = append(batches, list[start:end])
+	}
+
+	return batches
+}
+
+// createSets adds sets to the Datahub in batches. The bulk endpoint does not
+// return the new set IDs, which are required to add items and relationships,
+// so they are resolved afterwards by name. When the bulk endpoint is not
+// supported or rejects a batch, sets are created one at a time.
+func (dh *Datahub) createSets(sets []*doc.Set) {
+	created := make([]*doc.Set, 0, len(sets))
+
+	for _, batch := range chunk(sets, dh.batchSize()) {
+		if dh.offline || dh.nobulk || len(batch) == 1 {
+			for _, set := range batch {
+				dh.createSet(set)
+			}
+			continue
+		}
+
+		list := make([]interface{}, 0, len(batch))
+		for _, set := range batch {
+			list = append(list, set.ToPostBody())
+		}
+
+		status, result, err := dh.post("/catalog/source/"+dh.sourceID()+"/sets", map[string]interface{}{"sets": list})
+		if dh.disconnected(status, err) {
+			for _, set := range batch {
+				dh.createSet(set)
+			}
+			continue
+		}
+
+		if status == 404 || status == 405 {
+			dh.log().Info("the Datahub does not support the bulk set endpoint; creating sets one at a time")
+			dh.nobulk = true
+			for _, set := range batch {
+				dh.createSet(set)
+			}
+			continue
+		}
+
+		// The Datahub rejects the whole batch when one set is invalid or
+		// already exists: the sets are then created one at a time, so only
+		// the offending sets fail.
+		if err != nil || (status != 200 && status != 201) {
+			dh.log().Warn("the Datahub rejected a batch of sets; creating them one at a time", "sets", len(batch), "status", status, "response", describe(result, err))
+			for _, set := range batch {
+				dh.createSet(set)
+			}
+			continue
+		}
+
+		// Use the IDs when the response happens to include them.
+		ids := map[string]string{}
+		if data, ok := result.(map[string]interface{}); ok {
+			indexNames(data["sets"], ids)
+		}
+
+		for _, set := range batch {
+			if id, exists := ids[strings.ToLower(strings.TrimSpace(set.Name.Physical))]; exists {
+				set.Id = id
+			}
+			created = append(created, set)
+		}
+	}
+
+	dh.resolveSetIDs(created)
+}
+
+// createSet adds a single set to the Datahub, which returns the new ID.
+func (dh *Datahub) createSet(set *doc.Set) {
+	body := set.ToPostBody()
+	status, result, err := dh.deliver(&archive.Operation{Action: "add", Kind: "set", Set: set.Name.Physical, Payload: body}, func() (int, interface{}, error) {
+		return dh.post("/catalog/source/"+dh.sourceID()+"/set", body)
+	})
+	if status != 201 && err == nil {
+		dh.log().Warn("unexpected response to the set creation", "set", set.Name.Physical, "status", status, "response", result)
+	}
+
+	data, _ := result.(map[string]interface{})
+	if err == ErrQueued {
+		dh.results.queue(1)
+	} else if err != nil {
+		dh.results.fail(1, "error creating %v set: %v", set.Name.Physical, err.Error())
+	} else if id, ok := data["id"].(string); !ok || id == util.EmptyString {
+		dh.results.fail(1, "error creating %v set (HTTP %v): no ID returned", set.Name.Physical, status)
+	} else {
+		set.Id = id
+		dh.results.succeed(1)
+	}
+}
+
+// resolveSetIDs finds the IDs of sets created through the bulk endpoint, with
+// a single request for all the sets of the data source. Sets missing from
+// that response are looked up by name.
+func (dh *Datahub) resolveSetIDs(sets []*doc.Set) {
+	if len(sets) == 0 {
+		return
+	}
+
+	ids := map[string]string{}
+	status, body, err := dh.get("/catalog/source/" + dh.sourceID() + "?expand=sets")
+	if err == nil && status == 200 {
+		var data map[string]interface{}
+		if json.Unmarshal(body, &data) == nil {
+			indexNames(data["sets"], ids)
+		}
+	}
+
+	for _, set := range sets {
+		if set.Id == util.EmptyString {
+			set.Id = ids[strings.ToLower(strings.TrimSpace(set.Name.Physical))]
+		}
+
+		if set.Id == util.EmptyString {
+			found, err := dh.LookupSet(set.Name.Physical, dh.sourceID())
+			if err != nil || found.Id == util.EmptyString {
+				dh.results.fail(1, "created %v set, but its ID could not be resolved: %v", set.Name.Physical, err)
+				continue
+			}
+			set.Id = found.Id
+		}
+
+		dh.results.succeed(1)
+	}
+}
+
+func describe(result interface{}, err error) interface{} {
+	if err != nil {
+		return err
+	}
+
+	return result
+}
@@ -0,0 +1,50 @@
+package datahub
+
+import (
+	"dhs/extractor/datahub/datahubtest"
+	"dhs/extractor/doc"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateSetsFallsBackWhenTheBatchIsRejected(t *testing.T) {
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The existing orders set makes the Datahub reject the whole batch.
+	src := server.AddSource("public")
+	src.Sets = append(src.Sets, &datahubtest.Set{ID: "set-orders", Name: datahubtest.Name{Physical: "orders"}})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dh, err := New(ts.URL, "public", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	sets := make([]*doc.Set, 0)
+	for _, name := range []string{"users", "orders", "invoices"} {
+		sets = append(sets, &doc.Set{Name: doc.Name{Physical: name}, Items: map[string]*doc.Item{}})
+	}
+	dh.createSets(sets)
+
+	results := dh.Results()
+	if results.Succeeded != 2 || results.Failed != 1 {
+		t.Errorf("succeeded %v, failed %v, want 2 and 1: %v", results.Succeeded, results.Failed, results.Errors)
+	}
+
+	for _, set := range []*doc.Set{sets[0], sets[2]} {
+		if set.Id == "" {
+			t.Errorf("the %v set has no ID", set.Name.Physical)
+		}
+	}
+
+	if got := len(server.Source("public").Sets); got != 3 {
+		t.Errorf("the data source has %v sets, want 3", got)
+	}
+}
@@ -0,0 +1,371 @@
+package datahub
+
+import (
+	"bytes"
+	"dhs/util"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// Headers that carry credentials. Their values are never written to a cassette.
+var sensitiveHeaders = []string{"authorization", "proxy-authorization", "cookie", "set-cookie", "x-api-key"}
+
+// Response fields that carry tokens.
+var sensitiveFields = []string{"jwt", "access_token", "refresh_token", "id_token"}
+
+type CassetteRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+	played   bool
+}
+
+// Cassette is an ordered list of Datahub HTTP interactions.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+	path         string
+	mu           sync.Mutex
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	c := &Cassette{path: path, Interactions: make([]*Interaction, 0)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return c, errors.New("invalid cassette " + path + ": " + err.Error())
+	}
+
+	return c, nil
+}
+
+func (c *Cassette) Save() error {
+	j, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.path, j, 0644)
+}
+
+// Recorder is an http.RoundTripper that writes every request and response
+// to a cassette file. Each interaction is appended as it happens, and the
+// file is kept valid, so traffic up to the point of a failure is preserved.
+// Close the recorder when the traffic is over.
+type Recorder struct {
+	cassette *Cassette
+	next     http.RoundTripper
+	file     *os.File
+	err      error
+}
+
+// The cassette file is written as a header, the interactions and a tail,
+// which is overwritten by the next interaction.
+const (
+	cassetteHeader = "{\n  \"interactions\": [\n    "
+	cassetteTail   = "\n  ]\n}\n"
+)
+
+func NewRecorder(path string, next ...http.RoundTripper) *Recorder {
+	r := &Recorder{
+		cassette: &Cassette{path: path, Interactions: make([]*Interaction, 0)},
+		next:     http.DefaultTransport,
+	}
+
+	if len(next) > 0 && next[0] != nil {
+		r.next = next[0]
+	}
+
+	return r
+}
+
+// RoundTrip sends the request and records the interaction. A cassette that
+// cannot be written does not fail the request: the error is logged and
+// returned by Close.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqbody []byte
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		reqbody = body
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	resbody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resbody))
+
+	interaction := &Interaction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     redactURL(req.URL.String()),
+			Headers: redactHeaders(req.Header),
+			Body:    rawJSON(reqbody),
+		},
+		Response: CassetteResponse{
+			Status:  res.StatusCode,
+			Headers: redactHeaders(res.Header),
+			Body:    rawJSON(redactTokens(resbody)),
+		},
+	}
+
+	r.cassette.mu.Lock()
+	defer r.cassette.mu.Unlock()
+
+	if err := r.append(interaction); err != nil && r.err == nil {
+		r.err = errors.New("failed to write cassette " + r.cassette.path + ": " + err.Error())
+		slog.Warn(r.err.Error())
+	}
+
+	return res, nil
+}
+
+// append writes an interaction at the end of the cassette file.
+func (r *Recorder) append(interaction *Interaction) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	j, err := json.MarshalIndent(interaction, "    ", "  ")
+	if err != nil {
+		return err
+	}
+
+	if r.file == nil {
+		file, err := os.Create(r.cassette.path)
+		if err != nil {
+			return err
+		}
+		r.file = file
+
+		if _, err := r.file.WriteString(cassetteHeader); err != nil {
+			return err
+		}
+	} else {
+		if _, err := r.file.Seek(-int64(len(cassetteTail)), io.SeekEnd); err != nil {
+			return err
+		}
+
+		if _, err := r.file.WriteString(",\n    "); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.file.Write(append(j, cassetteTail...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the cassette file. It returns the first error met while
+// recording.
+func (r *Recorder) Close() error {
+	r.cassette.mu.Lock()
+	defer r.cassette.mu.Unlock()
+
+	if r.file != nil {
+		if err := r.file.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
+		r.file = nil
+	}
+
+	return r.err
+}
+
+// Replayer is an http.RoundTripper that serves responses from a cassette
+// instead of the network. Each recorded interaction is served once, in the
+// order it was recorded.
+type Replayer struct {
+	cassette *Cassette
+}
+
+func NewReplayer(path string) (*Replayer, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return &Replayer{cassette: c}, err
+	}
+
+	return &Replayer{cassette: c}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqbody []byte
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		reqbody = body
+	}
+
+	r.cassette.mu.Lock()
+	defer r.cassette.mu.Unlock()
+
+	uri := requestURI(redactURL(req.URL.String()))
+	body := string(rawJSON(reqbody))
+
+	// Prefer an exact match (including the body), then fall back to the next
+	// unplayed interaction for the same method and URL.
+	var match *Interaction
+	for _, interaction := range r.cassette.Interactions {
+		if !interaction.played && interaction.Request.Method == req.Method && requestURI(interaction.Request.URL) == uri && string(interaction.Request.Body) == body {
+			match = interaction
+			break
+		}
+	}
+
+	if match == nil {
+		for _, interaction := range r.cassette.Interactions {
+			if !interaction.played && interaction.Request.Method == req.Method && requestURI(interaction.Request.URL) == uri {
+				match = interaction
+				break
+			}
+		}
+	}
+
+	if match == nil {
+		return nil, errors.New("no recorded response for " + req.Method + " " + uri + " in " + r.cassette.path)
+	}
+
+	match.played = true
+
+	header := http.Header{}
+	for key, value := range match.Response.Headers {
+		header.Set(key, value)
+	}
+
+	resbody := []byte(match.Response.Body)
+	var text string
+	if json.Unmarshal(resbody, &text) == nil {
+		resbody = []byte(text)
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(match.Response.Status),
+		StatusCode:    match.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(resbody)),
+		ContentLength: int64(len(resbody)),
+		Request:       req,
+	}, nil
+}
+
+// requestURI strips the scheme and host from a URL, so a cassette recorded
+// against one Datahub can be replayed regardless of the configured root.
+func requestURI(uri string) string {
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+		if j := strings.Index(uri, "/"); j >= 0 {
+			return uri[j:]
+		}
+		return "/"
+	}
+
+	return uri
+}
+
+func redactURL(uri string) string {
+	base := strings.Index(uri, "://")
+	at := strings.Index(uri, "@")
+	if base >= 0 && at > base {
+		return uri[:base+3] + uri[at+1:]
+	}
+
+	return uri
+}
+
+func redactHeaders(headers http.Header) map[string]string {
+	result := map[string]string{}
+	for key, values := range headers {
+		if util.InSlice[string](strings.ToLower(key), sensitiveHeaders) {
+			result[key] = redacted
+		} else {
+			result[key] = strings.Join(values, ", ")
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// redactTokens removes the tokens (the Datahub JWT and the OAuth2 access,
+// refresh and ID tokens) from a response, whichever endpoint returned it.
+func redactTokens(body []byte) []byte {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+
+	found := false
+	for _, field := range sensitiveFields {
+		if _, exists := data[field]; exists {
+			data[field] = redacted
+			found = true
+		}
+	}
+	if !found {
+		return body
+	}
+
+	j, _ := json.Marshal(data)
+	return j
+}
+
+// rawJSON stores JSON bodies as-is and everything else as a JSON string.
+func rawJSON(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if json.Valid(body) && json.Compact(&buf, body) == nil {
+		return json.RawMessage(buf.Bytes())
+	}
+
+	j, _ := json.Marshal(string(body))
+	return json.RawMessage(j)
+}
@@ -0,0 +1,63 @@
+package datahub
+
+import (
+	"dhs/extractor/datahub/datahubtest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorderRedactsOAuthTokens(t *testing.T) {
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ClientID, server.ClientSecret = "dhs", "s3cr3t"
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder := NewRecorder(path, http.DefaultTransport)
+
+	auth := NewClientCredentialsAuth(ts.URL+"/oauth/token", "dhs", "s3cr3t")
+	token, err := auth.Token(&http.Client{Transport: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Errorf("the cassette contains the access token:\n%s", data)
+	}
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("the cassette contains the client secret:\n%s", data)
+	}
+}
+
+func TestRedactTokens(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"jwt":"abc"}`, `{"jwt":"REDACTED"}`},
+		{`{"access_token":"a","refresh_token":"r","id_token":"i","expires_in":3600}`, `{"access_token":"REDACTED","expires_in":3600,"id_token":"REDACTED","refresh_token":"REDACTED"}`},
+		{`{"id":"set-1","name":"users"}`, `{"id":"set-1","name":"users"}`},
+		{`not json`, `not json`},
+	}
+
+	for _, test := range tests {
+		if got := string(redactTokens([]byte(test.body))); got != test.want {
+			t.Errorf("redactTokens(%v) = %v, want %v", test.body, got, test.want)
+		}
+	}
+}
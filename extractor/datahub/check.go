@@ -0,0 +1,82 @@
+package datahub
+
+import (
+	"dhs/extractor"
+	"dhs/util"
+	"errors"
+	"fmt"
+)
+
+// Check verifies the Datahub is reachable, accepts the credentials, has the
+// data source and lets the credentials write to it. The write check creates no
+// sets in the data source, which changes nothing, and must succeed: only a
+// Datahub that cannot be reached makes it a warning.
+func (dh *Datahub) Check() []*extractor.Check {
+	checks := []*extractor.Check{}
+
+	reachable := &extractor.Check{Name: "reach the Datahub", Detail: dh.root, Err: dh.CheckConnection()}
+	checks = append(checks, reachable)
+	if reachable.Err != nil {
+		reachable.Hint = "check datahub_url, and the datahub_tls and datahub_proxy settings"
+		return checks
+	}
+
+	credentials := &extractor.Check{Name: "authenticate with the Datahub"}
+	checks = append(checks, credentials)
+	status, _, err := dh.get("/catalog/sources")
+	switch {
+	case err != nil:
+		credentials.Err = err
+		credentials.Hint = "check api_key or the datahub_auth settings (user, password, client ID and secret)"
+	case status == 401 || status == 403:
+		credentials.Err = fmt.Errorf("the Datahub rejected the credentials (HTTP %v)", status)
+		credentials.Hint = "check api_key or the datahub_auth settings, and that the token has not expired or been revoked"
+		if dh.auth == nil {
+			credentials.Err = fmt.Errorf("the Datahub requires credentials (HTTP %v)", status)
+			credentials.Hint = "set api_key or datahub_auth"
+		}
+	case status != 200:
+		credentials.Err = fmt.Errorf("unexpected Datahub response (HTTP %v)", status)
+		credentials.Hint = "check datahub_url points to the root of the Datahub API"
+	default:
+		credentials.Detail = "no credentials required"
+		if dh.auth != nil {
+			credentials.Detail = "credentials accepted"
+		}
+	}
+	if credentials.Err != nil {
+		return checks
+	}
+
+	source := &extractor.Check{Name: "find the Datahub data source"}
+	checks = append(checks, source)
+	if dh.source == util.EmptyString {
+		source.Err = errors.New("the Datahub data source is not set")
+		source.Hint = "set datahub_source (or --datahub_source)"
+		return checks
+	}
+
+	if err := dh.PopulateSources(); err != nil {
+		source.Err = err
+		source.Hint = "create the data source in the Datahub, or sync with --create-source"
+		return checks
+	}
+	source.Detail = fmt.Sprintf("%v (ID %v)", dh.doc.Source().Name.Physical, dh.source)
+
+	write := &extractor.Check{Name: "write to the Datahub data source"}
+	checks = append(checks, write)
+	status, _, err = dh.post("/catalog/source/"+dh.sourceID()+"/sets", map[string]interface{}{"sets": []interface{}{}})
+	switch {
+	case status == 401 || status == 403:
+		write.Err = fmt.Errorf("the Datahub refused a write request (HTTP %v)", status)
+		write.Hint = "grant the token (or user) write access to the data source"
+	case status != 0 && (status < 200 || status > 299):
+		write.Err = fmt.Errorf("the Datahub did not accept a write request (HTTP %v)", status)
+		write.Hint = "check datahub_source names the data source, and the Datahub logs for the rejected request"
+	case err != nil:
+		write.Err = fmt.Errorf("the write permission could not be verified: %w", err)
+		write.Warning = true
+	}
+
+	return checks
+}
@@ -0,0 +1,76 @@
+package datahub
+
+import (
+	"dhs/extractor"
+	"dhs/extractor/datahub/datahubtest"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// checkWith runs the checks against the mock Datahub, with the responses of
+// the write probe replaced by status (none when zero).
+func checkWith(t *testing.T, status int) (*datahubtest.Server, *extractor.Check) {
+	t.Helper()
+
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := server.AddSource("public")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 && r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/sets") {
+			w.WriteHeader(status)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	dh, err := New(ts.URL, src.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	checks := dh.Check()
+	write := checks[len(checks)-1]
+	if write.Name != "write to the Datahub data source" {
+		t.Fatalf("the checks stopped at %q: %v", write.Name, write.Err)
+	}
+
+	return server, write
+}
+
+func TestCheckProbesTheDataSource(t *testing.T) {
+	server, write := checkWith(t, 0)
+	if write.Err != nil {
+		t.Errorf("the write check failed: %v", write.Err)
+	}
+
+	probe := "POST /catalog/source/" + server.Source("public").ID + "/sets"
+	found := false
+	for _, request := range server.Requests() {
+		found = found || request == probe
+	}
+	if !found {
+		t.Errorf("the data source was not probed (%v): %v", probe, server.Requests())
+	}
+
+	if sets := len(server.Source("public").Sets); sets != 0 {
+		t.Errorf("the probe created %v set(s)", sets)
+	}
+}
+
+func TestCheckRequiresASuccessfulWrite(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError} {
+		_, write := checkWith(t, status)
+		if !write.Failed() || write.Hint == "" {
+			t.Errorf("HTTP %v: err = %v, warning = %v, hint = %q, want a failure with a hint", status, write.Err, write.Warning, write.Hint)
+		}
+	}
+}
@@ -2,46 +2,80 @@ package datahub
 
 import (
 	"bytes"
+	"context"
 	"dhs/archive"
+	"dhs/extractor"
 	"dhs/extractor/doc"
 	"dhs/util"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Datahub struct {
 	root           string
-	token          string
+	auth           Authenticator
 	reattemptlogin bool
 	doc            *doc.Doc
 	source         string
 	sourcedata     map[string]interface{}
 	archive        *archive.Archive
+	transport      http.RoundTripper
+	template       *doc.Source
+	results        CommitResults
+	offline        bool
+	batch          int
+	nobulk         bool
+	nopatch        bool
+	policy         string
+	grace          time.Duration
+	maxdelete      int
+	maxpercent     float64
+	filter         *extractor.Filter
+	out            io.Writer
+	selection      extractor.Selection
+	managed        bool
+	fingerprint    string
+	adopt          bool
+	ctx            context.Context
+	stopping       bool
+	logger         *slog.Logger
 }
 
+// New creates a Datahub client. When an API key is supplied, it is used as
+// the bearer token. Otherwise, credentials embedded in the root URL are
+// removed from the URL and exchanged for a JWT (see BasicAuth). Use SetAuth
+// to configure any other authentication provider.
 func New(root string, datasource string, a *archive.Archive, apikey ...string) (*Datahub, error) {
-	root = util.EncodeURL(root, apikey...)
-
-	_, err := url.Parse(root)
+	uri, err := url.Parse(util.EncodeURL(root))
 	if err != nil {
 		return &Datahub{}, err
 	}
 
-	var api_key string
-	if len(apikey) > 0 {
-		api_key = apikey[0]
+	var auth Authenticator
+	if len(apikey) > 0 && apikey[0] != util.EmptyString {
+		auth = NewAPIKeyAuth(apikey[0])
+	} else if uri.User != nil {
+		pwd, _ := uri.User.Password()
+		user := uri.User.Username()
+		uri.User = nil
+		auth = NewBasicAuth(uri.String(), user, pwd)
 	}
+	uri.User = nil
 
 	return &Datahub{
-		root:           root,
+		root:           strings.TrimRight(uri.String(), "/"),
+		auth:           auth,
 		reattemptlogin: true,
-		token:          api_key,
 		// doc: a.Doc(),
 		doc: doc.New(&doc.Source{
 			Name: doc.Name{Physical: datasource},
@@ -52,10 +86,93 @@ func New(root string, datasource string, a *archive.Archive, apikey ...string) (
 	}, nil
 }
 
+// SetLogger sets the logger of the client (slog.Default by default).
+func (dh *Datahub) SetLogger(logger *slog.Logger) {
+	dh.logger = logger
+	dh.results.logger = logger
+}
+
+func (dh *Datahub) log() *slog.Logger {
+	if dh.logger == nil {
+		return slog.Default()
+	}
+
+	return dh.logger
+}
+
 func (dh *Datahub) SetDoc(d *doc.Doc) {
 	dh.doc = d
 }
 
+// SetTransport overrides the HTTP transport used for every Datahub request,
+// i.e. to record or replay traffic. Use Transport to wrap the current one.
+func (dh *Datahub) SetTransport(rt http.RoundTripper) {
+	dh.transport = rt
+}
+
+func (dh *Datahub) Transport() http.RoundTripper {
+	return dh.transport
+}
+
+// Close releases the transport, i.e. closes the cassette of a recorder.
+func (dh *Datahub) Close() error {
+	if closer, ok := dh.transport.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (dh *Datahub) client() *http.Client {
+	return &http.Client{Transport: dh.transport}
+}
+
+// SetFilter leaves the sets, items and relationships excluded by a filter out
+// of the Datahub metadata, so they are neither updated nor deleted.
+func (dh *Datahub) SetFilter(f *extractor.Filter) {
+	dh.filter = f
+}
+
+// SetSelection restricts the deletion plan to the selected elements: the
+// deprecations of objects that are not synced are left as they are.
+func (dh *Datahub) SetSelection(s extractor.Selection) {
+	dh.selection = s
+}
+
+// SetAuth replaces the authentication provider.
+func (dh *Datahub) SetAuth(auth Authenticator) {
+	dh.auth = auth
+}
+
+// authorize applies the bearer token (if any) to a request.
+func (dh *Datahub) authorize(req *http.Request) error {
+	if dh.auth == nil {
+		return nil
+	}
+
+	token, err := dh.auth.Token(dh.client())
+	if err != nil {
+		return connectionError(err)
+	}
+
+	if token != util.EmptyString {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	return nil
+}
+
+// reauthenticate discards the current token after a 401 response. It
+// returns true when the request should be attempted once more.
+func (dh *Datahub) reauthenticate() bool {
+	if !dh.reattemptlogin || dh.auth == nil || !dh.auth.Invalidate() {
+		return false
+	}
+
+	dh.reattemptlogin = false
+	return true
+}
+
 func (dh *Datahub) GetDoc() *doc.Doc {
 	return dh.doc
 }
@@ -91,18 +208,26 @@ func (dh *Datahub) PopulateSources() error {
 			if sources, exist := d["sources"]; exist {
 				for _, source := range sources.([]interface{}) {
 					src := source.(map[string]interface{})
-					if src["id"] != nil && src["id"].(string) != dh.source {
-						dh.source = src["id"].(string)
-						dh.doc.Source().Name.Physical = src["name"].(map[string]interface{})["physical"].(string)
-						dh.doc.Source().Name.Logical = src["name"].(map[string]interface{})["logical"].(string)
-						return dh.PopulateSources()
+					if src["id"] == nil || !matchesSource(src, id) {
+						continue
 					}
+
+					if src["id"].(string) == id {
+						return errors.New("the " + id + " data source is listed by the Datahub but cannot be retrieved")
+					}
+
+					dh.source = src["id"].(string)
+					return dh.PopulateSources()
 				}
 			}
 
-			return nil
+			if dh.template != nil {
+				return dh.createSource()
+			}
+
+			return errors.New("\"" + id + "\" does not match the ID, physical name or logical name of any Datahub data source (use --create-source to create it)")
 		} else {
-			fmt.Printf("HTTP response status code %v\n", cd)
+			dh.log().Error("unexpected Datahub response", "status", cd, "source", id)
 			return errors.New("failed to return " + id + " data source.")
 		}
 	}
@@ -120,6 +245,8 @@ func (dh *Datahub) PopulateSources() error {
 		Logical:  data["name"].(map[string]interface{})["logical"].(string),
 		Physical: data["name"].(map[string]interface{})["physical"].(string),
 	}
+	src.Comment, _ = data["description"].(string)
+	src.Metadata, _ = data["metadata"].(map[string]interface{})
 
 	schema := dh.doc.ApplySchema(&doc.Schema{
 		Name:          doc.Name{Physical: src.Name.Physical},
@@ -153,6 +280,10 @@ func (dh *Datahub) PopulateSources() error {
 					s.Source = src.(string)
 				}
 			}
+
+			if set["attributes"] != nil {
+				s.Attributes, _ = set["attributes"].(map[string]interface{})
+			}
 		}
 	}
 
@@ -160,9 +291,65 @@ func (dh *Datahub) PopulateSources() error {
 
 	// fmt.Println(string(dh.doc.ToJSON()))
 
+	dh.filter.Apply(dh.doc)
+
 	return nil
 }
 
+// matchesSource determines whether a data source returned by the Datahub is
+// identified by the specified ID, physical name or logical name.
+func matchesSource(src map[string]interface{}, id string) bool {
+	id = strings.TrimSpace(id)
+	if value, ok := src["id"].(string); ok && value == id {
+		return true
+	}
+
+	if name, ok := src["name"].(map[string]interface{}); ok {
+		for _, key := range []string{"physical", "logical"} {
+			if value, ok := name[key].(string); ok && len(strings.TrimSpace(value)) > 0 && strings.EqualFold(strings.TrimSpace(value), id) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CreateMissingSource instructs PopulateSources to create the Datahub data
+// source (named after the configured source) when it does not exist. The
+// description, extractor type and metadata are taken from src.
+func (dh *Datahub) CreateMissingSource(src *doc.Source) {
+	dh.template = src
+}
+
+func (dh *Datahub) createSource() error {
+	body := dh.template.ToPostBody()
+	body["name"] = doc.Name{Physical: dh.source, Logical: dh.source}
+
+	if dh.template.Name.Physical != util.EmptyString && dh.template.Name.Physical != dh.source {
+		if body["metadata"] == nil {
+			body["metadata"] = make(map[string]interface{})
+		}
+		body["metadata"].(map[string]interface{})["database"] = dh.template.Name.Physical
+	}
+
+	dh.log().Info("creating the data source in the Datahub", "source", dh.source)
+	status, result, err := dh.post("/catalog/source", body)
+	if err != nil {
+		return errors.New("failed to create the " + dh.source + " data source: " + err.Error())
+	}
+
+	data, ok := result.(map[string]interface{})
+	if !ok || data["id"] == nil {
+		return errors.New(fmt.Sprintf("failed to create the %v data source (HTTP %v): no ID returned", dh.source, status))
+	}
+
+	dh.source = data["id"].(string)
+	dh.template = nil
+
+	return dh.PopulateSources()
+}
+
 func (dh *Datahub) PopulateItems(diff *archive.Diff) error {
 	id := dh.source
 	uri := "/catalog/source/" + id + "/sets"
@@ -192,7 +379,7 @@ func (dh *Datahub) PopulateItems(diff *archive.Diff) error {
 			if items, exist := d["items"]; exist {
 				for _, item := range items.([]interface{}) {
 					i := item.(map[string]interface{})
-					fmt.Println(i)
+					dh.log().Debug("Datahub item", "item", i)
 					// if i["id"] != nil && i["id"].(string) != dh.item {
 					// 	// dh.source = src["id"].(string)
 					// 	// dh.doc.Source().Name.Physical = src["name"].(map[string]interface{})["physical"].(string)
@@ -204,7 +391,7 @@ func (dh *Datahub) PopulateItems(diff *archive.Diff) error {
 
 			return nil
 		} else {
-			fmt.Printf("HTTP response status code %v for GET %v\n", cd, uri)
+			dh.log().Error("unexpected Datahub response", "status", cd, "method", "GET", "endpoint", uri)
 			return errors.New("failed to return " + id + " data set.")
 		}
 	}
@@ -225,7 +412,12 @@ func (dh *Datahub) PopulateItems(diff *archive.Diff) error {
 			Name: doc.Name{Physical: src.Name.Physical},
 		})
 
-		set, err := schema.GetSet(record["name"].(map[string]interface{})["physical"].(string))
+		name := record["name"].(map[string]interface{})["physical"].(string)
+		if !dh.filter.Set(name) {
+			continue
+		}
+
+		set, err := schema.GetSet(name)
 		if err != nil {
 			return err
 		}
@@ -256,6 +448,9 @@ func (dh *Datahub) PopulateItems(diff *archive.Diff) error {
 			if i["metadata"] != nil {
 				item.Metadata = i["metadata"].(map[string]interface{})
 			}
+			if i["attributes"] != nil {
+				item.Attributes, _ = i["attributes"].(map[string]interface{})
+			}
 			if i["key"] != nil {
 				if _, ok := i["key"].([]interface{}); ok {
 					keys := i["key"].([]interface{})
@@ -298,6 +493,8 @@ func (dh *Datahub) PopulateItems(diff *archive.Diff) error {
 		}
 	}
 
+	dh.filter.Apply(dh.doc)
+
 	return nil
 }
 
@@ -310,7 +507,7 @@ func (dh *Datahub) PopulateRelationships(diff *archive.Diff) error {
 	}
 
 	if cd != 200 {
-		fmt.Println(string(body))
+		dh.log().Error("unexpected Datahub response", "status", cd, "method", "GET", "endpoint", uri, "body", string(body))
 		return errors.New(string(body))
 	}
 
@@ -328,11 +525,14 @@ func (dh *Datahub) PopulateRelationships(diff *archive.Diff) error {
 
 	for _, relationship := range data["relationships"].([]interface{}) {
 		raw := relationship.(map[string]interface{})
+		if dh.filtered(raw) {
+			continue
+		}
 
 		if raw["items"].([]interface{})[0].(map[string]interface{})["parent"] != nil {
 			set, err := schema.GetSet(raw["items"].([]interface{})[0].(map[string]interface{})["parent"].(map[string]interface{})["set"].(map[string]interface{})["name"].(map[string]interface{})["physical"].(string))
 			if err != nil {
-				fmt.Println(err)
+				dh.log().Warn("failed to identify relationship set", "relationship", raw["id"], "error", err)
 			}
 
 			rel := schema.UpsertRelationship(&doc.Relationship{
@@ -352,6 +552,14 @@ func (dh *Datahub) PopulateRelationships(diff *archive.Diff) error {
 				Set:   set,
 			})
 
+			if raw["metadata"] != nil {
+				rel.Metadata, _ = raw["metadata"].(map[string]interface{})
+			}
+
+			if raw["attributes"] != nil {
+				rel.Attributes, _ = raw["attributes"].(map[string]interface{})
+			}
+
 			for _, item := range raw["items"].([]interface{}) {
 				i := item.(map[string]interface{})
 
@@ -388,32 +596,31 @@ func (dh *Datahub) PopulateRelationships(diff *archive.Diff) error {
 	return nil
 }
 
-func (dh *Datahub) getAuthToken() error {
-	if dh.token != util.EmptyString {
-		return nil
-	}
-
-	fmt.Println("  authenticating with Datahub service...")
-	cd, body, err := dh.get("/token")
-	if err != nil {
-		return err
-	}
-
-	if cd != 200 {
-		fmt.Printf("access denied (response code %v)\n", cd)
-		return errors.New("access denied")
+// filtered determines whether a relationship returned by the Datahub joins
+// sets or items excluded by the filter (see SetFilter).
+func (dh *Datahub) filtered(raw map[string]interface{}) bool {
+	if dh.filter.Empty() {
+		return false
 	}
 
-	var data map[string]interface{}
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return err
+	items, _ := raw["items"].([]interface{})
+	for _, item := range items {
+		join, _ := item.(map[string]interface{})
+		for _, side := range []string{"parent", "child"} {
+			end, _ := join[side].(map[string]interface{})
+			set, _ := end["set"].(map[string]interface{})
+			setname, _ := set["name"].(map[string]interface{})
+			name, _ := end["name"].(map[string]interface{})
+
+			physical, _ := setname["physical"].(string)
+			item, _ := name["physical"].(string)
+			if !dh.filter.Item(physical, item) {
+				return true
+			}
+		}
 	}
 
-	dh.token = data["jwt"].(string)
-	dh.reattemptlogin = true
-
-	return nil
+	return false
 }
 
 func (dh *Datahub) Get(endpoint string) (int, []byte, error) {
@@ -426,32 +633,24 @@ func (dh *Datahub) get(endpoint string) (int, []byte, error) {
 		return 0, util.EmptyByte, err
 	}
 
-	req, err := http.NewRequest("GET", uri.String(), nil)
+	req, err := http.NewRequestWithContext(dh.context(), "GET", uri.String(), nil)
 	if err != nil {
 		return 0, []byte{}, err
 	}
 
-	if dh.token != util.EmptyString {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", dh.token))
+	if err := dh.authorize(req); err != nil {
+		return 0, util.EmptyByte, err
 	}
 
-	client := &http.Client{}
-
-	res, err := client.Do(req)
+	res, err := dh.client().Do(req)
 	if err != nil {
-		return 0, util.EmptyByte, err
+		return 0, util.EmptyByte, connectionError(err)
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == 401 && dh.reattemptlogin {
-		dh.reattemptlogin = false
-		dh.token = util.EmptyString
-
-		err = dh.getAuthToken()
-		if err == nil {
-			dh.reattemptlogin = true
-			return dh.get(endpoint)
-		}
+	if res.StatusCode == 401 && dh.reauthenticate() {
+		defer func() { dh.reattemptlogin = true }()
+		return dh.get(endpoint)
 	}
 
 	// fmt.Print("GET %v%v%v\n", dh.root, endpoint)
@@ -465,24 +664,14 @@ func (dh *Datahub) get(endpoint string) (int, []byte, error) {
 }
 
 func (dh *Datahub) send(method string, endpoint string, data interface{}) (int, interface{}, error) {
-	fmt.Printf("  HTTP %v %v\n", method, endpoint)
-
-	// if method == "POST" {
-	// 	util.DumpLog("./post.log", map[string]interface{}{
-	// 		"endpoint": endpoint,
-	// 		"data":     data,
-	// 	})
-	// }
+	var res interface{}
+	if err := dh.context().Err(); err != nil {
+		return int(0), res, err
+	}
 
-	// if method == "PUT" {
-	// 	util.DumpLog("./put.log", map[string]interface{}{
-	// 		"endpoint": endpoint,
-	// 		"data":     data,
-	// 	})
-	// }
+	dh.log().Debug("Datahub request", "method", method, "endpoint", endpoint)
 
 	body, _ := json.Marshal(data)
-	var res interface{}
 
 	uri, err := url.Parse(dh.root + endpoint)
 	if err != nil {
@@ -494,28 +683,21 @@ func (dh *Datahub) send(method string, endpoint string, data interface{}) (int,
 		return int(0), res, err
 	}
 
-	if dh.token != util.EmptyString {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", dh.token))
+	if err := dh.authorize(req); err != nil {
+		return int(0), res, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := http.Client{}
-	response, err := client.Do(req)
+	response, err := dh.client().Do(req)
 	if err != nil {
-		return int(0), res, err
+		return int(0), res, connectionError(err)
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode == 401 && dh.reattemptlogin {
-		dh.reattemptlogin = false
-		dh.token = util.EmptyString
-
-		err = dh.getAuthToken()
-		if err == nil {
-			dh.reattemptlogin = true
-			return dh.send(method, endpoint, data)
-		}
+	if response.StatusCode == 401 && dh.reauthenticate() {
+		defer func() { dh.reattemptlogin = true }()
+		return dh.send(method, endpoint, data)
 	}
 
 	content, err := ioutil.ReadAll(response.Body)
@@ -569,8 +751,12 @@ func (dh *Datahub) Delete(endpoint string, data ...interface{}) (int, interface{
 }
 
 func (dh *Datahub) delete(endpoint string, data ...interface{}) (int, interface{}, error) {
-	fmt.Printf("  HTTP DELETE %v\n", endpoint)
 	var res interface{}
+	if err := dh.context().Err(); err != nil {
+		return 0, res, err
+	}
+
+	dh.log().Debug("Datahub request", "method", "DELETE", "endpoint", endpoint)
 
 	uri, err := url.Parse(dh.root + endpoint)
 	if err != nil {
@@ -589,26 +775,19 @@ func (dh *Datahub) delete(endpoint string, data ...interface{}) (int, interface{
 		return 0, res, err
 	}
 
-	if dh.token != util.EmptyString {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", dh.token))
+	if err := dh.authorize(req); err != nil {
+		return 0, res, err
 	}
 
-	client := http.Client{}
-	response, err := client.Do(req)
+	response, err := dh.client().Do(req)
 	if err != nil {
-		return 0, res, err
+		return 0, res, connectionError(err)
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode == 401 && dh.reattemptlogin {
-		dh.reattemptlogin = false
-		dh.token = util.EmptyString
-
-		err = dh.getAuthToken()
-		if err == nil {
-			dh.reattemptlogin = true
-			return dh.delete(endpoint, data...)
-		}
+	if response.StatusCode == 401 && dh.reauthenticate() {
+		defer func() { dh.reattemptlogin = true }()
+		return dh.delete(endpoint, data...)
 	}
 
 	// if len(data) > 0 {
@@ -626,124 +805,140 @@ func (dh *Datahub) delete(endpoint string, data ...interface{}) (int, interface{
 	return response.StatusCode, resbody, nil
 }
 
+// SetOutput redirects the previews of the changes (see DryRun), which are
+// written to the standard output by default.
+func (dh *Datahub) SetOutput(w io.Writer) {
+	dh.out = w
+}
+
+// preview writes a heading and (at most max of) the objects it applies to.
+func (dh *Datahub) preview(heading string, symbol string, lines []string, max int) {
+	out := dh.out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	fmt.Fprintf(out, "  %v\n", heading)
+	for i, line := range lines {
+		if i == max {
+			fmt.Fprintf(out, "    %v and more...\n", symbol)
+			break
+		}
+		fmt.Fprintf(out, "    %v %v\n", symbol, line)
+	}
+}
+
+// DryRun previews the changes of a diff. The datatype (set, item,
+// relationship or join) describes the objects of the diff.
 func (dh *Datahub) DryRun(d *archive.Diff, max int, datatype ...string) {
 	data := "set"
 	if len(datatype) > 0 {
 		data = datatype[0]
 	}
 
-	if len(d.Added) > 0 {
-		fmt.Printf("  %v %v(s) will be added to the Datahub\n", len(d.Added), data)
-		i := 0
-		for _, el := range d.Added {
-			i++
-			if i <= max {
-				if data == "set" {
-					fmt.Printf("    + %v\n", el.(*doc.Set).Name.Physical)
-				} else if data == "item" {
-					i := el.(*doc.Item)
-					fmt.Printf("    + %v.%v\n", i.Set().Name.Physical, i.Name.Physical)
-				} else if data == "join" {
-					i := el.(*doc.Join)
-					fmt.Printf("    + %v -> %v\n", i.Parent.FQDN, i.Child.FQDN)
-				} else {
-					i := el.(*doc.Relationship)
-					fmt.Printf("    + %v\n", i.Name.Physical)
-				}
-			} else if i == (max + 1) {
-				fmt.Printf("    + and more...\n")
-				break
-			}
+	for _, group := range []struct {
+		symbol string
+		label  string
+		list   []interface{}
+	}{
+		{"+", "added to", d.Added},
+		{"-", "removed from", d.Deleted},
+		{"!", "updated in", d.Updated},
+	} {
+		if len(group.list) == 0 {
+			continue
 		}
-	}
 
-	if len(d.Deleted) > 0 {
-		fmt.Printf("\n  %v %v(s) will be removed from the Datahub\n", len(d.Deleted), data)
-		i := 0
-		for _, el := range d.Deleted {
-			i++
-			if i <= max {
-				if data == "set" {
-					s := el.(*doc.Set)
-					fmt.Printf("    - %v (%v)\n", s.Name.Physical, s.Id)
-				} else if data == "item" {
-					s := el.(*doc.Item)
-					fmt.Printf("    - %v.%v (%v)\n", s.Set().Name.Physical, s.Name.Physical, s.Id)
-				} else if data == "join" {
-					s := el.(*doc.Join)
-					fmt.Printf("    + %v -> %v\n", s.Parent.FQDN, s.Child.FQDN)
-				} else {
-					s := el.(*doc.Relationship)
-					fmt.Printf("    - %v (%v)\n", s.Name.Physical, s.Id)
-				}
-			} else if i == (max + 1) {
-				fmt.Printf("    - and more...\n")
-				break
+		lines := make([]string, 0, len(group.list))
+		for _, el := range group.list {
+			var name, id string
+			var fields []string
+			switch value := el.(type) {
+			case *doc.Set:
+				name, id, fields = value.Name.Physical, value.Id, value.UpdateFields
+			case *doc.Item:
+				name, id, fields = value.Set().Name.Physical+"."+value.Name.Physical, value.Id, value.UpdateFields
+			case *doc.Join:
+				name = value.Parent.FQDN + " -> " + value.Child.FQDN
+			case *doc.Relationship:
+				name, id, fields = value.Name.Physical, value.Id, value.UpdateFields
 			}
-		}
-	}
 
-	if len(d.Updated) > 0 {
-		fmt.Printf("\n  %v %v(s) will be updated in the Datahub\n", len(d.Updated), data)
-		i := 0
-		for _, el := range d.Updated {
-			i++
-			if i <= max {
-				if data == "set" {
-					fmt.Printf("    ! %v (%v)\n", el.(*doc.Set).Name.Physical, el.(*doc.Set).Id)
-				} else if data == "item" {
-					fmt.Printf("    ! %v.%v (%v)\n", el.(*doc.Item).Set().Name.Physical, el.(*doc.Item).Name.Physical, el.(*doc.Item).Id)
-				} else if data == "join" {
-					fmt.Printf("    + %v -> %v\n", el.(*doc.Join).Parent.FQDN, el.(*doc.Join).Child.FQDN)
-				} else {
-					fmt.Printf("    ! %v (%v)\n", el.(*doc.Relationship).Name.Physical, el.(*doc.Relationship).Id)
-				}
-			} else if i == (max + 1) {
-				fmt.Printf("    ! and more...\n")
-				break
+			if id != util.EmptyString {
+				name = name + " (" + id + ")"
+			}
+			if len(fields) > 0 {
+				name = name + " changed: " + strings.Join(fields, ", ")
 			}
+			lines = append(lines, name)
 		}
+
+		dh.preview(fmt.Sprintf("%v %v(s) will be %v the Datahub", len(group.list), data, group.label), group.symbol, lines, max)
 	}
 }
 
 func (dh *Datahub) Commit(diffs ...*archive.Diff) error {
 	for _, d := range diffs {
+		for _, list := range [][]interface{}{d.Added, d.Updated} {
+			for _, obj := range list {
+				dh.stamp(obj)
+			}
+		}
+
 		// Deletions
 		rels := []string{}
 		if len(d.Deleted) > 0 {
-			fmt.Println("\n  committing deletions...")
+			dh.log().Info("committing deletions...", "count", len(d.Deleted))
 			var status int
 			var err error
 
 			for _, obj := range d.Deleted {
 				switch value := obj.(type) {
 				case *doc.Set:
-					status, _, err = dh.delete("/catalog/set/" + value.Id)
-					if err != nil || status != 200 {
-						fmt.Printf("Error deleting set %v (HTTP %v)\n%v\n", value.Id, status, err)
+					status, _, err = dh.deliver(&archive.Operation{Action: "delete", Kind: "set", Set: value.Name.Physical, Target: value.Id}, func() (int, interface{}, error) {
+						return dh.delete("/catalog/set/" + value.Id)
+					})
+					if err == ErrQueued {
+						dh.results.queue(1)
+					} else if err != nil || status != 200 {
+						dh.results.fail(1, "Error deleting set %v (HTTP %v)\n%v", value.Id, status, err)
+					} else {
+						dh.results.succeed(1)
 					}
 				case *doc.Item:
-					status, _, err = dh.delete("/catalog/item/" + value.Id)
-					if err != nil || status != 200 {
-						fmt.Printf("Error deleting item %v (HTTP %v)\n%v\n", value.Id, status, err)
+					status, _, err = dh.deliver(&archive.Operation{Action: "delete", Kind: "item", Set: value.Set().Name.Physical, Name: value.Name.Physical, Target: value.Id}, func() (int, interface{}, error) {
+						return dh.delete("/catalog/item/" + value.Id)
+					})
+					if err == ErrQueued {
+						dh.results.queue(1)
+					} else if err != nil || status != 200 {
+						dh.results.fail(1, "Error deleting item %v (HTTP %v)\n%v", value.Id, status, err)
+					} else {
+						dh.results.succeed(1)
 					}
 				case *doc.Relationship:
 					rels = append(rels, value.Id)
 				}
 			}
 
-			if len(rels) > 0 {
-				status, b, err := dh.delete("/catalog/relationships", map[string]interface{}{
-					"relationships": rels,
+			for _, batch := range chunk(rels, dh.batchSize()) {
+				body := map[string]interface{}{
+					"relationships": batch,
+				}
+				status, b, err := dh.deliver(&archive.Operation{Action: "delete", Kind: "relationship", Payload: body}, func() (int, interface{}, error) {
+					return dh.delete("/catalog/relationships", body)
 				})
 
 				// util.Dump(map[string]interface{}{
 				// 	"relationships": rels,
 				// })
 
-				if err != nil || status != 200 {
-					fmt.Printf("Error deleting relationships (HTTP %v)\n", status)
-					fmt.Println(b)
+				if err == ErrQueued {
+					dh.results.queue(len(batch))
+				} else if err != nil || status != 200 {
+					dh.results.fail(len(batch), "Error deleting relationships (HTTP %v)\n%v", status, b)
+				} else {
+					dh.results.succeed(len(batch))
 				}
 			}
 			// } else {
@@ -752,37 +947,48 @@ func (dh *Datahub) Commit(diffs ...*archive.Diff) error {
 
 		// Additions
 		if len(d.Added) > 0 {
-			fmt.Println("\n  committing additions...")
+			dh.log().Info("committing additions...", "count", len(d.Added))
 			items := make(map[string][]interface{})
+			setnames := make(map[string]string)
 			rels := make([]map[string]interface{}, 0)
+
+			// Sets are created first, since the new IDs are required to add
+			// or **update** items and relationships.
+			sets := make([]*doc.Set, 0)
+			for _, obj := range d.Added {
+				if value, ok := obj.(*doc.Set); ok {
+					sets = append(sets, value)
+				}
+			}
+			dh.createSets(sets)
+
 			for _, obj := range d.Added {
 				switch value := obj.(type) {
-				case *doc.Set:
-					// The bulk endpoint is not used because it does not return the new ID for each set.
-					// The new ID is required to add or **update** items and relationships.
-					status, result, err := dh.post("/catalog/source/"+dh.sourcedata["id"].(string)+"/set", value.ToPostBody())
-					if status != 201 && err == nil {
-						fmt.Println(result)
-					}
-					if err != nil {
-						fmt.Printf("error creating %v set: %v\n", value.Name.Physical, err.Error())
-					} else {
-						value.Id = result.(map[string]interface{})["id"].(string)
-					}
 				case *doc.Item:
 					id := value.Set().Id
 					if id == util.EmptyString {
 						s, err := value.Set().GetSchemaObject().GetSet(value.Set().Name.Physical)
 						if err != nil {
-							fmt.Println(err)
+							dh.log().Warn("failed to identify item set", "item", value.FQDN, "error", err)
 						}
 
 						if s.Id == util.EmptyString {
 							tmpset, err := dh.LookupSet(value.Set().Name.Physical, dh.sourcedata["id"].(string))
 							if err == nil {
 								id = tmpset.Id
+							} else if dh.disconnected(0, err) {
+								// The set ID is resolved by name when the outbox is flushed.
+								err = dh.enqueue(&archive.Operation{Action: "add", Kind: "item", Set: value.Set().Name.Physical, Name: value.Name.Physical, Payload: map[string]interface{}{
+									"items": []interface{}{value.ToPostBody()},
+								}})
+								if err == ErrQueued {
+									dh.results.queue(1)
+								} else {
+									dh.results.fail(1, "cannot add %v item: %v", value.FQDN, err)
+								}
+								break
 							} else {
-								fmt.Println(err)
+								dh.results.fail(1, "cannot add %v item: %v", value.FQDN, err)
 								break
 							}
 						} else {
@@ -794,6 +1000,7 @@ func (dh *Datahub) Commit(diffs ...*archive.Diff) error {
 						items[id] = make([]interface{}, 0)
 					}
 					items[id] = append(items[id], value.ToPostBody())
+					setnames[id] = value.Set().Name.Physical
 				case *doc.Relationship:
 					rels = append(rels, value.ToPostBody())
 				}
@@ -802,23 +1009,42 @@ func (dh *Datahub) Commit(diffs ...*archive.Diff) error {
 			if len(items) > 0 {
 				for id, body := range items {
 					if id == util.EmptyString || len(strings.TrimSpace(id)) == 0 {
-						fmt.Println("Failed to add item (no set associated with item):")
-						util.Dump(body)
+						dh.results.fail(len(body), "Failed to add %v item(s) (no set associated with item)", len(body))
+						dh.log().Debug("items without a set", "items", body)
 					} else {
-						dh.post("/catalog/set/"+id+"/items", map[string]interface{}{
-							"items": body,
-						})
+						for _, batch := range chunk(body, dh.batchSize()) {
+							data := map[string]interface{}{
+								"items": batch,
+							}
+							status, _, err := dh.deliver(&archive.Operation{Action: "add", Kind: "item", Set: setnames[id], Target: id, Payload: data}, func() (int, interface{}, error) {
+								return dh.post("/catalog/set/"+id+"/items", data)
+							})
+							if err == ErrQueued {
+								dh.results.queue(len(batch))
+							} else if err != nil {
+								dh.results.fail(len(batch), "error adding items to set %v (HTTP %v): %v", id, status, err)
+							} else {
+								dh.results.succeed(len(batch))
+							}
+						}
 					}
 				}
 			}
 
-			if len(rels) > 0 {
-				status, _, err := dh.post("/catalog/relationships", map[string]interface{}{
-					"relationships": rels,
+			for _, batch := range chunk(rels, dh.batchSize()) {
+				body := map[string]interface{}{
+					"relationships": batch,
+				}
+				status, _, err := dh.deliver(&archive.Operation{Action: "add", Kind: "relationship", Payload: body}, func() (int, interface{}, error) {
+					return dh.post("/catalog/relationships", body)
 				})
 
-				if err != nil {
-					fmt.Printf("error creating relationships (HTTP %v): %v\n", status, err.Error())
+				if err == ErrQueued {
+					dh.results.queue(len(batch))
+				} else if err != nil {
+					dh.results.fail(len(batch), "error creating relationships (HTTP %v): %v", status, err.Error())
+				} else {
+					dh.results.succeed(len(batch))
 				}
 			}
 			// } else {
@@ -826,59 +1052,154 @@ func (dh *Datahub) Commit(diffs ...*archive.Diff) error {
 		}
 
 		// Updates
+		// Objects with known changes (UpdateFields) are sent as partial
+		// updates, so fields owned by the Datahub or its stewards are kept.
 		if len(d.Updated) > 0 {
-			fmt.Println("\n  committing updates...")
-			sets := make(map[string][]map[string]interface{})
-			rels := make([]map[string]interface{}, 0)
+			dh.log().Info("committing updates...", "count", len(d.Updated))
+			sets := make(map[string][]*doc.Item)
+			patches := make(map[string][]*doc.Item)
+			setnames := make(map[string]string)
+			rels := make([]*doc.Relationship, 0)
+			relpatches := make([]*doc.Relationship, 0)
 			for _, obj := range d.Updated {
 				switch value := obj.(type) {
 				case *doc.Set:
 					data := value.ToPostBody()
 					delete(data, "items")
-					dh.put("/catalog/set/"+value.Id, data)
+					update := func() (int, interface{}, error) {
+						return dh.put("/catalog/set/"+value.Id, data)
+					}
+
+					op := &archive.Operation{Action: "update", Kind: "set", Set: value.Name.Physical, Target: value.Id, Payload: data}
+					request := update
+					if len(value.UpdateFields) > 0 {
+						patch := value.ToPatchBody()
+						op.Action = "patch"
+						op.Payload = patch
+						request = func() (int, interface{}, error) {
+							return dh.patch("/catalog/set/"+value.Id, patch, update)
+						}
+					}
+
+					status, _, err := dh.deliver(op, request)
+					if err == ErrQueued {
+						dh.results.queue(1)
+					} else if err != nil {
+						dh.results.fail(1, "error updating %v set (HTTP %v): %v", value.Name.Physical, status, err)
+					} else {
+						dh.results.succeed(1)
+					}
 					// util.Dump(data)
 				case *doc.Item:
-					if sets[value.Set().Id] == nil {
-						sets[value.Set().Id] = make([]map[string]interface{}, 0)
+					id := value.Set().Id
+					setnames[id] = value.Set().Name.Physical
+					if len(value.UpdateFields) > 0 {
+						patches[id] = append(patches[id], value)
+					} else {
+						sets[id] = append(sets[id], value)
 					}
-					sets[value.Set().Id] = append(sets[value.Set().Id], value.ToPostBody())
 				case *doc.Relationship:
-					rels = append(rels, value.ToPostBody())
+					if len(value.UpdateFields) > 0 {
+						relpatches = append(relpatches, value)
+					} else {
+						rels = append(rels, value)
+					}
 				}
 			}
 
-			if len(sets) > 0 {
-				for id, data := range sets {
-					// if false {
-					// 	fmt.Println(id)
-					// }
-					// util.Dump(map[string]interface{}{
-					// 	"items": data,
-					// })
-					status, _, err := dh.post("/catalog/set/"+id+"/items", map[string]interface{}{
-						"items": data,
-					})
-					if err != nil {
-						fmt.Println(err)
-					} else if status != 201 && status != 200 {
-						fmt.Printf("%v set item updates failed with HTTP %v\n", id, status)
+			// Full updates are sent before partial updates.
+			for _, partial := range []bool{false, true} {
+				group := sets
+				if partial {
+					group = patches
+				}
+				for id, list := range group {
+					for _, batch := range chunk(list, dh.batchSize()) {
+						items := make([]interface{}, 0, len(batch))
+						changes := make([]interface{}, 0, len(batch))
+						for _, item := range batch {
+							items = append(items, item.ToPostBody())
+							changes = append(changes, item.ToPatchBody())
+						}
+						body := map[string]interface{}{
+							"items": items,
+						}
+						update := func() (int, interface{}, error) {
+							return dh.post("/catalog/set/"+id+"/items", body)
+						}
+
+						op := &archive.Operation{Action: "update", Kind: "item", Set: setnames[id], Target: id, Payload: body}
+						request := update
+						if partial {
+							patch := map[string]interface{}{
+								"items": changes,
+							}
+							op.Action = "patch"
+							op.Payload = patch
+							request = func() (int, interface{}, error) {
+								return dh.patch("/catalog/set/"+id+"/items", patch, update)
+							}
+						}
+
+						status, _, err := dh.deliver(op, request)
+						if err == ErrQueued {
+							dh.results.queue(len(batch))
+						} else if err != nil {
+							dh.results.fail(len(batch), "%v", err)
+						} else if status != 201 && status != 200 {
+							dh.results.fail(len(batch), "%v set item updates failed with HTTP %v", id, status)
+						} else {
+							dh.results.succeed(len(batch))
+						}
 					}
 				}
-				// util.DumpFile("tmp.json", sets)
 			}
 
-			if len(rels) > 0 {
-				status, _, err := dh.put("/catalog/relationships", map[string]interface{}{
-					"relationships": rels,
-				})
-
-				if err != nil {
-					fmt.Println(err)
-				} else if status != 201 && status != 200 {
-					fmt.Printf("%v relationship updates failed with HTTP %v\n", len(rels), status)
+			for _, partial := range []bool{false, true} {
+				group := rels
+				if partial {
+					group = relpatches
 				}
+				for _, batch := range chunk(group, dh.batchSize()) {
+					list := make([]interface{}, 0, len(batch))
+					changes := make([]interface{}, 0, len(batch))
+					for _, rel := range batch {
+						list = append(list, rel.ToPostBody())
+						changes = append(changes, rel.ToPatchBody())
+					}
+					body := map[string]interface{}{
+						"relationships": list,
+					}
+					update := func() (int, interface{}, error) {
+						return dh.put("/catalog/relationships", body)
+					}
+
+					op := &archive.Operation{Action: "update", Kind: "relationship", Payload: body}
+					request := update
+					if partial {
+						patch := map[string]interface{}{
+							"relationships": changes,
+						}
+						op.Action = "patch"
+						op.Payload = patch
+						request = func() (int, interface{}, error) {
+							return dh.patch("/catalog/relationships", patch, update)
+						}
+					}
+
+					status, _, err := dh.deliver(op, request)
+					if err == ErrQueued {
+						dh.results.queue(len(batch))
+					} else if err != nil {
+						dh.results.fail(len(batch), "%v", err)
+					} else if status != 201 && status != 200 {
+						dh.results.fail(len(batch), "%v relationship updates failed with HTTP %v", len(batch), status)
+					} else {
+						dh.results.succeed(len(batch))
+					}
 
-				// util.Dump(body)
+					// util.Dump(body)
+				}
 			}
 			// } else {
 			// 	fmt.Println("\n  skipping updates (none detected)")
@@ -888,30 +1209,43 @@ func (dh *Datahub) Commit(diffs ...*archive.Diff) error {
 	return nil
 }
 
+// LookupSet finds the ID of a set by name, first in the archive and then in
+// the Datahub.
 func (dh *Datahub) LookupSet(name string, schema string) (*doc.Set, error) {
-	id := ""
-	rs, err := dh.archive.LookupDatahubSet(name)
-	if err == nil {
+	if dh.archive != nil {
+		rs, err := dh.archive.LookupDatahubSet(name)
+		if err != nil {
+			return &doc.Set{}, err
+		}
+
 		if rs.Count() > 0 {
-			id = rs.Get(0)["id"].(string)
-		} else {
-			status, result, err := dh.get("/catalog/schema/" + schema + "/sets")
-			if err == nil {
-				if status == 200 {
-					var res map[string]interface{}
-					json.Unmarshal(result, &res)
-					for _, set := range res["sets"].([]map[string]interface{}) {
-						if strings.ToLower(strings.TrimSpace(set["name"].(map[string]interface{})["physical"].(string))) == strings.ToLower(strings.TrimSpace(name)) {
-							return &doc.Set{Id: set["id"].(string)}, nil
-						}
-					}
-					err = errors.New("schema does not contain \"" + name + "\" set")
-				} else {
-					err = errors.New(fmt.Sprintf("not found (in Datahub - HTTP %v)", status))
-				}
-			}
+			return &doc.Set{Id: rs.Get(0)["id"].(string)}, nil
 		}
 	}
 
-	return &doc.Set{Id: id}, err
+	if dh.offline {
+		return &doc.Set{}, errors.New("the Datahub is unreachable")
+	}
+
+	status, result, err := dh.get("/catalog/schema/" + schema + "/sets")
+	if err != nil {
+		return &doc.Set{}, err
+	}
+
+	if status != 200 {
+		return &doc.Set{}, errors.New(fmt.Sprintf("not found (in Datahub - HTTP %v)", status))
+	}
+
+	var res map[string]interface{}
+	if err := json.Unmarshal(result, &res); err != nil {
+		return &doc.Set{}, err
+	}
+
+	ids := map[string]string{}
+	indexNames(res["sets"], ids)
+	if id, exists := ids[strings.ToLower(strings.TrimSpace(name))]; exists {
+		return &doc.Set{Id: id}, nil
+	}
+
+	return &doc.Set{}, errors.New("schema does not contain \"" + name + "\" set")
 }
@@ -0,0 +1,362 @@
+package datahubtest
+
+import (
+	"dhs/util"
+	"fmt"
+	"strings"
+)
+
+// The render functions produce the JSON structures returned by the Datahub
+// API. Every field the datahub client reads is always present.
+
+func renderName(name Name) map[string]interface{} {
+	return map[string]interface{}{
+		"physical": name.Physical,
+		"logical":  name.Logical,
+	}
+}
+
+func renderSource(src *Source, expand bool) map[string]interface{} {
+	metadata := src.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+
+	data := map[string]interface{}{
+		"id":          src.ID,
+		"name":        renderName(src.Name),
+		"stub":        src.stub(),
+		"description": src.Description,
+		"metadata":    metadata,
+	}
+
+	if expand {
+		sets := make([]interface{}, 0)
+		for _, set := range src.Sets {
+			sets = append(sets, renderSet(src, set, false))
+		}
+		data["sets"] = sets
+	}
+
+	return data
+}
+
+func renderSet(src *Source, set *Set, expand bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":          set.ID,
+		"name":        renderName(set.Name),
+		"stub":        src.stub() + "." + strings.ToLower(set.Name.Physical),
+		"description": set.Description,
+		"definition":  set.Definition,
+		"source":      map[string]interface{}{"id": src.ID, "stub": src.stub()},
+	}
+
+	if set.Metadata != nil {
+		data["metadata"] = set.Metadata
+	}
+
+	if set.Attributes != nil {
+		data["attributes"] = set.Attributes
+	}
+
+	if expand {
+		items := make([]interface{}, 0)
+		for _, item := range set.Items {
+			items = append(items, renderItem(src, set, item))
+		}
+		data["items"] = items
+	}
+
+	return data
+}
+
+func renderItem(src *Source, set *Set, item *Item) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":          item.ID,
+		"name":        renderName(item.Name),
+		"stub":        src.stub() + "." + strings.ToLower(set.Name.Physical) + "." + strings.ToLower(item.Name.Physical),
+		"description": item.Description,
+		"type":        item.Type,
+		"nullable":    item.Nullable,
+	}
+
+	if item.Default != util.EmptyString {
+		data["default"] = item.Default
+	}
+
+	if item.Example != util.EmptyString {
+		data["example"] = item.Example
+	}
+
+	if item.Metadata != nil {
+		data["metadata"] = item.Metadata
+	}
+
+	if item.Attributes != nil {
+		data["attributes"] = item.Attributes
+	}
+
+	if len(item.Keys) > 0 {
+		keys := make([]interface{}, 0)
+		for _, key := range item.Keys {
+			keys = append(keys, map[string]interface{}{
+				"is_key":  true,
+				"name":    key.Name,
+				"primary": key.Primary,
+			})
+		}
+		data["key"] = keys
+	}
+
+	return data
+}
+
+// renderRelationshipItem resolves an item stub (schema.set.item) to the
+// nested structure the Datahub returns for relationship joins.
+func (s *Server) renderRelationshipItem(stub string) map[string]interface{} {
+	parts := strings.SplitN(stub, ".", 3)
+	for len(parts) < 3 {
+		parts = append(parts, util.EmptyString)
+	}
+
+	source := Name{Physical: parts[0]}
+	set := Name{Physical: parts[1]}
+	item := Name{Physical: parts[2]}
+
+	if src := s.state.source(parts[0]); src != nil {
+		source = src.Name
+		if st := src.setByName(parts[1]); st != nil {
+			set = st.Name
+			if i := st.itemByName(parts[2]); i != nil {
+				item = i.Name
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"stub": stub,
+		"name": renderName(item),
+		"set": map[string]interface{}{
+			"name": renderName(set),
+			"source": map[string]interface{}{
+				"stub": strings.ToLower(source.Physical),
+			},
+		},
+	}
+}
+
+func (s *Server) renderRelationship(rel *Relationship) map[string]interface{} {
+	items := make([]interface{}, 0)
+	for _, join := range rel.Items {
+		items = append(items, map[string]interface{}{
+			"parent": s.renderRelationshipItem(join.Parent),
+			"child":  s.renderRelationshipItem(join.Child),
+		})
+	}
+
+	cardinality := make([]interface{}, 0)
+	for _, value := range rel.Cardinality {
+		cardinality = append(cardinality, value)
+	}
+
+	data := map[string]interface{}{
+		"id":          rel.ID,
+		"name":        renderName(rel.Name),
+		"description": rel.Description,
+		"parent_set":  rel.ParentSet,
+		"child_set":   rel.ChildSet,
+		"match_type":  rel.MatchType,
+		"referential_integrity": map[string]interface{}{
+			"on_update": rel.OnUpdate,
+			"on_delete": rel.OnDelete,
+		},
+		"cardinality": map[string]interface{}{
+			"raw": cardinality,
+		},
+		"items": items,
+	}
+
+	if len(rel.Metadata) > 0 {
+		data["metadata"] = rel.Metadata
+	}
+
+	if len(rel.Attributes) > 0 {
+		data["attributes"] = rel.Attributes
+	}
+
+	return data
+}
+
+// The apply functions merge a request body into a catalog object. Only the
+// fields present in the body are changed.
+
+func applySet(set *Set, body map[string]interface{}) {
+	if _, exists := body["name"]; exists {
+		name := parseName(body["name"])
+		if name.Physical != util.EmptyString {
+			set.Name.Physical = name.Physical
+		}
+		if name.Logical != util.EmptyString {
+			set.Name.Logical = name.Logical
+		}
+	}
+
+	if value, exists := body["description"]; exists {
+		set.Description = toString(value)
+	}
+
+	if value, exists := body["definition"]; exists {
+		set.Definition = toString(value)
+	}
+
+	if value, ok := body["metadata"].(map[string]interface{}); ok {
+		set.Metadata = value
+	}
+
+	if value, ok := body["attributes"].(map[string]interface{}); ok {
+		set.Attributes = value
+	}
+}
+
+func applyItem(item *Item, body map[string]interface{}) {
+	if _, exists := body["name"]; exists {
+		name := parseName(body["name"])
+		if name.Physical != util.EmptyString {
+			item.Name.Physical = name.Physical
+		}
+		if name.Logical != util.EmptyString {
+			item.Name.Logical = name.Logical
+		}
+	}
+
+	if value, exists := body["description"]; exists {
+		item.Description = toString(value)
+	}
+
+	if value, exists := body["udt_type"]; exists {
+		item.Type = toString(value)
+	} else if value, exists := body["type"]; exists {
+		item.Type = toString(value)
+	}
+
+	if value, ok := body["nullable"].(bool); ok {
+		item.Nullable = value
+	}
+
+	if value, exists := body["default"]; exists {
+		item.Default = toString(value)
+	}
+
+	if value, exists := body["example"]; exists {
+		item.Example = toString(value)
+	}
+
+	if value, ok := body["metadata"].(map[string]interface{}); ok {
+		item.Metadata = value
+	}
+
+	if value, ok := body["attributes"].(map[string]interface{}); ok {
+		item.Attributes = value
+	}
+
+	if value, ok := body["keys"].([]interface{}); ok {
+		item.Keys = make([]*Key, 0)
+		for _, raw := range value {
+			if k, ok := raw.(map[string]interface{}); ok {
+				primary, _ := k["primary"].(bool)
+				item.Keys = append(item.Keys, &Key{Name: toString(k["name"]), Primary: primary})
+			}
+		}
+	}
+}
+
+func applyRelationship(rel *Relationship, body map[string]interface{}) {
+	if _, exists := body["name"]; exists {
+		name := parseName(body["name"])
+		if name.Physical != util.EmptyString {
+			rel.Name.Physical = name.Physical
+		}
+		if name.Logical != util.EmptyString {
+			rel.Name.Logical = name.Logical
+		}
+	}
+
+	if value, exists := body["description"]; exists {
+		rel.Description = toString(value)
+	}
+
+	if value, exists := body["parent_set"]; exists {
+		rel.ParentSet = toString(value)
+	}
+
+	if value, exists := body["child_set"]; exists {
+		rel.ChildSet = toString(value)
+	}
+
+	if value, exists := body["match_type"]; exists {
+		rel.MatchType = toString(value)
+	}
+
+	if value, ok := body["metadata"].(map[string]interface{}); ok {
+		rel.Metadata = value
+	}
+
+	if value, ok := body["attributes"].(map[string]interface{}); ok {
+		rel.Attributes = value
+	}
+
+	if ri, ok := body["referential_integrity"].(map[string]interface{}); ok {
+		rel.OnUpdate = toString(ri["on_update"])
+		rel.OnDelete = toString(ri["on_delete"])
+	}
+
+	if value, ok := body["cardinality"].([]interface{}); ok {
+		rel.Cardinality = make([]int, 0)
+		for _, c := range value {
+			if n, ok := c.(float64); ok {
+				rel.Cardinality = append(rel.Cardinality, int(n))
+			}
+		}
+	}
+
+	if len(rel.Cardinality) == 0 {
+		rel.Cardinality = []int{1, 1, 0, -1}
+	}
+
+	if value, ok := body["items"].([]interface{}); ok {
+		rel.Items = make([]*Join, 0)
+		for _, raw := range value {
+			if j, ok := raw.(map[string]interface{}); ok {
+				rel.Items = append(rel.Items, &Join{
+					Parent: toString(j["parent"]),
+					Child:  toString(j["child"]),
+				})
+			}
+		}
+	}
+}
+
+func parseName(value interface{}) Name {
+	switch name := value.(type) {
+	case string:
+		return Name{Physical: name, Logical: name}
+	case map[string]interface{}:
+		return Name{
+			Physical: toString(name["physical"]),
+			Logical:  toString(name["logical"]),
+		}
+	}
+
+	return Name{}
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return util.EmptyString
+	case string:
+		return v
+	}
+
+	return fmt.Sprintf("%v", value)
+}
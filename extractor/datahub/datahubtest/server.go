@@ -0,0 +1,693 @@
+// Package datahubtest provides an in-memory Datahub API for tests and demos.
+// It implements the subset of endpoints used by the datahub client, so a
+// complete sync can be exercised without access to a real Datahub.
+package datahubtest
+
+import (
+	"dhs/util"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type Server struct {
+	// APIKey, when set, is accepted as a bearer token on every request.
+	APIKey string
+	// User and Password, when set, are required (as basic auth) to obtain a
+	// JWT from the /token endpoint.
+	User     string
+	Password string
+	// ClientID and ClientSecret, when set, are required to obtain an access
+	// token from the OAuth2 client credentials endpoint (/oauth/token).
+	ClientID     string
+	ClientSecret string
+	// NoPatch rejects partial updates (HTTP 405), like Datahub versions that
+	// do not support PATCH.
+	NoPatch bool
+
+	mu       sync.Mutex
+	file     string
+	state    *State
+	tokens   []string
+	requests []string
+}
+
+// New creates a mock Datahub. When a state file is supplied, the catalog is
+// loaded from it (if it exists) and persisted back after every change.
+func New(file ...string) (*Server, error) {
+	s := &Server{tokens: make([]string, 0), requests: make([]string, 0)}
+
+	if len(file) > 0 && file[0] != util.EmptyString {
+		s.file = file[0]
+	}
+
+	state, err := loadState(s.file)
+	if err != nil {
+		return s, err
+	}
+	s.state = state
+
+	return s, nil
+}
+
+// AddSource creates a data source in the mock catalog (or returns the
+// existing source with the same physical name).
+func (s *Server) AddSource(name string, description ...string) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if src := s.state.source(name); src != nil {
+		return src
+	}
+
+	src := &Source{
+		ID:       s.state.nextID("source"),
+		Name:     Name{Physical: name, Logical: name},
+		Metadata: map[string]interface{}{},
+		Sets:     make([]*Set, 0),
+	}
+
+	if len(description) > 0 {
+		src.Description = description[0]
+	}
+
+	s.state.Sources = append(s.state.Sources, src)
+	s.save()
+
+	return src
+}
+
+// Snapshot returns a deep copy of the current catalog, suitable for
+// assertions.
+func (s *Server) Snapshot() *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, _ := json.Marshal(s.state)
+	state := newState()
+	json.Unmarshal(j, state)
+
+	return state
+}
+
+// Source returns a copy of the source identified by ID or physical name.
+func (s *Server) Source(id string) *Source {
+	return s.Snapshot().source(id)
+}
+
+// Relationships returns a copy of every relationship attached to a source.
+func (s *Server) Relationships(id string) []*Relationship {
+	state := s.Snapshot()
+	rels := make([]*Relationship, 0)
+
+	src := state.source(id)
+	if src == nil {
+		return rels
+	}
+
+	for _, rel := range state.Relationships {
+		if rel.Source == src.ID {
+			rels = append(rels, rel)
+		}
+	}
+
+	return rels
+}
+
+// JobLog returns the status events posted to a system/job.
+func (s *Server) JobLog(id string) []map[string]interface{} {
+	return s.Snapshot().Jobs[id]
+}
+
+// Requests lists every request received, formatted as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+// Save writes the catalog to the state file (if one was configured).
+func (s *Server) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.save(s.file)
+}
+
+func (s *Server) save() {
+	if err := s.state.save(s.file); err != nil {
+		slog.Error("mock datahub: failed to save state", "error", err)
+	}
+}
+
+// ListenAndServe starts the mock Datahub on the specified address.
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(path) == 1 && path[0] == "token" {
+		s.token(w, r)
+		return
+	}
+
+	if len(path) == 2 && path[0] == "oauth" && path[1] == "token" {
+		s.oauthToken(w, r)
+		return
+	}
+
+	if !s.authorized(r) {
+		reply(w, http.StatusUnauthorized, map[string]interface{}{"error": "unauthorized"})
+		return
+	}
+
+	if len(path) == 3 && path[0] == "system" && path[2] == "log" {
+		s.jobLog(w, r, path[1])
+		return
+	}
+
+	if len(path) < 2 || path[0] != "catalog" {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+		return
+	}
+
+	if s.NoPatch && r.Method == http.MethodPatch {
+		reply(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Body != nil && r.Method != http.MethodGet {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	route := r.Method + " " + path[1]
+	if path[1] == "relationships" && len(path) == 4 && path[2] == "source" {
+		route = route + "/source/:id"
+		path = append(path[:2], path[3])
+	} else if len(path) > 3 {
+		route = route + "/:id/" + strings.Join(path[3:], "/")
+	} else if len(path) == 3 {
+		route = route + "/:id"
+	}
+
+	var id string
+	if len(path) > 2 {
+		id = path[2]
+	}
+
+	switch route {
+	case "GET sources":
+		s.listSources(w)
+	case "POST source", "POST sources":
+		s.createSource(w, body)
+	case "GET source/:id":
+		s.getSource(w, id, r.URL.Query().Get("expand") == "sets")
+	case "GET source/:id/sets", "GET schema/:id/sets":
+		s.listSets(w, id)
+	case "POST source/:id/set":
+		s.createSet(w, id, body)
+	case "POST source/:id/sets":
+		s.createSets(w, id, body)
+	case "GET set/:id":
+		s.getSet(w, id)
+	case "PUT set/:id":
+		s.updateSet(w, id, body, true)
+	case "PATCH set/:id":
+		s.updateSet(w, id, body, false)
+	case "DELETE set/:id":
+		s.deleteSet(w, id)
+	case "GET set/:id/items":
+		s.getSet(w, id)
+	case "POST set/:id/items":
+		s.upsertItems(w, id, body)
+	case "PATCH set/:id/items":
+		s.patchItems(w, id, body)
+	case "GET item/:id":
+		s.getItem(w, id)
+	case "DELETE item/:id":
+		s.deleteItem(w, id)
+	case "GET relationships/source/:id":
+		s.listRelationships(w, id)
+	case "POST relationships", "PUT relationships":
+		s.upsertRelationships(w, body)
+	case "PATCH relationships":
+		s.patchRelationships(w, body)
+	case "DELETE relationships":
+		s.deleteRelationships(w, body)
+	default:
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+	}
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if s.User != util.EmptyString {
+		user, pwd, ok := r.BasicAuth()
+		if !ok || user != s.User || pwd != s.Password {
+			reply(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid credentials"})
+			return
+		}
+	}
+
+	jwt := s.state.nextID("jwt")
+	s.tokens = append(s.tokens, jwt)
+
+	reply(w, http.StatusOK, map[string]interface{}{"jwt": jwt})
+}
+
+// oauthToken implements the OAuth2 client credentials grant. Client
+// credentials are accepted as basic auth or as form fields.
+func (s *Server) oauthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		reply(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "invalid_request"})
+		return
+	}
+
+	r.ParseForm()
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"error": "unsupported_grant_type"})
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if s.ClientID != util.EmptyString && (id != s.ClientID || secret != s.ClientSecret) {
+		reply(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid_client", "error_description": "client authentication failed"})
+		return
+	}
+
+	token := s.state.nextID("access")
+	s.tokens = append(s.tokens, token)
+
+	reply(w, http.StatusOK, map[string]interface{}{"access_token": token, "token_type": "Bearer", "expires_in": 3600})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.APIKey == util.EmptyString && s.User == util.EmptyString && s.ClientID == util.EmptyString {
+		return true
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == util.EmptyString {
+		return false
+	}
+
+	if s.APIKey != util.EmptyString && token == s.APIKey {
+		return true
+	}
+
+	return util.InSlice[string](token, s.tokens)
+}
+
+func (s *Server) listSources(w http.ResponseWriter) {
+	sources := make([]interface{}, 0)
+	for _, src := range s.state.Sources {
+		sources = append(sources, renderSource(src, false))
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{"sources": sources})
+}
+
+func (s *Server) createSource(w http.ResponseWriter, body map[string]interface{}) {
+	name := parseName(body["name"])
+	if name.Physical == util.EmptyString {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"error": "a physical source name is required"})
+		return
+	}
+
+	if s.state.source(name.Physical) != nil {
+		reply(w, http.StatusConflict, map[string]interface{}{"error": name.Physical + " source already exists"})
+		return
+	}
+
+	src := &Source{
+		ID:          s.state.nextID("source"),
+		Name:        name,
+		Description: toString(body["description"]),
+		Metadata:    map[string]interface{}{},
+		Sets:        make([]*Set, 0),
+	}
+
+	if value, ok := body["metadata"].(map[string]interface{}); ok {
+		src.Metadata = value
+	}
+
+	s.state.Sources = append(s.state.Sources, src)
+	s.save()
+
+	reply(w, http.StatusCreated, renderSource(src, false))
+}
+
+func (s *Server) getSource(w http.ResponseWriter, id string, expand bool) {
+	src := s.state.source(id)
+	if src == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " source not found"})
+		return
+	}
+
+	reply(w, http.StatusOK, renderSource(src, expand))
+}
+
+func (s *Server) listSets(w http.ResponseWriter, id string) {
+	src := s.state.source(id)
+	if src == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " source not found"})
+		return
+	}
+
+	sets := make([]interface{}, 0)
+	for _, set := range src.Sets {
+		sets = append(sets, renderSet(src, set, true))
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{"sets": sets})
+}
+
+func (s *Server) createSet(w http.ResponseWriter, id string, body map[string]interface{}) {
+	src := s.state.source(id)
+	if src == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " source not found"})
+		return
+	}
+
+	name := parseName(body["name"])
+	if name.Physical == util.EmptyString {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"error": "a physical set name is required"})
+		return
+	}
+
+	if src.setByName(name.Physical) != nil {
+		reply(w, http.StatusConflict, map[string]interface{}{"error": name.Physical + " set already exists"})
+		return
+	}
+
+	set := &Set{ID: s.state.nextID("set"), Items: make([]*Item, 0)}
+	applySet(set, body)
+	src.Sets = append(src.Sets, set)
+	s.save()
+
+	reply(w, http.StatusCreated, renderSet(src, set, false))
+}
+
+// createSets implements the bulk set endpoint. Like the Datahub, it does not
+// return the IDs of the new sets. The batch is rejected when any set is
+// invalid or already exists.
+func (s *Server) createSets(w http.ResponseWriter, id string, body map[string]interface{}) {
+	src := s.state.source(id)
+	if src == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " source not found"})
+		return
+	}
+
+	list, ok := body["sets"].([]interface{})
+	if !ok {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"error": "a list of sets is required"})
+		return
+	}
+
+	names := map[string]bool{}
+	for _, raw := range list {
+		data, _ := raw.(map[string]interface{})
+		name := parseName(data["name"])
+		if name.Physical == util.EmptyString {
+			reply(w, http.StatusBadRequest, map[string]interface{}{"error": "a physical set name is required"})
+			return
+		}
+
+		if src.setByName(name.Physical) != nil || names[strings.ToLower(name.Physical)] {
+			reply(w, http.StatusConflict, map[string]interface{}{"error": name.Physical + " set already exists"})
+			return
+		}
+		names[strings.ToLower(name.Physical)] = true
+	}
+
+	for _, raw := range list {
+		set := &Set{ID: s.state.nextID("set"), Items: make([]*Item, 0)}
+		applySet(set, raw.(map[string]interface{}))
+		src.Sets = append(src.Sets, set)
+	}
+	s.save()
+
+	reply(w, http.StatusCreated, map[string]interface{}{"created": len(list)})
+}
+
+func (s *Server) getSet(w http.ResponseWriter, id string) {
+	src, set := s.state.set(id)
+	if set == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " set not found"})
+		return
+	}
+
+	reply(w, http.StatusOK, renderSet(src, set, true))
+}
+
+// updateSet applies a full (replace) or partial update to a set. A full
+// update clears the fields missing from the body, but keeps the items.
+func (s *Server) updateSet(w http.ResponseWriter, id string, body map[string]interface{}, replace bool) {
+	src, set := s.state.set(id)
+	if set == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " set not found"})
+		return
+	}
+
+	if replace {
+		*set = Set{ID: set.ID, Name: Name{Physical: set.Name.Physical}, Items: set.Items}
+	}
+	applySet(set, body)
+	s.save()
+
+	reply(w, http.StatusOK, renderSet(src, set, false))
+}
+
+func (s *Server) deleteSet(w http.ResponseWriter, id string) {
+	if !s.state.deleteSet(id) {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " set not found"})
+		return
+	}
+
+	s.save()
+	reply(w, http.StatusOK, map[string]interface{}{"id": id})
+}
+
+func (s *Server) upsertItems(w http.ResponseWriter, id string, body map[string]interface{}) {
+	src, set := s.state.set(id)
+	if set == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " set not found"})
+		return
+	}
+
+	list, _ := body["items"].([]interface{})
+	result := make([]interface{}, 0)
+	for _, raw := range list {
+		data, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name := parseName(data["name"])
+		if name.Physical == util.EmptyString {
+			continue
+		}
+
+		// Existing items are replaced, like the Datahub does.
+		item := set.itemByName(name.Physical)
+		if item == nil {
+			item = &Item{ID: s.state.nextID("item"), Nullable: true}
+			set.Items = append(set.Items, item)
+		} else {
+			*item = Item{ID: item.ID, Name: Name{Physical: item.Name.Physical}, Nullable: true}
+		}
+
+		applyItem(item, data)
+		result = append(result, renderItem(src, set, item))
+	}
+
+	s.save()
+	reply(w, http.StatusCreated, map[string]interface{}{"items": result})
+}
+
+// patchItems applies partial updates to existing items. Items that do not
+// exist are ignored.
+func (s *Server) patchItems(w http.ResponseWriter, id string, body map[string]interface{}) {
+	src, set := s.state.set(id)
+	if set == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " set not found"})
+		return
+	}
+
+	list, _ := body["items"].([]interface{})
+	result := make([]interface{}, 0)
+	for _, raw := range list {
+		data, _ := raw.(map[string]interface{})
+		if item := set.itemByName(parseName(data["name"]).Physical); item != nil {
+			applyItem(item, data)
+			result = append(result, renderItem(src, set, item))
+		}
+	}
+
+	s.save()
+	reply(w, http.StatusOK, map[string]interface{}{"items": result})
+}
+
+func (s *Server) getItem(w http.ResponseWriter, id string) {
+	src, set, item := s.state.item(id)
+	if item == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " item not found"})
+		return
+	}
+
+	reply(w, http.StatusOK, renderItem(src, set, item))
+}
+
+func (s *Server) deleteItem(w http.ResponseWriter, id string) {
+	if !s.state.deleteItem(id) {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " item not found"})
+		return
+	}
+
+	s.save()
+	reply(w, http.StatusOK, map[string]interface{}{"id": id})
+}
+
+func (s *Server) listRelationships(w http.ResponseWriter, id string) {
+	src := s.state.source(id)
+	if src == nil {
+		reply(w, http.StatusNotFound, map[string]interface{}{"error": id + " source not found"})
+		return
+	}
+
+	rels := make([]interface{}, 0)
+	for _, rel := range s.state.Relationships {
+		if rel.Source == src.ID {
+			rels = append(rels, s.renderRelationship(rel))
+		}
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{"relationships": rels})
+}
+
+func (s *Server) upsertRelationships(w http.ResponseWriter, body map[string]interface{}) {
+	list, _ := body["relationships"].([]interface{})
+	result := make([]interface{}, 0)
+
+	for _, raw := range list {
+		data, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		parent := toString(data["parent_set"])
+		src := s.state.source(strings.Split(parent, ".")[0])
+		if src == nil && len(s.state.Sources) == 1 {
+			src = s.state.Sources[0]
+		}
+		if src == nil {
+			reply(w, http.StatusBadRequest, map[string]interface{}{"error": "cannot identify the source of the " + parent + " set"})
+			return
+		}
+
+		items, _ := data["items"].([]interface{})
+		if len(items) == 0 {
+			reply(w, http.StatusBadRequest, map[string]interface{}{"error": "relationships require at least one item"})
+			return
+		}
+
+		name := parseName(data["name"])
+		// Existing relationships are replaced, like the Datahub does.
+		rel := s.state.relationship(src.ID, name.Physical)
+		if rel == nil {
+			rel = &Relationship{ID: s.state.nextID("relationship"), Source: src.ID}
+			s.state.Relationships = append(s.state.Relationships, rel)
+		} else {
+			*rel = Relationship{ID: rel.ID, Source: rel.Source, Name: Name{Physical: rel.Name.Physical}}
+		}
+
+		applyRelationship(rel, data)
+		result = append(result, s.renderRelationship(rel))
+	}
+
+	s.save()
+	reply(w, http.StatusCreated, map[string]interface{}{"relationships": result})
+}
+
+// patchRelationships applies partial updates to existing relationships,
+// identified by ID. Relationships that do not exist are ignored.
+func (s *Server) patchRelationships(w http.ResponseWriter, body map[string]interface{}) {
+	list, _ := body["relationships"].([]interface{})
+	result := make([]interface{}, 0)
+	for _, raw := range list {
+		data, _ := raw.(map[string]interface{})
+		for _, rel := range s.state.Relationships {
+			if rel.ID == toString(data["id"]) {
+				applyRelationship(rel, data)
+				result = append(result, s.renderRelationship(rel))
+			}
+		}
+	}
+
+	s.save()
+	reply(w, http.StatusOK, map[string]interface{}{"relationships": result})
+}
+
+func (s *Server) deleteRelationships(w http.ResponseWriter, body map[string]interface{}) {
+	list, _ := body["relationships"].([]interface{})
+	deleted := make([]string, 0)
+	for _, id := range list {
+		if s.state.deleteRelationship(toString(id)) {
+			deleted = append(deleted, toString(id))
+		}
+	}
+
+	s.save()
+	reply(w, http.StatusOK, map[string]interface{}{"relationships": deleted})
+}
+
+func (s *Server) jobLog(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method == http.MethodGet {
+		reply(w, http.StatusOK, map[string]interface{}{"events": s.state.Jobs[id]})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		reply(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	var event map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	if s.state.Jobs == nil {
+		s.state.Jobs = make(map[string][]map[string]interface{})
+	}
+	s.state.Jobs[id] = append(s.state.Jobs[id], event)
+	s.save()
+
+	reply(w, http.StatusCreated, event)
+}
+
+func reply(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
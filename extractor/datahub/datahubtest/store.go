@@ -0,0 +1,262 @@
+package datahubtest
+
+import (
+	"dhs/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type Name struct {
+	Physical string `json:"physical"`
+	Logical  string `json:"logical"`
+}
+
+type Key struct {
+	Name    string `json:"name"`
+	Primary bool   `json:"primary"`
+}
+
+type Item struct {
+	ID          string                 `json:"id"`
+	Name        Name                   `json:"name"`
+	Description string                 `json:"description"`
+	Type        string                 `json:"type"`
+	Nullable    bool                   `json:"nullable"`
+	Default     string                 `json:"default,omitempty"`
+	Example     string                 `json:"example,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Keys        []*Key                 `json:"keys,omitempty"`
+}
+
+type Set struct {
+	ID          string                 `json:"id"`
+	Name        Name                   `json:"name"`
+	Description string                 `json:"description"`
+	Definition  string                 `json:"definition,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Items       []*Item                `json:"items"`
+}
+
+type Source struct {
+	ID          string                 `json:"id"`
+	Name        Name                   `json:"name"`
+	Description string                 `json:"description"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Sets        []*Set                 `json:"sets"`
+}
+
+type Join struct {
+	Parent string `json:"parent"`
+	Child  string `json:"child"`
+}
+
+type Relationship struct {
+	ID          string                 `json:"id"`
+	Source      string                 `json:"source"`
+	Name        Name                   `json:"name"`
+	Description string                 `json:"description"`
+	ParentSet   string                 `json:"parent_set"`
+	ChildSet    string                 `json:"child_set"`
+	OnUpdate    string                 `json:"on_update"`
+	OnDelete    string                 `json:"on_delete"`
+	MatchType   string                 `json:"match_type"`
+	Cardinality []int                  `json:"cardinality"`
+	Items       []*Join                `json:"items"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// State is the complete mock catalog. It is what gets persisted to
+// (and loaded from) the JSON state file.
+type State struct {
+	Sources       []*Source                           `json:"sources"`
+	Relationships []*Relationship                     `json:"relationships"`
+	Jobs          map[string][]map[string]interface{} `json:"jobs,omitempty"`
+	Sequence      int                                 `json:"sequence"`
+}
+
+func newState() *State {
+	return &State{
+		Sources:       make([]*Source, 0),
+		Relationships: make([]*Relationship, 0),
+	}
+}
+
+func loadState(path string) (*State, error) {
+	state := newState()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+
+		return state, err
+	}
+
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return state, nil
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return state, errors.New("invalid mock datahub state file " + path + ": " + err.Error())
+	}
+
+	return state, nil
+}
+
+func (s *State) save(path string) error {
+	if path == util.EmptyString {
+		return nil
+	}
+
+	j, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, j, 0644)
+}
+
+func (s *State) nextID(kind string) string {
+	s.Sequence++
+	return fmt.Sprintf("%s-%06d", kind, s.Sequence)
+}
+
+func (s *State) source(id string) *Source {
+	id = strings.ToLower(strings.TrimSpace(id))
+	for _, src := range s.Sources {
+		if strings.ToLower(src.ID) == id {
+			return src
+		}
+	}
+
+	for _, src := range s.Sources {
+		if strings.ToLower(src.Name.Physical) == id {
+			return src
+		}
+	}
+
+	return nil
+}
+
+func (s *State) set(id string) (*Source, *Set) {
+	for _, src := range s.Sources {
+		for _, set := range src.Sets {
+			if set.ID == id {
+				return src, set
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *State) item(id string) (*Source, *Set, *Item) {
+	for _, src := range s.Sources {
+		for _, set := range src.Sets {
+			for _, item := range set.Items {
+				if item.ID == id {
+					return src, set, item
+				}
+			}
+		}
+	}
+
+	return nil, nil, nil
+}
+
+func (s *State) relationship(source string, name string) *Relationship {
+	for _, rel := range s.Relationships {
+		if rel.Source == source && strings.EqualFold(rel.Name.Physical, name) {
+			return rel
+		}
+	}
+
+	return nil
+}
+
+func (s *State) deleteSet(id string) bool {
+	for _, src := range s.Sources {
+		for i, set := range src.Sets {
+			if set.ID == id {
+				src.Sets = append(src.Sets[:i], src.Sets[i+1:]...)
+				s.pruneRelationships(src, set)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *State) deleteItem(id string) bool {
+	for _, src := range s.Sources {
+		for _, set := range src.Sets {
+			for i, item := range set.Items {
+				if item.ID == id {
+					set.Items = append(set.Items[:i], set.Items[i+1:]...)
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *State) deleteRelationship(id string) bool {
+	for i, rel := range s.Relationships {
+		if rel.ID == id {
+			s.Relationships = append(s.Relationships[:i], s.Relationships[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneRelationships removes relationships that reference a deleted set,
+// mirroring the cascade the Datahub applies.
+func (s *State) pruneRelationships(src *Source, set *Set) {
+	stub := strings.ToLower(src.stub() + "." + set.Name.Physical)
+	rels := make([]*Relationship, 0)
+	for _, rel := range s.Relationships {
+		if rel.Source == src.ID && (strings.ToLower(rel.ParentSet) == stub || strings.ToLower(rel.ChildSet) == stub) {
+			continue
+		}
+		rels = append(rels, rel)
+	}
+
+	s.Relationships = rels
+}
+
+func (src *Source) stub() string {
+	return strings.ToLower(src.Name.Physical)
+}
+
+func (src *Source) setByName(name string) *Set {
+	for _, set := range src.Sets {
+		if strings.EqualFold(set.Name.Physical, name) {
+			return set
+		}
+	}
+
+	return nil
+}
+
+func (set *Set) itemByName(name string) *Item {
+	for _, item := range set.Items {
+		if strings.EqualFold(item.Name.Physical, name) {
+			return item
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,437 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Deletion policies determine what happens to Datahub objects that no longer
+// exist in the data source.
+const (
+	DeletePolicy    = "delete"
+	DeprecatePolicy = "deprecate"
+	IgnorePolicy    = "ignore"
+)
+
+// The attributes that mark a deprecated object.
+const (
+	DeprecatedAttribute   = "Deprecated"
+	DeprecatedAtAttribute = "Deprecated At"
+)
+
+// SetDeletionPolicy determines what happens to Datahub objects that no longer
+// exist in the data source. Deprecated objects are purged once the grace
+// period has elapsed (a zero grace period never purges them). The deprecate
+// policy requires an archive.
+func (dh *Datahub) SetDeletionPolicy(policy string, grace time.Duration) error {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	switch policy {
+	case util.EmptyString:
+		policy = DeletePolicy
+	case DeletePolicy, DeprecatePolicy, IgnorePolicy:
+	default:
+		return fmt.Errorf("invalid deletion policy %q (expected %v, %v or %v)", policy, DeletePolicy, DeprecatePolicy, IgnorePolicy)
+	}
+
+	dh.policy = policy
+	dh.grace = grace
+
+	return nil
+}
+
+// ParseGracePeriod reads a grace period such as "30d", "12h" or "90m".
+func ParseGracePeriod(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == util.EmptyString {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(value, "d") {
+		var days int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(value, "d"), "%d", &days); err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid grace period %q", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("invalid grace period %q", value)
+	}
+
+	return grace, nil
+}
+
+// DeletionPlan is the outcome of the deletion policy. With the deprecate
+// policy, deletions are replaced by deprecations until the grace period
+// elapses, and deprecated objects that exist in the data source again are
+// reinstated.
+type DeletionPlan struct {
+	Policy string
+	// Deprecate lists the objects to mark as deprecated.
+	Deprecate []*archive.Deprecation
+	// Reinstate lists deprecated objects that exist in the data source again.
+	Reinstate []*archive.Deprecation
+	// Purge lists deprecated objects whose grace period has elapsed. They
+	// remain in the diffs, so they are deleted by Commit.
+	Purge []*archive.Deprecation
+	// Ignored is the number of deletions skipped by the ignore policy.
+	Ignored int
+}
+
+// PlanDeletions applies the deletion policy to the set, item and relationship
+// diffs. Deletions that must not happen (yet) are removed from the diffs. The
+// Datahub must be populated, since the deprecation attributes are read from
+// the Datahub objects. Nothing is changed until CommitDeletions is called.
+func (dh *Datahub) PlanDeletions(diffs ...*archive.Diff) (*DeletionPlan, error) {
+	plan := &DeletionPlan{Policy: dh.policy}
+	if plan.Policy == util.EmptyString {
+		plan.Policy = DeletePolicy
+	}
+
+	switch plan.Policy {
+	case DeletePolicy:
+		return plan, nil
+	case IgnorePolicy:
+		return plan, dh.ignore(plan, diffs, nil)
+	}
+
+	// Without the grace periods, nothing can be deleted safely.
+	if dh.archive == nil {
+		return plan, dh.ignore(plan, diffs, errors.New("the deprecate deletion policy requires an archive to track the grace period"))
+	}
+
+	recorded, err := dh.archive.Deprecations(dh.sourceID())
+	if err != nil {
+		return plan, dh.ignore(plan, diffs, err)
+	}
+
+	deleted := map[string]bool{}
+	for _, d := range diffs {
+		remaining := make([]interface{}, 0, len(d.Deleted))
+		for _, obj := range d.Deleted {
+			dep := dh.deprecation(obj)
+			if dep == nil {
+				remaining = append(remaining, obj)
+				continue
+			}
+			deleted[dep.Key()] = true
+
+			if rec, exists := recorded[dep.Key()]; exists {
+				dep.Since = rec.Since
+			}
+
+			switch {
+			case dep.Since.IsZero():
+				plan.Deprecate = append(plan.Deprecate, dep)
+			case dh.grace > 0 && time.Since(dep.Since) >= dh.grace:
+				plan.Purge = append(plan.Purge, dep)
+				remaining = append(remaining, obj)
+			}
+		}
+		d.Deleted = remaining
+	}
+
+	// Objects that are marked as deprecated but no longer scheduled for
+	// deletion exist in the data source again, unless their deletions were
+	// not evaluated (see SetSelection).
+	for _, dep := range dh.deprecated() {
+		if !deleted[dep.Key()] && dh.selection.Deletes(dep.Kind) {
+			plan.Reinstate = append(plan.Reinstate, dep)
+		}
+	}
+
+	for key, rec := range recorded {
+		if !deleted[key] && dh.selection.Deletes(rec.Kind) && !containsDeprecation(plan.Reinstate, key) {
+			plan.Reinstate = append(plan.Reinstate, rec)
+		}
+	}
+
+	return plan, nil
+}
+
+// ignore removes all the deletions from the diffs.
+func (dh *Datahub) ignore(plan *DeletionPlan, diffs []*archive.Diff, err error) error {
+	for _, d := range diffs {
+		plan.Ignored += len(d.Deleted)
+		d.Deleted = make([]interface{}, 0)
+	}
+
+	return err
+}
+
+func containsDeprecation(list []*archive.Deprecation, key string) bool {
+	for _, dep := range list {
+		if dep.Key() == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deprecation describes a deleted object. The grace period start (Since) is
+// read from the deprecation attribute of the Datahub object, when it exists.
+func (dh *Datahub) deprecation(obj interface{}) *archive.Deprecation {
+	dep := &archive.Deprecation{Source: dh.sourceID()}
+
+	var attributes map[string]interface{}
+	switch value := obj.(type) {
+	case *doc.Set:
+		dep.Kind, dep.Name, dep.Target = "set", value.Name.Physical, value.Id
+		if set := dh.datahubSet(value.Name.Physical); set != nil {
+			dep.Target, attributes = set.Id, set.Attributes
+		}
+	case *doc.Item:
+		dep.Kind, dep.Set, dep.Name, dep.Target = "item", value.Set().Name.Physical, value.Name.Physical, value.Id
+		if set := dh.datahubSet(value.Set().Name.Physical); set != nil {
+			if item, err := set.GetItem(value.Name.Physical); err == nil {
+				dep.Target, attributes = item.Id, item.Attributes
+			}
+		}
+	case *doc.Relationship:
+		dep.Kind, dep.Name, dep.Target = "relationship", value.Name.Physical, value.Id
+		if rel := dh.datahubRelationship(value.Name.Physical); rel != nil {
+			dep.Target, attributes = rel.Id, rel.Attributes
+		}
+	default:
+		return nil
+	}
+
+	if deprecated(attributes) {
+		dep.Since, _ = time.Parse(time.RFC3339, fmt.Sprintf("%v", attributes[DeprecatedAtAttribute]))
+		if dep.Since.IsZero() {
+			dep.Since = time.Now().UTC()
+		}
+	}
+
+	return dep
+}
+
+func deprecated(attributes map[string]interface{}) bool {
+	return strings.EqualFold(fmt.Sprintf("%v", attributes[DeprecatedAttribute]), "true")
+}
+
+// deprecated lists the Datahub objects marked as deprecated.
+func (dh *Datahub) deprecated() []*archive.Deprecation {
+	deps := make([]*archive.Deprecation, 0)
+
+	for _, schema := range dh.doc.GetSchemas() {
+		for _, set := range schema.Sets {
+			if deprecated(set.Attributes) {
+				deps = append(deps, &archive.Deprecation{Source: dh.sourceID(), Kind: "set", Name: set.Name.Physical, Target: set.Id})
+			}
+
+			for _, item := range set.Items {
+				if deprecated(item.Attributes) {
+					deps = append(deps, &archive.Deprecation{Source: dh.sourceID(), Kind: "item", Set: set.Name.Physical, Name: item.Name.Physical, Target: item.Id})
+				}
+			}
+		}
+
+		for _, rel := range schema.Relationships {
+			if deprecated(rel.Attributes) {
+				deps = append(deps, &archive.Deprecation{Source: dh.sourceID(), Kind: "relationship", Name: rel.Name.Physical, Target: rel.Id})
+			}
+		}
+	}
+
+	return deps
+}
+
+func (dh *Datahub) datahubSet(name string) *doc.Set {
+	for _, schema := range dh.doc.GetSchemas() {
+		if set, err := schema.GetSet(name); err == nil {
+			return set
+		}
+	}
+
+	return nil
+}
+
+func (dh *Datahub) datahubRelationship(name string) *doc.Relationship {
+	for _, schema := range dh.doc.GetSchemas() {
+		if rel, exists := schema.Relationships[strings.ToLower(name)]; exists {
+			return rel
+		}
+	}
+
+	return nil
+}
+
+// DryRunDeletions previews the deletion plan.
+func (dh *Datahub) DryRunDeletions(plan *DeletionPlan, max int) {
+	if plan.Ignored > 0 {
+		dh.preview(fmt.Sprintf("%v deletion(s) ignored (%v policy)", plan.Ignored, plan.Policy), "-", nil, max)
+	}
+
+	for _, group := range []struct {
+		label  string
+		symbol string
+		list   []*archive.Deprecation
+	}{
+		{"will be marked as deprecated in the Datahub", "-", plan.Deprecate},
+		{"will be reinstated (they exist in the data source again)", "+", plan.Reinstate},
+		{"will be purged (the grace period elapsed)", "-", plan.Purge},
+	} {
+		if len(group.list) == 0 {
+			continue
+		}
+
+		lines := make([]string, 0, len(group.list))
+		for _, dep := range group.list {
+			lines = append(lines, describeDeprecation(dep))
+		}
+		dh.preview(fmt.Sprintf("%v object(s) %v", len(group.list), group.label), group.symbol, lines, max)
+	}
+}
+
+func describeDeprecation(dep *archive.Deprecation) string {
+	name := dep.Name
+	if dep.Set != util.EmptyString {
+		name = dep.Set + "." + dep.Name
+	}
+
+	if dep.Since.IsZero() {
+		return fmt.Sprintf("%v %v", dep.Kind, name)
+	}
+
+	return fmt.Sprintf("%v %v (deprecated %v)", dep.Kind, name, dep.Since.Format("2006-01-02"))
+}
+
+// CommitDeletions marks and reinstates deprecated objects, and records the
+// grace periods in the archive. It must be called before the diffs are
+// committed.
+func (dh *Datahub) CommitDeletions(plan *DeletionPlan) {
+	now := time.Now().UTC()
+
+	for _, dep := range plan.Deprecate {
+		dep.Since = now
+		if dh.mark(dep, true) {
+			if err := dh.archive.Deprecate(dep); err != nil {
+				dh.log().Warn("failed to record the deprecation of "+describeDeprecation(dep), "error", err)
+			}
+		}
+	}
+
+	for _, dep := range plan.Reinstate {
+		if dh.mark(dep, false) {
+			dh.archive.Undeprecate(dep)
+		}
+	}
+
+	// Purged objects are deleted by Commit. When the deletion fails, the
+	// deprecation attribute still dates the grace period.
+	for _, dep := range plan.Purge {
+		dh.archive.Undeprecate(dep)
+	}
+}
+
+// mark adds (or removes) the deprecation attributes of an object, keeping
+// its other attributes. Only the attributes are sent when the Datahub
+// supports partial updates.
+func (dh *Datahub) mark(dep *archive.Deprecation, deprecate bool) bool {
+	attributes := func(current map[string]interface{}) map[string]interface{} {
+		updated := make(map[string]interface{})
+		for key, value := range current {
+			updated[key] = value
+		}
+
+		if deprecate {
+			updated[DeprecatedAttribute] = "true"
+			updated[DeprecatedAtAttribute] = dep.Since.Format(time.RFC3339)
+		} else {
+			delete(updated, DeprecatedAttribute)
+			delete(updated, DeprecatedAtAttribute)
+		}
+
+		return updated
+	}
+
+	op := &archive.Operation{Action: "patch", Kind: dep.Kind, Set: dep.Set}
+	var request func() (int, interface{}, error)
+
+	switch dep.Kind {
+	case "set":
+		set := dh.datahubSet(dep.Name)
+		if set == nil {
+			return !deprecate
+		}
+		set.Attributes = attributes(set.Attributes)
+
+		data := set.ToPostBody()
+		delete(data, "items")
+		patch := map[string]interface{}{"attributes": set.Attributes}
+		op.Set, op.Target, op.Payload = set.Name.Physical, set.Id, patch
+		request = func() (int, interface{}, error) {
+			return dh.patch("/catalog/set/"+set.Id, patch, func() (int, interface{}, error) {
+				return dh.put("/catalog/set/"+set.Id, data)
+			})
+		}
+	case "item":
+		set := dh.datahubSet(dep.Set)
+		if set == nil {
+			return !deprecate
+		}
+		item, err := set.GetItem(dep.Name)
+		if err != nil {
+			return !deprecate
+		}
+		item.Attributes = attributes(item.Attributes)
+
+		body := map[string]interface{}{"items": []interface{}{item.ToPostBody()}}
+		patch := map[string]interface{}{"items": []interface{}{map[string]interface{}{
+			"name":       map[string]interface{}{"physical": item.Name.Physical},
+			"attributes": item.Attributes,
+		}}}
+		op.Target, op.Payload = set.Id, patch
+		request = func() (int, interface{}, error) {
+			return dh.patch("/catalog/set/"+set.Id+"/items", patch, func() (int, interface{}, error) {
+				return dh.post("/catalog/set/"+set.Id+"/items", body)
+			})
+		}
+	case "relationship":
+		rel := dh.datahubRelationship(dep.Name)
+		if rel == nil {
+			return !deprecate
+		}
+		rel.Attributes = attributes(rel.Attributes)
+
+		body := map[string]interface{}{"relationships": []interface{}{rel.ToPostBody()}}
+		patch := map[string]interface{}{"relationships": []interface{}{map[string]interface{}{
+			"id":         rel.Id,
+			"name":       map[string]interface{}{"physical": rel.Name.Physical},
+			"attributes": rel.Attributes,
+		}}}
+		op.Target, op.Payload = rel.Id, patch
+		request = func() (int, interface{}, error) {
+			return dh.patch("/catalog/relationships", patch, func() (int, interface{}, error) {
+				return dh.put("/catalog/relationships", body)
+			})
+		}
+	default:
+		return false
+	}
+
+	action := "deprecating"
+	if !deprecate {
+		action = "reinstating"
+	}
+
+	status, _, err := dh.deliver(op, request)
+	if err == ErrQueued {
+		dh.results.queue(1)
+	} else if err != nil || (status != 200 && status != 201) {
+		dh.results.fail(1, "error %v %v (HTTP %v): %v", action, describeDeprecation(dep), status, err)
+		return false
+	} else {
+		dh.results.succeed(1)
+	}
+
+	return true
+}
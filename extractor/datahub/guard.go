@@ -0,0 +1,131 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxDeletePercent is the share of the sets or items of a data source
+// that a sync may delete before it is considered a mass deletion.
+const DefaultMaxDeletePercent = 50
+
+// Deleting fewer objects than this never counts as a mass deletion by
+// percentage, so small data sources can still be cleaned up.
+const minMassDeletion = 10
+
+// MassDeletionError reports a sync that would delete more of the Datahub than
+// the configured thresholds allow, which usually means it runs against the
+// wrong database or with the wrong schemas.
+type MassDeletionError struct {
+	Sets          int
+	TotalSets     int
+	Items         int
+	TotalItems    int
+	Relationships int
+	Reasons       []string
+	Examples      []string
+}
+
+func (e *MassDeletionError) Error() string {
+	msg := "aborting: " + e.Summary()
+
+	if len(e.Examples) > 0 {
+		msg += "\n  including " + strings.Join(e.Examples, ", ")
+	}
+
+	return msg + "\n  check the connection string and schemas, or use --allow-mass-delete to proceed"
+}
+
+// Summary describes the deletions and the thresholds they exceed.
+func (e *MassDeletionError) Summary() string {
+	return fmt.Sprintf(
+		"the sync would delete %v of %v set(s), %v of %v item(s) and %v relationship(s) (%v)",
+		e.Sets, e.TotalSets, e.Items, e.TotalItems, e.Relationships, strings.Join(e.Reasons, ", "),
+	)
+}
+
+// SetDeletionLimits configures the mass-deletion guard. A sync may delete at
+// most max objects (0 is unlimited) and at most percent of the sets or items
+// of the data source (0 uses DefaultMaxDeletePercent, a negative value is
+// unlimited).
+func (dh *Datahub) SetDeletionLimits(max int, percent float64) {
+	dh.maxdelete = max
+	dh.maxpercent = percent
+}
+
+// CheckDeletions returns a MassDeletionError when the set, item and
+// relationship diffs delete more than the deletion limits allow. Items of
+// deleted sets count as deleted items. The Datahub must be populated.
+func (dh *Datahub) CheckDeletions(sets *archive.Diff, items *archive.Diff, rels *archive.Diff) error {
+	e := &MassDeletionError{Relationships: len(rels.Deleted)}
+
+	for _, schema := range dh.doc.GetSchemas() {
+		e.TotalSets += len(schema.Sets)
+		for _, set := range schema.Sets {
+			e.TotalItems += len(set.Items)
+		}
+	}
+
+	for _, obj := range sets.Deleted {
+		set := obj.(*doc.Set)
+		e.Sets++
+		if current := dh.datahubSet(set.Name.Physical); current != nil {
+			e.Items += len(current.Items)
+		}
+		e.example(set.Name.Physical)
+	}
+
+	for _, obj := range items.Deleted {
+		item := obj.(*doc.Item)
+		e.Items++
+		e.example(item.Set().Name.Physical + "." + item.Name.Physical)
+	}
+
+	for _, obj := range rels.Deleted {
+		e.example(obj.(*doc.Relationship).Name.Physical)
+	}
+
+	total := e.Sets + e.Items + e.Relationships
+	if dh.maxdelete > 0 && total > dh.maxdelete {
+		e.Reasons = append(e.Reasons, fmt.Sprintf("more than %v object(s)", dh.maxdelete))
+	}
+
+	percent := dh.maxpercent
+	if percent == 0 {
+		percent = DefaultMaxDeletePercent
+	}
+
+	if percent > 0 {
+		if e.Sets >= minMassDeletion && share(e.Sets, e.TotalSets) > percent {
+			e.Reasons = append(e.Reasons, fmt.Sprintf("%.0f%% of the sets, more than %v%%", share(e.Sets, e.TotalSets), percent))
+		}
+
+		if e.Items >= minMassDeletion && share(e.Items, e.TotalItems) > percent {
+			e.Reasons = append(e.Reasons, fmt.Sprintf("%.0f%% of the items, more than %v%%", share(e.Items, e.TotalItems), percent))
+		}
+	}
+
+	if len(e.Reasons) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func (e *MassDeletionError) example(name string) {
+	if len(e.Examples) < 5 {
+		e.Examples = append(e.Examples, name)
+	} else if len(e.Examples) == 5 {
+		e.Examples = append(e.Examples, "...")
+	}
+}
+
+func share(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(count) * 100 / float64(total)
+}
@@ -0,0 +1,72 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCheckDeletions(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int
+		sets    int
+		items   int
+		rels    int
+		max     int
+		percent float64
+		trips   bool
+	}{
+		{"nothing deleted", 20, 0, 0, 0, 0, 0, false},
+		{"below the floor", 10, 9, 0, 0, 0, 0, false},
+		{"at the floor", 12, 10, 0, 0, 0, 0, true},
+		{"half of the sets", 20, 10, 0, 0, 0, 0, false},
+		{"more than half of the sets", 20, 11, 0, 0, 0, 0, true},
+		{"half of the items", 20, 0, 10, 0, 0, 0, false},
+		{"more than half of the items", 20, 0, 11, 0, 0, 0, true},
+		{"within a higher percentage", 20, 12, 0, 0, 0, 60, false},
+		{"above a higher percentage", 20, 13, 0, 0, 0, 60, true},
+		{"above a lower percentage", 40, 11, 0, 0, 0, 25, true},
+		{"unlimited percentage", 20, 20, 0, 0, 0, -1, false},
+		{"at the maximum", 20, 0, 0, 5, 5, -1, false},
+		{"above the maximum", 20, 0, 0, 6, 5, -1, true},
+		// The items of a deleted set count as deleted.
+		{"sets and their items above the maximum", 20, 3, 0, 0, 5, -1, true},
+	}
+
+	for _, test := range tests {
+		dh, err := New("http://datahub.invalid", "public", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The data source has total sets of one item each.
+		dh.doc = doc.New(&doc.Source{Name: doc.Name{Physical: "public"}})
+		schema := dh.doc.ApplySchema(&doc.Schema{Name: doc.Name{Physical: "public"}, Sets: map[string]*doc.Set{}})
+		sets, items, rels := archive.CreateDiff(), archive.CreateDiff(), archive.CreateDiff()
+		for i := 0; i < test.total; i++ {
+			set := schema.UpsertSet(&doc.Set{Name: doc.Name{Physical: fmt.Sprintf("set%02d", i)}, Items: map[string]*doc.Item{}})
+			item := set.UpsertItem(&doc.Item{Name: doc.Name{Physical: "id"}})
+
+			switch {
+			case i < test.sets:
+				sets.Deleted = append(sets.Deleted, set)
+			case i < test.sets+test.items:
+				items.Deleted = append(items.Deleted, item)
+			}
+		}
+		for i := 0; i < test.rels; i++ {
+			rels.Deleted = append(rels.Deleted, &doc.Relationship{Name: doc.Name{Physical: fmt.Sprintf("rel%02d", i)}})
+		}
+
+		dh.SetDeletionLimits(test.max, test.percent)
+		err = dh.CheckDeletions(sets, items, rels)
+
+		var mass *MassDeletionError
+		if trips := errors.As(err, &mass); trips != test.trips {
+			t.Errorf("%v: err = %v, want a mass deletion = %v", test.name, err, test.trips)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/util"
+	"fmt"
+	"time"
+)
+
+const (
+	JobRunning = "running"
+	JobSuccess = "success"
+	JobFailed  = "failed"
+)
+
+// JobLog posts structured status events to a Datahub system/job, so the
+// Datahub keeps a record of when each source was synced and the outcome.
+// Reporting is best effort: a failure to log never fails the sync.
+type JobLog struct {
+	dh    *Datahub
+	id    string
+	start time.Time
+}
+
+// Job returns the status log for the specified system/job ID. When the ID is
+// empty, every event is discarded.
+func (dh *Datahub) Job(id string) *JobLog {
+	return &JobLog{dh: dh, id: id, start: time.Now()}
+}
+
+func (j *JobLog) Enabled() bool {
+	return j != nil && j.id != util.EmptyString
+}
+
+// Report posts an event to the job log.
+func (j *JobLog) Report(event string, status string, message string, data ...map[string]interface{}) error {
+	if !j.Enabled() || j.dh.offline {
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"event":     event,
+		"status":    status,
+		"message":   message,
+		"source":    j.dh.source,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if len(data) > 0 && data[0] != nil {
+		body["data"] = data[0]
+	}
+
+	status_code, _, err := j.dh.post("/system/"+j.id+"/log", body)
+	if err != nil {
+		j.dh.log().Warn("failed to report to the job log", "event", event, "job", j.id, "status", status_code, "error", err)
+	}
+
+	return err
+}
+
+func (j *JobLog) Started(data map[string]interface{}) error {
+	return j.Report("sync.started", JobRunning, "Synchronization started", data)
+}
+
+func (j *JobLog) Extracted(sets int, items int, relationships int, duration time.Duration) error {
+	return j.Report("extraction.finished", JobRunning, fmt.Sprintf("Extracted %v set(s), %v item(s) and %v relationship(s)", sets, items, relationships), map[string]interface{}{
+		"sets":          sets,
+		"items":         items,
+		"relationships": relationships,
+		"duration_ms":   duration.Milliseconds(),
+	})
+}
+
+// Diffed reports the number of additions, deletions and updates for each
+// kind of object (i.e. "set", "item", "relationship").
+func (j *JobLog) Diffed(summary map[string]map[string]int) error {
+	data := map[string]interface{}{}
+	total := 0
+	for kind, counts := range summary {
+		data[kind] = counts
+		for _, count := range counts {
+			total += count
+		}
+	}
+
+	return j.Report("diff.finished", JobRunning, fmt.Sprintf("Identified %v change(s)", total), data)
+}
+
+func (j *JobLog) Committed(results *CommitResults) error {
+	status := JobRunning
+	if results.Failed > 0 {
+		status = JobFailed
+	}
+
+	return j.Report("commit.finished", status, fmt.Sprintf("Committed %v change(s), %v failed, %v queued", results.Succeeded, results.Failed, results.Queued), map[string]interface{}{
+		"succeeded": results.Succeeded,
+		"failed":    results.Failed,
+		"queued":    results.Queued,
+		"errors":    results.Errors,
+	})
+}
+
+// Finished reports the final outcome of the sync, along with the total
+// duration since the job log was created.
+func (j *JobLog) Finished(err error) error {
+	duration := time.Since(j.start)
+
+	if err != nil {
+		return j.Report("sync.failed", JobFailed, err.Error(), map[string]interface{}{
+			"error":       err.Error(),
+			"duration_ms": duration.Milliseconds(),
+		})
+	}
+
+	return j.Report("sync.finished", JobSuccess, fmt.Sprintf("Synchronization completed in %s", duration), map[string]interface{}{
+		"duration_ms": duration.Milliseconds(),
+	})
+}
+
+// DiffSummary counts the additions, deletions and updates in a diff.
+func DiffSummary(d *archive.Diff) map[string]int {
+	return map[string]int{"add": len(d.Added), "delete": len(d.Deleted), "update": len(d.Updated)}
+}
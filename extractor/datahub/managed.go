@@ -0,0 +1,309 @@
+package datahub
+
+import (
+	"crypto/sha256"
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The metadata marker of the Datahub objects created by this tool. Objects
+// without it (i.e. relationships added by data stewards) are never updated or
+// deleted by a sync.
+const (
+	ManagedBy      = "dh-util"
+	ManagedByKey   = "managed_by"
+	FingerprintKey = "source_fingerprint"
+)
+
+// Fingerprint identifies the database behind a connection string (its host,
+// port and database name, without credentials), so objects created from
+// another database are recognized.
+func Fingerprint(connstr string) string {
+	identity := connstr
+	if uri, err := url.Parse(connstr); err == nil && uri.Host != util.EmptyString {
+		identity = uri.Host + uri.Path
+	} else if at := strings.LastIndex(connstr, "@"); at >= 0 {
+		identity = connstr[at+1:]
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(identity))))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Manage tags every object the sync creates (or fully updates) with the
+// managed_by marker and the source fingerprint, and restricts updates and
+// deletions to objects with the marker (see ProtectUnmanaged). When adopt is
+// true, objects without the marker (i.e. created by earlier versions) that
+// still exist in the data source receive the marker and are updated.
+func (dh *Datahub) Manage(fingerprint string, adopt bool) {
+	dh.managed = true
+	dh.fingerprint = fingerprint
+	dh.adopt = adopt
+}
+
+// Stamp adds the managed_by marker and the source fingerprint to metadata.
+func (dh *Datahub) Stamp(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+
+	metadata[ManagedByKey] = ManagedBy
+	if dh.fingerprint != util.EmptyString {
+		metadata[FingerprintKey] = dh.fingerprint
+	}
+
+	return metadata
+}
+
+// Owns reports whether an object with the specified metadata may be deleted:
+// it must carry the marker and must not have been created from another
+// database.
+func (dh *Datahub) Owns(metadata map[string]interface{}) bool {
+	return managed(metadata) && !dh.foreign(metadata)
+}
+
+func managed(metadata map[string]interface{}) bool {
+	value, _ := metadata[ManagedByKey].(string)
+	return value == ManagedBy
+}
+
+func (dh *Datahub) foreign(metadata map[string]interface{}) bool {
+	fingerprint, _ := metadata[FingerprintKey].(string)
+	return fingerprint != util.EmptyString && dh.fingerprint != util.EmptyString && fingerprint != dh.fingerprint
+}
+
+// stamp tags an object about to be created or updated.
+func (dh *Datahub) stamp(obj interface{}) {
+	if !dh.managed {
+		return
+	}
+
+	switch value := obj.(type) {
+	case *doc.Set:
+		value.Metadata = dh.Stamp(value.Metadata)
+		for _, item := range value.Items {
+			item.Metadata = dh.Stamp(item.Metadata)
+		}
+	case *doc.Item:
+		value.Metadata = dh.Stamp(value.Metadata)
+	case *doc.Relationship:
+		value.Metadata = dh.Stamp(value.Metadata)
+	}
+}
+
+// Ownership is the outcome of ProtectUnmanaged.
+type Ownership struct {
+	// Unmanaged lists the objects without the marker that were not updated
+	// or deleted.
+	Unmanaged []string
+	// Foreign lists the objects created from another database that were not
+	// deleted.
+	Foreign []string
+	// The objects without the marker that receive it (adopt).
+	Sets          []*doc.Set
+	Items         []*doc.Item
+	Relationships []*doc.Relationship
+}
+
+// ProtectUnmanaged removes the updates and deletions of Datahub objects
+// without the managed_by marker from the diffs, as well as the deletions of
+// objects created from another database. When unmarked objects are adopted,
+// they are updated, and the ones that still exist in the data source are
+// listed so CommitAdoptions can tag them. Unmarked objects are never deleted.
+// The Datahub must be populated. Nothing happens unless Manage was called.
+func (dh *Datahub) ProtectUnmanaged(diffs ...*archive.Diff) *Ownership {
+	o := &Ownership{}
+	if !dh.managed {
+		return o
+	}
+
+	missing := map[string]bool{}
+	for _, d := range diffs {
+		remaining := make([]interface{}, 0, len(d.Deleted))
+		for _, obj := range d.Deleted {
+			metadata, name, exists := dh.datahubMetadata(obj)
+			missing[name] = true
+			switch {
+			case exists && !managed(metadata):
+				o.Unmanaged = append(o.Unmanaged, name)
+			case exists && dh.foreign(metadata):
+				o.Foreign = append(o.Foreign, name)
+			default:
+				remaining = append(remaining, obj)
+			}
+		}
+		d.Deleted = remaining
+
+		if dh.adopt {
+			continue
+		}
+
+		updates := make([]interface{}, 0, len(d.Updated))
+		for _, obj := range d.Updated {
+			metadata, name, exists := dh.datahubMetadata(obj)
+			if exists && !managed(metadata) {
+				o.Unmanaged = append(o.Unmanaged, name)
+				continue
+			}
+			updates = append(updates, obj)
+		}
+		d.Updated = updates
+	}
+
+	if !dh.adopt {
+		return o
+	}
+
+	for _, schema := range dh.doc.GetSchemas() {
+		for _, set := range schema.Sets {
+			if missing["set "+set.Name.Physical] {
+				continue
+			}
+
+			if !managed(set.Metadata) {
+				o.Sets = append(o.Sets, set)
+			}
+
+			for _, item := range set.Items {
+				if !managed(item.Metadata) && !missing["item "+set.Name.Physical+"."+item.Name.Physical] {
+					o.Items = append(o.Items, item)
+				}
+			}
+		}
+
+		for _, rel := range schema.Relationships {
+			if !managed(rel.Metadata) && !missing["relationship "+rel.Name.Physical] && rel.Id != util.EmptyString {
+				o.Relationships = append(o.Relationships, rel)
+			}
+		}
+	}
+
+	return o
+}
+
+// datahubMetadata finds the metadata of the Datahub object that corresponds
+// to a diffed object, and describes the object.
+func (dh *Datahub) datahubMetadata(obj interface{}) (map[string]interface{}, string, bool) {
+	switch value := obj.(type) {
+	case *doc.Set:
+		name := "set " + value.Name.Physical
+		if set := dh.datahubSet(value.Name.Physical); set != nil {
+			return set.Metadata, name, true
+		}
+		return nil, name, false
+	case *doc.Item:
+		name := "item " + value.Set().Name.Physical + "." + value.Name.Physical
+		if set := dh.datahubSet(value.Set().Name.Physical); set != nil {
+			if item, err := set.GetItem(value.Name.Physical); err == nil {
+				return item.Metadata, name, true
+			}
+		}
+		return nil, name, false
+	case *doc.Relationship:
+		name := "relationship " + value.Name.Physical
+		if rel := dh.datahubRelationship(value.Name.Physical); rel != nil {
+			return rel.Metadata, name, true
+		}
+		return nil, name, false
+	}
+
+	return nil, util.EmptyString, false
+}
+
+// DryRunOwnership previews the objects left untouched because they are not
+// managed by this tool, and the objects that are adopted.
+func (dh *Datahub) DryRunOwnership(o *Ownership, max int) {
+	for _, group := range []struct {
+		label string
+		list  []string
+	}{
+		{"are not managed by " + ManagedBy + " and will be left untouched", o.Unmanaged},
+		{"were created from another database and will not be deleted", o.Foreign},
+	} {
+		if len(group.list) > 0 {
+			dh.preview(fmt.Sprintf("%v object(s) %v", len(group.list), group.label), "=", group.list, max)
+		}
+	}
+
+	if adopted := len(o.Sets) + len(o.Items) + len(o.Relationships); adopted > 0 {
+		dh.preview(fmt.Sprintf("%v set(s), %v item(s) and %v relationship(s) will be tagged as managed by %v", len(o.Sets), len(o.Items), len(o.Relationships), ManagedBy), "+", nil, max)
+	}
+}
+
+// CommitAdoptions adds the managed_by marker to the adopted objects. Only the
+// metadata is sent when the Datahub supports partial updates.
+func (dh *Datahub) CommitAdoptions(o *Ownership) {
+	for _, set := range o.Sets {
+		set.Metadata = dh.Stamp(set.Metadata)
+		data := set.ToPostBody()
+		delete(data, "items")
+		patch := map[string]interface{}{"name": data["name"], "metadata": set.Metadata}
+
+		status, _, err := dh.deliver(&archive.Operation{Action: "patch", Kind: "set", Set: set.Name.Physical, Target: set.Id, Payload: patch}, func() (int, interface{}, error) {
+			return dh.patch("/catalog/set/"+set.Id, patch, func() (int, interface{}, error) {
+				return dh.put("/catalog/set/"+set.Id, data)
+			})
+		})
+		dh.adopted(1, "set "+set.Name.Physical, status, err)
+	}
+
+	sets := make(map[*doc.Set][]*doc.Item)
+	for _, item := range o.Items {
+		sets[item.Set()] = append(sets[item.Set()], item)
+	}
+
+	for set, items := range sets {
+		for _, batch := range chunk(items, dh.batchSize()) {
+			full := make([]interface{}, 0, len(batch))
+			changes := make([]interface{}, 0, len(batch))
+			for _, item := range batch {
+				item.Metadata = dh.Stamp(item.Metadata)
+				full = append(full, item.ToPostBody())
+				changes = append(changes, map[string]interface{}{"name": map[string]interface{}{"physical": item.Name.Physical}, "metadata": item.Metadata})
+			}
+			body := map[string]interface{}{"items": full}
+			patch := map[string]interface{}{"items": changes}
+
+			status, _, err := dh.deliver(&archive.Operation{Action: "patch", Kind: "item", Set: set.Name.Physical, Target: set.Id, Payload: patch}, func() (int, interface{}, error) {
+				return dh.patch("/catalog/set/"+set.Id+"/items", patch, func() (int, interface{}, error) {
+					return dh.post("/catalog/set/"+set.Id+"/items", body)
+				})
+			})
+			dh.adopted(len(batch), "items of the "+set.Name.Physical+" set", status, err)
+		}
+	}
+
+	for _, batch := range chunk(o.Relationships, dh.batchSize()) {
+		full := make([]interface{}, 0, len(batch))
+		changes := make([]interface{}, 0, len(batch))
+		for _, rel := range batch {
+			rel.Metadata = dh.Stamp(rel.Metadata)
+			full = append(full, rel.ToPostBody())
+			changes = append(changes, map[string]interface{}{"id": rel.Id, "name": map[string]interface{}{"physical": rel.Name.Physical}, "metadata": rel.Metadata})
+		}
+		body := map[string]interface{}{"relationships": full}
+		patch := map[string]interface{}{"relationships": changes}
+
+		status, _, err := dh.deliver(&archive.Operation{Action: "patch", Kind: "relationship", Payload: patch}, func() (int, interface{}, error) {
+			return dh.patch("/catalog/relationships", patch, func() (int, interface{}, error) {
+				return dh.put("/catalog/relationships", body)
+			})
+		})
+		dh.adopted(len(batch), fmt.Sprintf("%v relationship(s)", len(batch)), status, err)
+	}
+}
+
+func (dh *Datahub) adopted(count int, name string, status int, err error) {
+	if err == ErrQueued {
+		dh.results.queue(count)
+	} else if err != nil || (status != 200 && status != 201) {
+		dh.results.fail(count, "error tagging %v as managed (HTTP %v): %v", name, status, err)
+	} else {
+		dh.results.succeed(count)
+	}
+}
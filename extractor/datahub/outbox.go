@@ -0,0 +1,438 @@
+package datahub
+
+import (
+	"context"
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrQueued is returned for changes that were queued in the archive outbox
+// because the Datahub could not be reached.
+var ErrQueued = errors.New("the Datahub is unreachable; the change was queued in the outbox")
+
+// unreachable determines whether a request failed because the Datahub could
+// not be reached (as opposed to the Datahub rejecting the request).
+func unreachable(status int, err error) bool {
+	if status == 502 || status == 503 || status == 504 {
+		return true
+	}
+
+	if err == nil || status != 0 {
+		return false
+	}
+
+	var operr *net.OpError
+	if errors.As(err, &operr) {
+		return true
+	}
+
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Timeout() || errors.Is(uerr.Err, io.EOF) || errors.Is(uerr.Err, io.ErrUnexpectedEOF)
+	}
+
+	return false
+}
+
+// disconnected reports whether the Datahub is offline, either because an
+// earlier request could not reach it or because of the specified response.
+// Once offline, every remaining change is queued without being attempted.
+func (dh *Datahub) disconnected(status int, err error) bool {
+	if dh.offline {
+		return true
+	}
+
+	if !unreachable(status, err) {
+		return false
+	}
+
+	reason := fmt.Sprintf("HTTP %v", status)
+	if err != nil {
+		reason = err.Error()
+	}
+	dh.log().Warn("the Datahub is unreachable; queueing the remaining changes in the outbox", "reason", strings.TrimSpace(reason))
+	dh.offline = true
+
+	return true
+}
+
+// SetContext sets the context of the Datahub requests. Once the context is
+// done (i.e. the sync is interrupted or times out), reads fail, the requests
+// in flight are allowed to finish and every remaining change is queued in the
+// outbox, so it is delivered by the next sync or by the flush command.
+func (dh *Datahub) SetContext(ctx context.Context) {
+	dh.ctx = ctx
+	dh.stopping = false
+}
+
+func (dh *Datahub) context() context.Context {
+	if dh.ctx == nil {
+		return context.Background()
+	}
+
+	return dh.ctx
+}
+
+// stopped reports whether the context is done, so no more changes are sent.
+func (dh *Datahub) stopped() bool {
+	if dh.context().Err() == nil {
+		return false
+	}
+
+	if !dh.stopping {
+		dh.log().Warn("the sync was stopped; queueing the remaining changes in the outbox", "reason", dh.context().Err())
+		dh.stopping = true
+	}
+
+	return true
+}
+
+// deliver sends a change to the Datahub. When the Datahub cannot be reached,
+// or the context is done, the change is queued in the outbox and ErrQueued is
+// returned.
+func (dh *Datahub) deliver(op *archive.Operation, request func() (int, interface{}, error)) (int, interface{}, error) {
+	if !dh.offline && !dh.stopped() {
+		status, result, err := request()
+		if !dh.disconnected(status, err) && !(status == 0 && dh.stopped()) {
+			return status, result, err
+		}
+	}
+
+	return 0, nil, dh.enqueue(op)
+}
+
+func (dh *Datahub) enqueue(op *archive.Operation) error {
+	if dh.archive == nil {
+		return errors.New("cannot queue " + op.String() + ": no archive is available for the outbox")
+	}
+
+	op.Source = dh.sourceID()
+	if err := dh.archive.Enqueue(op); err != nil {
+		return err
+	}
+
+	dh.log().Info("queued "+op.String(), "operation", op.String())
+	return ErrQueued
+}
+
+// sourceID is the Datahub ID of the data source, once it has been resolved.
+func (dh *Datahub) sourceID() string {
+	if id, ok := dh.sourcedata["id"].(string); ok && id != util.EmptyString {
+		return id
+	}
+
+	return dh.source
+}
+
+// Offline reports whether changes are being queued in the outbox because the
+// Datahub could not be reached.
+func (dh *Datahub) Offline() bool {
+	return dh.offline
+}
+
+// catalog is the current Datahub state of a data source, used to reconcile
+// queued operations before they are delivered.
+type catalog struct {
+	sets          map[string]string
+	relationships map[string]string
+}
+
+// setID resolves the set an operation applies to, by ID and then by name.
+func (c *catalog) setID(op *archive.Operation) string {
+	if op.Kind == "set" && contains(c.sets, op.Target) {
+		return op.Target
+	}
+
+	return c.sets[strings.ToLower(strings.TrimSpace(op.Set))]
+}
+
+func contains(index map[string]string, id string) bool {
+	for _, value := range index {
+		if id != util.EmptyString && value == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indexNames adds the Datahub objects in a response list to an index of IDs
+// by (lower case) physical name.
+func indexNames(list interface{}, index map[string]string) {
+	objects, _ := list.([]interface{})
+	for _, raw := range objects {
+		obj, _ := raw.(map[string]interface{})
+		name, _ := obj["name"].(map[string]interface{})
+		id, _ := obj["id"].(string)
+		physical, _ := name["physical"].(string)
+		if id != util.EmptyString {
+			index[strings.ToLower(strings.TrimSpace(physical))] = id
+		}
+	}
+}
+
+func (dh *Datahub) catalog() (*catalog, error) {
+	c := &catalog{sets: map[string]string{}, relationships: map[string]string{}}
+
+	id := dh.sourceID()
+	for _, uri := range []string{"/catalog/source/" + id + "?expand=sets", "/catalog/relationships/source/" + id} {
+		status, body, err := dh.get(uri)
+		if err != nil {
+			return c, err
+		}
+
+		if status != 200 {
+			return c, fmt.Errorf("failed to retrieve the current Datahub state (HTTP %v for GET %v)", status, uri)
+		}
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return c, err
+		}
+
+		indexNames(data["sets"], c.sets)
+		indexNames(data["relationships"], c.relationships)
+	}
+
+	return c, nil
+}
+
+// Flush delivers the operations queued in the outbox for the data source, in
+// the order they were queued. Each operation is first reconciled with the
+// current Datahub state (so Flush must be called before PopulateSources):
+// additions of objects that already exist become updates, and updates or
+// deletions of objects that no longer exist are dropped. When the Datahub is still unreachable, the remaining operations
+// stay queued and an error is returned.
+func (dh *Datahub) Flush() error {
+	if dh.archive == nil {
+		return nil
+	}
+
+	pending, err := dh.archive.Outbox()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	if dh.sourcedata["id"] == nil {
+		if err := dh.PopulateSources(); err != nil {
+			return err
+		}
+	}
+
+	// The source is re-read from scratch after the flush, since the flush
+	// resolves the source and may change it.
+	defer func() {
+		dh.doc = doc.New(&doc.Source{Name: doc.Name{Physical: dh.source}})
+		dh.sourcedata = map[string]interface{}{}
+	}()
+
+	ops, err := dh.archive.Outbox(dh.sourceID())
+	if err != nil || len(ops) == 0 {
+		return err
+	}
+
+	dh.log().Info("flushing queued changes from the outbox...", "count", len(ops))
+	dh.offline = false
+
+	state, err := dh.catalog()
+	if err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		if dh.stopped() {
+			return fmt.Errorf("the sync was stopped; %v change(s) remain queued in the outbox", len(ops)-i)
+		}
+
+		status, err := dh.replay(op, state)
+
+		if dh.disconnected(status, err) {
+			dh.offline = false
+			dh.archive.Deferred(op, err)
+			return fmt.Errorf("the Datahub is unreachable; %v change(s) remain queued in the outbox", len(ops)-i)
+		}
+
+		count := op.Count()
+		if err != nil {
+			dh.results.fail(count, "failed to deliver queued %v: %v", op, err)
+		} else if status != 0 {
+			dh.results.succeed(count)
+		}
+
+		if err := dh.archive.Dequeue(op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replay delivers a single queued operation. A zero status with no error
+// means the operation is obsolete and was skipped.
+func (dh *Datahub) replay(op *archive.Operation, state *catalog) (int, error) {
+	skip := func(reason string) (int, error) {
+		dh.log().Info("skipped queued "+op.String(), "operation", op.String(), "reason", reason)
+		return 0, nil
+	}
+
+	check := func(status int, result interface{}, err error) (int, error) {
+		if err == nil && status != 200 && status != 201 {
+			err = fmt.Errorf("HTTP %v", status)
+		}
+		return status, err
+	}
+
+	dh.log().Info("delivering queued "+op.String(), "operation", op.String())
+
+	switch op.Kind + ":" + op.Action {
+	case "set:add":
+		if id := state.setID(op); id != util.EmptyString {
+			dh.log().Info("the set already exists, updating it instead", "set", op.Set)
+			data := op.Payload
+			delete(data, "items")
+			return check(dh.put("/catalog/set/"+id, data))
+		}
+
+		status, result, err := dh.post("/catalog/source/"+dh.sourceID()+"/set", op.Payload)
+		if err == nil {
+			if data, ok := result.(map[string]interface{}); ok && data["id"] != nil {
+				state.sets[strings.ToLower(strings.TrimSpace(op.Set))] = data["id"].(string)
+			}
+		}
+		return check(status, result, err)
+
+	case "set:update":
+		id := state.setID(op)
+		if id == util.EmptyString {
+			return skip("the set no longer exists")
+		}
+		return check(dh.put("/catalog/set/"+id, op.Payload))
+
+	case "set:patch":
+		id := state.setID(op)
+		if id == util.EmptyString {
+			return skip("the set no longer exists")
+		}
+		// Only the changed fields were queued: without PATCH, they are merged
+		// into the current set.
+		return check(dh.patch("/catalog/set/"+id, op.Payload, func() (int, interface{}, error) {
+			data, err := dh.merge("set", id, op.Payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			return dh.put("/catalog/set/"+id, data)
+		}))
+
+	case "set:delete":
+		if !contains(state.sets, op.Target) {
+			return skip("the set was already deleted")
+		}
+		status, err := check(dh.delete("/catalog/set/" + op.Target))
+		if err == nil {
+			delete(state.sets, strings.ToLower(strings.TrimSpace(op.Set)))
+		}
+		return status, err
+
+	case "item:add", "item:update":
+		id := state.setID(op)
+		if id == util.EmptyString {
+			return skip("the " + op.Set + " set does not exist")
+		}
+		return check(dh.post("/catalog/set/"+id+"/items", op.Payload))
+
+	case "item:patch":
+		id := state.setID(op)
+		if id == util.EmptyString {
+			return skip("the " + op.Set + " set does not exist")
+		}
+		return check(dh.patch("/catalog/set/"+id+"/items", op.Payload, func() (int, interface{}, error) {
+			data, err := dh.merge("item", id, op.Payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			return dh.post("/catalog/set/"+id+"/items", data)
+		}))
+
+	case "item:delete":
+		status, _, err := dh.get("/catalog/item/" + op.Target)
+		if err != nil {
+			return status, err
+		}
+		if status == 404 {
+			return skip("the item was already deleted")
+		}
+		return check(dh.delete("/catalog/item/" + op.Target))
+
+	case "relationship:add", "relationship:update":
+		rels, _ := op.Payload["relationships"].([]interface{})
+		added := make([]interface{}, 0)
+		updated := make([]interface{}, 0)
+		for _, raw := range rels {
+			rel, _ := raw.(map[string]interface{})
+			name, _ := rel["name"].(map[string]interface{})
+			physical, _ := name["physical"].(string)
+			if _, exists := state.relationships[strings.ToLower(strings.TrimSpace(physical))]; exists {
+				updated = append(updated, rel)
+			} else {
+				added = append(added, rel)
+			}
+		}
+
+		var status int
+		var err error
+		if len(added) > 0 {
+			status, err = check(dh.post("/catalog/relationships", map[string]interface{}{"relationships": added}))
+			if err != nil {
+				return status, err
+			}
+		}
+		if len(updated) > 0 {
+			status, err = check(dh.put("/catalog/relationships", map[string]interface{}{"relationships": updated}))
+		}
+		return status, err
+
+	case "relationship:patch":
+		rels, _ := op.Payload["relationships"].([]interface{})
+		remaining := make([]interface{}, 0)
+		for _, raw := range rels {
+			rel, _ := raw.(map[string]interface{})
+			if id, ok := rel["id"].(string); ok && contains(state.relationships, id) {
+				remaining = append(remaining, rel)
+			}
+		}
+		if len(remaining) == 0 {
+			return skip("the relationships no longer exist")
+		}
+		body := map[string]interface{}{"relationships": remaining}
+		return check(dh.patch("/catalog/relationships", body, func() (int, interface{}, error) {
+			data, err := dh.merge("relationship", util.EmptyString, body)
+			if err != nil {
+				return 0, nil, err
+			}
+			return dh.put("/catalog/relationships", data)
+		}))
+
+	case "relationship:delete":
+		ids, _ := op.Payload["relationships"].([]interface{})
+		remaining := make([]interface{}, 0)
+		for _, id := range ids {
+			if value, ok := id.(string); ok && contains(state.relationships, value) {
+				remaining = append(remaining, value)
+			}
+		}
+		if len(remaining) == 0 {
+			return skip("the relationships were already deleted")
+		}
+		return check(dh.delete("/catalog/relationships", map[string]interface{}{"relationships": remaining}))
+	}
+
+	return 0, fmt.Errorf("unrecognized outbox operation %v", op)
+}
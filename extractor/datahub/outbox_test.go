@@ -0,0 +1,87 @@
+package datahub
+
+import (
+	"dhs/archive"
+	"dhs/extractor/datahub/datahubtest"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlushMergesPatchesWithoutPatchSupport(t *testing.T) {
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.NoPatch = true
+
+	src := server.AddSource("public")
+	src.Sets = append(src.Sets, &datahubtest.Set{
+		ID:          "set-users",
+		Name:        datahubtest.Name{Physical: "users", Logical: "Users"},
+		Description: "The customers",
+		Metadata:    map[string]interface{}{"owner": "sales"},
+		Items: []*datahubtest.Item{{
+			ID:          "item-email",
+			Name:        datahubtest.Name{Physical: "email", Logical: "E-mail"},
+			Description: "The login",
+			Type:        "varchar",
+		}},
+	})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	cache, err := archive.Open(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh, err := New(ts.URL, "public", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	deprecated := map[string]interface{}{DeprecatedAttribute: "true"}
+	err = cache.Enqueue(
+		&archive.Operation{Source: src.ID, Action: "patch", Kind: "set", Set: "users", Target: "set-users", Payload: map[string]interface{}{
+			"name":       map[string]interface{}{"physical": "users"},
+			"attributes": deprecated,
+		}},
+		&archive.Operation{Source: src.ID, Action: "patch", Kind: "item", Set: "users", Target: "set-users", Payload: map[string]interface{}{
+			"items": []interface{}{map[string]interface{}{
+				"name":       map[string]interface{}{"physical": "email"},
+				"attributes": deprecated,
+			}},
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dh.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if results := dh.Results(); results.Succeeded != 2 || results.Failed != 0 {
+		t.Fatalf("succeeded %v, failed %v, want 2 and 0: %v", results.Succeeded, results.Failed, results.Errors)
+	}
+
+	set := server.Source("public").Sets[0]
+	if set.Name.Logical != "Users" || set.Description != "The customers" || set.Metadata["owner"] != "sales" {
+		t.Errorf("the set lost its fields: %+v", set)
+	}
+	if set.Attributes[DeprecatedAttribute] != "true" {
+		t.Errorf("the set attributes were not updated: %v", set.Attributes)
+	}
+
+	item := set.Items[0]
+	if item.Name.Logical != "E-mail" || item.Description != "The login" || item.Type != "varchar" {
+		t.Errorf("the item lost its fields: %+v", item)
+	}
+	if item.Attributes[DeprecatedAttribute] != "true" {
+		t.Errorf("the item attributes were not updated: %v", item.Attributes)
+	}
+}
@@ -0,0 +1,171 @@
+package datahub
+
+import (
+	"dhs/extractor"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"fmt"
+)
+
+// patch sends a partial update. Datahub versions that do not support PATCH
+// receive the full update (fallback) instead.
+func (dh *Datahub) patch(endpoint string, data interface{}, fallback func() (int, interface{}, error)) (int, interface{}, error) {
+	if dh.nopatch {
+		return fallback()
+	}
+
+	status, result, err := dh.send("PATCH", endpoint, data)
+	if status == 405 || status == 501 {
+		dh.log().Info("the Datahub does not support partial updates; sending full updates instead")
+		dh.nopatch = true
+		return fallback()
+	}
+
+	return status, result, err
+}
+
+// merge turns a queued partial update into a full update, for Datahub
+// versions that do not support PATCH: the objects are re-read and the queued
+// fields applied to them, so the other fields are kept. The set is the ID of
+// the set (or of the parent set of items).
+func (dh *Datahub) merge(kind string, set string, payload map[string]interface{}) (map[string]interface{}, error) {
+	current, err := dh.reread()
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-read the Datahub state: %w", err)
+	}
+
+	var parent *doc.Set
+	for _, s := range extractor.GetAllSets(current) {
+		if s.Id == set {
+			parent = s
+		}
+	}
+
+	switch kind {
+	case "set":
+		if parent == nil {
+			return nil, errors.New("the set no longer exists")
+		}
+
+		data := parent.ToPostBody()
+		delete(data, "items")
+		if parent.Source != util.EmptyString {
+			data["definition"] = parent.Source
+		}
+
+		return overlay(data, payload), nil
+
+	case "item":
+		if parent == nil {
+			return nil, errors.New("the set no longer exists")
+		}
+
+		changes, _ := payload["items"].([]interface{})
+		items := make([]interface{}, 0, len(changes))
+		for _, raw := range changes {
+			change, _ := raw.(map[string]interface{})
+			item, err := parent.GetItem(physical(change["name"]))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, overlay(item.ToPostBody(), change))
+		}
+
+		return map[string]interface{}{"items": items}, nil
+
+	case "relationship":
+		changes, _ := payload["relationships"].([]interface{})
+		rels := make([]interface{}, 0, len(changes))
+		for _, raw := range changes {
+			change, _ := raw.(map[string]interface{})
+			var data map[string]interface{}
+			for _, rel := range extractor.GetAllRelationships(current) {
+				if rel.Id == change["id"] {
+					data = rel.ToPostBody()
+				}
+			}
+			if data == nil {
+				return nil, fmt.Errorf("the %v relationship no longer exists", physical(change["name"]))
+			}
+			rels = append(rels, overlay(data, change, "id"))
+		}
+
+		return map[string]interface{}{"relationships": rels}, nil
+	}
+
+	return nil, fmt.Errorf("%v objects cannot be merged", kind)
+}
+
+// reread loads the current sets, items and relationships of the data source
+// into a new document.
+func (dh *Datahub) reread() (*doc.Doc, error) {
+	saved := dh.doc
+	defer func() { dh.doc = saved }()
+
+	dh.doc = doc.New(&doc.Source{Name: doc.Name{Physical: dh.source}})
+	if err := dh.PopulateSources(); err != nil {
+		return nil, err
+	}
+	if err := dh.PopulateItems(nil); err != nil {
+		return nil, err
+	}
+	if err := dh.PopulateRelationships(nil); err != nil {
+		return nil, err
+	}
+
+	return dh.doc, nil
+}
+
+// overlay copies the fields of a partial update (except the skipped ones)
+// onto a full update. The names are merged, since a partial update may only
+// carry the physical name that identifies the object.
+func overlay(data map[string]interface{}, changes map[string]interface{}, skip ...string) map[string]interface{} {
+	for key, value := range changes {
+		if util.InSlice[string](key, skip) {
+			continue
+		}
+
+		if key == "name" {
+			data[key] = mergeNames(data[key], value)
+			continue
+		}
+
+		data[key] = value
+	}
+
+	return data
+}
+
+// mergeNames combines name objects; later names take precedence.
+func mergeNames(names ...interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, name := range names {
+		switch value := name.(type) {
+		case doc.Name:
+			merged["physical"] = value.Physical
+			if value.Logical != util.EmptyString {
+				merged["logical"] = value.Logical
+			}
+		case map[string]interface{}:
+			for key, part := range value {
+				merged[key] = part
+			}
+		}
+	}
+
+	return merged
+}
+
+// physical returns the physical name of a JSON name object.
+func physical(name interface{}) string {
+	switch value := name.(type) {
+	case doc.Name:
+		return value.Physical
+	case map[string]interface{}:
+		str, _ := value["physical"].(string)
+		return str
+	}
+
+	return util.EmptyString
+}
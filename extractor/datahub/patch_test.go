@@ -0,0 +1,46 @@
+package datahub
+
+import (
+	"dhs/extractor/datahub/datahubtest"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPatchOnlyFallsBackWhenUnsupported(t *testing.T) {
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dh, err := New(ts.URL, "public", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	fallback := func() (int, interface{}, error) {
+		t.Error("the fallback was sent for a missing set")
+		return 200, nil, nil
+	}
+	if status, _, _ := dh.patch("/catalog/set/missing", map[string]interface{}{}, fallback); status != 404 {
+		t.Errorf("status = %v, want 404", status)
+	}
+	if dh.nopatch {
+		t.Error("a missing object disabled partial updates")
+	}
+
+	server.NoPatch = true
+	sent := false
+	dh.patch("/catalog/set/missing", map[string]interface{}{}, func() (int, interface{}, error) {
+		sent = true
+		return 200, nil, nil
+	})
+	if !sent || !dh.nopatch {
+		t.Error("a Datahub without PATCH support did not receive the fallback")
+	}
+}
@@ -0,0 +1,48 @@
+package datahub
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// CommitResults tallies the outcome of every change pushed to the Datahub.
+type CommitResults struct {
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Queued    int      `json:"queued"`
+	Errors    []string `json:"errors,omitempty"`
+	logger    *slog.Logger
+}
+
+func (r *CommitResults) succeed(count int) {
+	r.Succeeded += count
+}
+
+func (r *CommitResults) fail(count int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logger := r.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Error(msg, "count", count)
+
+	r.Failed += count
+	r.Errors = append(r.Errors, msg)
+}
+
+func (r *CommitResults) queue(count int) {
+	r.Queued += count
+}
+
+// Add tallies the outcome of other commits.
+func (r *CommitResults) Add(other *CommitResults) {
+	r.Succeeded += other.Succeeded
+	r.Failed += other.Failed
+	r.Queued += other.Queued
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+// Results returns the outcome of all commits made through this client.
+func (dh *Datahub) Results() *CommitResults {
+	return &dh.results
+}
@@ -0,0 +1,206 @@
+package datahub
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TLSOptions configure how the Datahub server certificate is verified and
+// which client certificate (if any) is presented for mutual TLS.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system roots (i.e. an internal CA).
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and private key.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name used to verify the certificate.
+	ServerName string
+	// InsecureSkipVerify disables certificate verification. Development only.
+	InsecureSkipVerify bool
+}
+
+// ProxyOptions route Datahub requests through an HTTP(S) proxy. When no URL
+// is configured, the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment
+// variables apply.
+type ProxyOptions struct {
+	URL string
+	// NoProxy lists hosts (or domain suffixes, i.e. ".corp.local") that are
+	// contacted directly.
+	NoProxy []string
+}
+
+// NewTransport creates an HTTP transport with the TLS and proxy settings
+// applied. Either argument may be nil.
+func NewTransport(opts *TLSOptions, proxy *ProxyOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts != nil {
+		config, err := opts.config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+
+	if proxy != nil && proxy.URL != util.EmptyString {
+		uri, err := url.Parse(proxy.URL)
+		if err != nil || uri.Host == util.EmptyString {
+			return nil, fmt.Errorf("invalid proxy URL %q", redactURL(proxy.URL))
+		}
+
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypassProxy(req.URL.Hostname(), proxy.NoProxy) {
+				return nil, nil
+			}
+			return uri, nil
+		}
+	}
+
+	return transport, nil
+}
+
+func (o *TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != util.EmptyString {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("the CA bundle %v does not contain any PEM certificates", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != util.EmptyString || o.KeyFile != util.EmptyString {
+		if o.CertFile == util.EmptyString || o.KeyFile == util.EmptyString {
+			return nil, errors.New("mutual TLS requires both a client certificate and a private key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.InsecureSkipVerify {
+		slog.Warn("Datahub certificate verification is disabled")
+	}
+
+	return config, nil
+}
+
+func bypassProxy(host string, noproxy []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range noproxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == util.EmptyString {
+			continue
+		}
+
+		if entry == "*" || host == strings.TrimPrefix(entry, ".") {
+			return true
+		}
+
+		if strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckConnection verifies the Datahub is reachable, which includes the TLS
+// handshake and proxy. Any HTTP response counts as a success; certificate
+// problems are reported with an explanation of how to fix them.
+func (dh *Datahub) CheckConnection() error {
+	req, err := http.NewRequest("GET", dh.root, nil)
+	if err != nil {
+		return err
+	}
+
+	client := dh.client()
+	client.Timeout = 30 * time.Second
+
+	res, err := client.Do(req)
+	if err != nil {
+		return connectionError(err)
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// connectionError explains TLS and proxy failures. Other errors are returned
+// unchanged.
+func connectionError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var unknown x509.UnknownAuthorityError
+	if errors.As(err, &unknown) {
+		issuer := util.EmptyString
+		if unknown.Cert != nil {
+			issuer = " (issued by \"" + unknown.Cert.Issuer.String() + "\")"
+		}
+		return fmt.Errorf("the Datahub certificate is signed by an unknown authority%v; add the CA to the datahub_tls ca_file bundle: %w", issuer, err)
+	}
+
+	var hostname x509.HostnameError
+	if errors.As(err, &hostname) {
+		valid := util.EmptyString
+		if hostname.Certificate != nil && len(hostname.Certificate.DNSNames) > 0 {
+			valid = " (valid for " + strings.Join(hostname.Certificate.DNSNames, ", ") + ")"
+		}
+		return fmt.Errorf("the Datahub certificate does not match host %v%v; correct the URL or set the datahub_tls server_name: %w", hostname.Host, valid, err)
+	}
+
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) {
+		if invalid.Reason == x509.Expired && invalid.Cert != nil {
+			return fmt.Errorf("the Datahub certificate is expired or not yet valid (valid %v to %v): %w", invalid.Cert.NotBefore.Format(time.RFC3339), invalid.Cert.NotAfter.Format(time.RFC3339), err)
+		}
+		return fmt.Errorf("the Datahub certificate is invalid: %w", err)
+	}
+
+	var header tls.RecordHeaderError
+	if errors.As(err, &header) {
+		return fmt.Errorf("the Datahub did not respond with TLS; check whether the URL should use http:// instead of https://: %w", err)
+	}
+
+	msg := err.Error()
+	if strings.Contains(msg, "tls: certificate required") || strings.Contains(msg, "tls: bad certificate") || strings.Contains(msg, "tls: unknown certificate authority") {
+		return fmt.Errorf("the Datahub rejected the client certificate; check the datahub_tls cert_file and key_file: %w", err)
+	}
+
+	var uerr *url.Error
+	var operr *net.OpError
+	if errors.As(err, &uerr) && errors.As(err, &operr) && operr.Op == "proxyconnect" {
+		return fmt.Errorf("cannot connect to the proxy: %w", err)
+	}
+
+	return err
+}
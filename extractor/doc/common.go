@@ -53,3 +53,30 @@ func (k *Key) IsPrimary() bool {
 
 	return strings.Contains(strings.ToLower(k.Type), "primary")
 }
+
+// partial reduces a request body to the fields that changed. The fields that
+// identify the object are always kept. When no fields are known to have
+// changed, the whole body is returned.
+func partial(body map[string]interface{}, fields []string, identifiers ...string) map[string]interface{} {
+	if len(fields) == 0 {
+		return body
+	}
+
+	data := make(map[string]interface{})
+	for _, field := range append(identifiers, fields...) {
+		if value, exists := body[field]; exists {
+			data[field] = value
+		}
+	}
+
+	return data
+}
+
+// addField records a changed field once.
+func addField(fields []string, field string) []string {
+	if util.InSlice[string](field, fields) {
+		return fields
+	}
+
+	return append(fields, field)
+}
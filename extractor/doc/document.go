@@ -51,6 +51,66 @@ func (d *Doc) GetSchema(name string) (*Schema, error) {
 	return &Schema{Name: Name{Physical: name}}, errors.New(name + " schema does not exist")
 }
 
+// RenameSchema renames a schema, along with the FQDNs of its sets and items,
+// and adds it to the document when it was removed. A schema renamed after an
+// existing schema is merged into it, as long as their sets and relationships
+// have different names.
+func (d *Doc) RenameSchema(schema *Schema, name string) error {
+	target, err := d.GetSchema(name)
+	if err != nil || target == schema {
+		d.RemoveSchema(schema)
+		schema.Name.Physical = name
+		d.schemas[schema.ID()] = schema
+		for _, set := range schema.Sets {
+			set.rehome(schema)
+		}
+
+		return nil
+	}
+
+	for id, set := range schema.Sets {
+		if _, exists := target.Sets[id]; exists {
+			return errors.New("cannot merge the " + schema.Name.Physical + " schema into " + target.Name.Physical + ": both have the " + set.Name.Physical + " set")
+		}
+	}
+
+	for id := range schema.Relationships {
+		if _, exists := target.Relationships[id]; exists {
+			return errors.New("cannot merge the " + schema.Name.Physical + " schema into " + target.Name.Physical + ": both have the " + id + " relationship")
+		}
+	}
+
+	if target.Sets == nil {
+		target.Sets = make(map[string]*Set)
+	}
+	for id, set := range schema.Sets {
+		target.Sets[id] = set
+		set.rehome(target)
+	}
+
+	if len(schema.Relationships) > 0 && target.Relationships == nil {
+		target.Relationships = make(map[string]*Relationship)
+	}
+	for id, rel := range schema.Relationships {
+		target.Relationships[id] = rel
+	}
+
+	if len(strings.TrimSpace(target.Comment)) == 0 {
+		target.Comment = schema.Comment
+	}
+
+	d.RemoveSchema(schema)
+
+	return nil
+}
+
+// RemoveSchema removes a schema from the document.
+func (d *Doc) RemoveSchema(schema *Schema) {
+	if d.schemas[schema.ID()] == schema {
+		delete(d.schemas, schema.ID())
+	}
+}
+
 func (d *Doc) GetSchemas() []*Schema {
 	result := make([]*Schema, 0)
 	for _, s := range d.schemas {
@@ -71,6 +131,14 @@ func (d *Doc) ToJSON(minimize ...bool) []byte {
 		data["comment"] = d.source.Comment
 	}
 
+	if d.source.Type != util.EmptyString {
+		data["type"] = d.source.Type
+	}
+
+	if len(d.source.Metadata) > 0 {
+		data["metadata"] = d.source.Metadata
+	}
+
 	if len(minimize) > 0 && minimize[0] == true {
 		j, _ = json.Marshal(data)
 	} else {
@@ -80,6 +148,92 @@ func (d *Doc) ToJSON(minimize ...bool) []byte {
 	return j
 }
 
+type jsonSchema struct {
+	Name          Name                     `json:"name"`
+	Comment       string                   `json:"comment"`
+	Metadata      map[string]interface{}   `json:"metadata"`
+	Sets          map[string]*Set          `json:"sets"`
+	Relationships map[string]*Relationship `json:"relationships"`
+}
+
+type jsonDoc struct {
+	Name     Name                   `json:"name"`
+	Comment  string                 `json:"comment"`
+	Type     string                 `json:"type"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Schemas  map[string]*jsonSchema `json:"schemas"`
+}
+
+// FromJSON loads a document produced by ToJSON, restoring the links between
+// schemas, sets, items and relationships.
+func FromJSON(data []byte) (*Doc, error) {
+	var raw jsonDoc
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.New("invalid document: " + err.Error())
+	}
+
+	d := New(&Source{
+		Name:     raw.Name,
+		Comment:  raw.Comment,
+		Type:     raw.Type,
+		Metadata: raw.Metadata,
+	})
+
+	for _, s := range raw.Schemas {
+		if s == nil {
+			continue
+		}
+
+		schema := d.ApplySchema(&Schema{
+			Name:          s.Name,
+			Comment:       s.Comment,
+			Metadata:      s.Metadata,
+			Sets:          make(map[string]*Set),
+			Relationships: make(map[string]*Relationship),
+		})
+
+		for _, set := range s.Sets {
+			if set == nil {
+				continue
+			}
+
+			items := set.Items
+			set.Items = make(map[string]*Item)
+			set.Relationships = make([]string, 0)
+			set = schema.UpsertSet(set)
+
+			for _, item := range items {
+				if item != nil {
+					set.UpsertItem(item)
+				}
+			}
+		}
+
+		for _, rel := range s.Relationships {
+			if rel == nil || len(rel.Items) == 0 || rel.Items[0].Parent == nil {
+				continue
+			}
+
+			set, err := schema.GetSet(rel.Items[0].Parent.Set)
+			if err != nil {
+				return d, errors.New("the " + rel.Name.Physical + " relationship references a set that does not exist: " + err.Error())
+			}
+
+			joins := rel.Items
+			rel.Items = make([]*Join, 0)
+			rel = set.UpsertRelationship(rel)
+
+			for _, join := range joins {
+				if join != nil && join.Parent != nil && join.Child != nil {
+					rel.UpsertJoin(join)
+				}
+			}
+		}
+	}
+
+	return d, nil
+}
+
 func (d *Doc) GetViews(schemaName string) ([]*Set, error) {
 	if schema, exists := d.schemas[schemaName]; exists {
 		return schema.GetViews(), nil
@@ -2,7 +2,6 @@ package doc
 
 import (
 	"dhs/util"
-	"fmt"
 	"strconv"
 	"strings"
 )
@@ -20,6 +19,7 @@ type Item struct {
 	Example      string                 `json:"example,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
 	Keys         map[string]*Key        `json:"keys,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
 	set          *Set                   `json:"-"`
 	UpdateFields []string               `json:"-"`
 }
@@ -42,9 +42,6 @@ func (i *Item) ToPostBody() map[string]interface{} {
 		data["udt_type"] = i.Type
 	}
 
-	if i.Set().Name.Physical == "user_group" && i.Name.Physical == "seqid" {
-		fmt.Printf("==> %v | %v > %v\n", (i.Default != util.EmptyString), i.Default, util.EmptyString)
-	}
 	if i.Default != util.EmptyString {
 		data["default"] = i.Default
 	}
@@ -67,6 +64,14 @@ func (i *Item) ToPostBody() map[string]interface{} {
 		}
 	}
 
+	if len(i.Attributes) > 0 {
+		attributes := make(map[string]interface{})
+		for key, value := range i.Attributes {
+			attributes[key] = value
+		}
+		data["attributes"] = attributes
+	}
+
 	if i.Metadata != nil {
 		data["metadata"] = i.Metadata
 		if i.Metadata["most_common_value"] != nil {
@@ -98,6 +103,18 @@ func (i *Item) ToPostBody() map[string]interface{} {
 	return data
 }
 
+// ToPatchBody is the request body for a partial update, containing only the
+// fields listed in UpdateFields.
+func (i *Item) ToPatchBody() map[string]interface{} {
+	return partial(i.ToPostBody(), i.UpdateFields, "name")
+}
+
+// Changed records a field (by request body name) that differs from the
+// Datahub.
+func (i *Item) Changed(field string) {
+	i.UpdateFields = addField(i.UpdateFields, field)
+}
+
 func (i *Item) ID() string {
 	return strings.ToLower(strings.TrimSpace(i.FQDN))
 }
@@ -127,6 +144,7 @@ func (i *Item) UpsertKey(key *Key) *Key {
 		if i.Keys == nil {
 			i.Keys = make(map[string]*Key)
 		}
+		k = key
 		i.Keys[strings.ToLower(key.Name)] = k
 	}
 
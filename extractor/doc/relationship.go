@@ -54,13 +54,18 @@ func (j *Join) ID() string {
 }
 
 type Relationship struct {
-	Id        string                `json:"-"`
-	Name      Name                  `json:"name"`
-	Type      string                `json:"type"`
-	Comment   string                `json:"comment"`
-	Items     []*Join               `json:"items"`
-	Integrity *ReferentialIntegrity `json:"referential_integrity"`
-	Set       *Set                  `json:"-"`
+	Id         string                 `json:"-"`
+	Name       Name                   `json:"name"`
+	Type       string                 `json:"type"`
+	Comment    string                 `json:"comment"`
+	Items      []*Join                `json:"items"`
+	Integrity  *ReferentialIntegrity  `json:"referential_integrity"`
+	Set        *Set                   `json:"-"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// UpdateFields lists the fields (by request body name) that differ from
+	// the Datahub.
+	UpdateFields []string `json:"-"`
 }
 
 func (r *Relationship) ID() string {
@@ -207,10 +212,22 @@ func (r *Relationship) ToPostBody() map[string]interface{} {
 		result["name"].(map[string]interface{})["logical"] = r.Name.Logical
 	}
 
+	if r.Comment != util.EmptyString {
+		result["description"] = r.Comment
+	}
+
 	if r.Integrity.Match != util.EmptyString {
 		result["match_type"] = strings.ToUpper(r.Integrity.Match)
 	}
 
+	if len(r.Metadata) > 0 {
+		result["metadata"] = r.Metadata
+	}
+
+	if len(r.Attributes) > 0 {
+		result["attributes"] = r.Attributes
+	}
+
 	for i, join := range r.Items {
 		result["items"].([]map[string]interface{})[i] = map[string]interface{}{
 			"parent": join.Parent.FQDN,
@@ -220,3 +237,25 @@ func (r *Relationship) ToPostBody() map[string]interface{} {
 
 	return result
 }
+
+// ToPatchBody is the request body for a partial update, containing only the
+// fields listed in UpdateFields. The relationship is identified by its
+// Datahub ID.
+func (r *Relationship) ToPatchBody() map[string]interface{} {
+	data := r.ToPostBody()
+	if data == nil {
+		return data
+	}
+
+	if len(r.UpdateFields) > 0 {
+		data["id"] = r.Id
+	}
+
+	return partial(data, r.UpdateFields, "id", "name")
+}
+
+// Changed records a field (by request body name) that differs from the
+// Datahub.
+func (r *Relationship) Changed(field string) {
+	r.UpdateFields = addField(r.UpdateFields, field)
+}
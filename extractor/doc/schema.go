@@ -2,7 +2,7 @@ package doc
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"strings"
 )
 
@@ -61,6 +61,18 @@ func (s *Schema) UpsertSet(set *Set) *Set {
 	return currSet
 }
 
+// PrefixSets prefixes the names of the sets of the schema, along with their
+// FQDNs and the FQDNs of their items.
+func (s *Schema) PrefixSets(prefix string) {
+	sets := make(map[string]*Set)
+	for _, set := range s.Sets {
+		set.Name.Physical = prefix + set.Name.Physical
+		sets[set.ID()] = set
+		set.rehome(s)
+	}
+	s.Sets = sets
+}
+
 func (s *Schema) GetViews() []*Set {
 	sets := make([]*Set, 0)
 
@@ -105,7 +117,7 @@ func (s *Schema) UpsertRelationship(rel *Relationship) *Relationship {
 	for _, join := range rel.Items {
 		set, err := join.Child.GetSet(s)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("failed to link the relationship to its child set", "relationship", rel.ID(), "error", err)
 		} else {
 			set.LinkRelationship(rel.ID())
 		}
@@ -19,6 +19,10 @@ type Set struct {
 	Source        string                 `json:"view_source,omitempty"`
 	FQDN          string                 `json:"fqdn"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	UpdateFields  []string               `json:"-"`
+	// Partition is set for the partition (and inheritance) children.
+	Partition bool `json:"partition,omitempty"`
 }
 
 func (set *Set) ToPostBody() map[string]interface{} {
@@ -38,13 +42,22 @@ func (set *Set) ToPostBody() map[string]interface{} {
 		set.Metadata["view_source"] = set.Source
 	}
 
+	if len(set.Attributes) > 0 {
+		attributes := make(map[string]interface{})
+		for key, value := range set.Attributes {
+			attributes[key] = value
+		}
+		data["attributes"] = attributes
+	}
+
 	if set.Metadata != nil {
 		data["metadata"] = set.Metadata
 
 		if val, exists := set.Metadata["most_common_value"]; exists {
-			data["attributes"] = map[string]interface{}{
-				"Most Common Value": val,
+			if data["attributes"] == nil {
+				data["attributes"] = make(map[string]interface{})
 			}
+			data["attributes"].(map[string]interface{})["Most Common Value"] = val
 			delete(data["metadata"].(map[string]interface{}), "most_common_value")
 		}
 	}
@@ -52,12 +65,61 @@ func (set *Set) ToPostBody() map[string]interface{} {
 	return data
 }
 
+// ToPatchBody is the request body for a partial update, containing only the
+// fields listed in UpdateFields.
+func (set *Set) ToPatchBody() map[string]interface{} {
+	data := set.ToPostBody()
+	if set.Source != util.EmptyString {
+		data["definition"] = set.Source
+	}
+
+	return partial(data, set.UpdateFields, "name")
+}
+
+// Changed records a field (by request body name) that differs from the
+// Datahub.
+func (set *Set) Changed(field string) {
+	set.UpdateFields = addField(set.UpdateFields, field)
+}
+
 func (set *Set) setParent(schema *Schema) {
 	set.schema = schema
 	set.Schema = schema.Name.Physical
 	set.FQDN = schema.Name.Physical + "." + set.Name.Physical
 }
 
+// rehome moves the set to a schema, and rebases the FQDNs of its items (and
+// their keys) on the new FQDN of the set.
+func (set *Set) rehome(schema *Schema) {
+	old := set.FQDN
+	set.setParent(schema)
+
+	keys := func(list []*Key) {
+		for _, key := range list {
+			for i, fqdn := range key.Items {
+				key.Items[i] = rebase(fqdn, old, set.FQDN)
+			}
+		}
+	}
+
+	for _, item := range set.Items {
+		item.FQDN = rebase(item.FQDN, old, set.FQDN)
+		for _, key := range item.Keys {
+			keys([]*Key{key})
+		}
+	}
+	keys(set.Keys)
+}
+
+// rebase replaces the set FQDN at the start of an item FQDN.
+func rebase(fqdn string, old string, set string) string {
+	if len(fqdn) > len(old) && strings.EqualFold(fqdn[:len(old)+1], old+".") {
+		return set + fqdn[len(old):]
+	}
+
+	return fqdn
+}
+
 func (set *Set) GetSchemaObject() *Schema {
 	return set.schema
 }
@@ -120,7 +182,7 @@ func (set *Set) GetItem(name string) (*Item, error) {
 
 func (set *Set) UpsertItem(item *Item) *Item {
 	id := strings.ToLower(item.Name.Physical)
-	currItem, err := set.GetItem(set.Name.Physical)
+	currItem, err := set.GetItem(item.Name.Physical)
 	if err != nil {
 		if set.Items == nil {
 			set.Items = make(map[string]*Item)
@@ -1,8 +1,36 @@
 package doc
 
+import "dhs/util"
+
 type Source struct {
 	Name     Name   `json:"name"`
 	Comment  string `json:"comment"`
+	Type     string `json:"type,omitempty"`
 	schemas  map[string]*Schema
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
+
+func (src *Source) ToPostBody() map[string]interface{} {
+	data := map[string]interface{}{
+		"name": src.Name,
+	}
+
+	if src.Comment != util.EmptyString {
+		data["description"] = src.Comment
+	}
+
+	metadata := make(map[string]interface{})
+	for key, value := range src.Metadata {
+		metadata[key] = value
+	}
+
+	if src.Type != util.EmptyString {
+		metadata["extractor"] = src.Type
+	}
+
+	if len(metadata) > 0 {
+		data["metadata"] = metadata
+	}
+
+	return data
+}
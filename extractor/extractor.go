@@ -1,15 +1,20 @@
 package extractor
 
-import "dhs/extractor/doc"
+import (
+	"context"
+	"dhs/extractor/doc"
+	"log/slog"
+)
 
 type Extractor interface {
 	SetConnectionString(str string) error
-	Extract(...string) (*doc.Doc, error)
-	ExtractRelationships(...string) (map[string]interface{}, error)
+	Extract(context.Context, ...string) (*doc.Doc, error)
+	ExtractRelationships(context.Context, ...string) (map[string]interface{}, error)
 	Type() string
 	// Query(statement string) ([]map[string]interface{}, error)
-	ExpandJSONFields(*doc.Doc, bool, ...string)
+	ExpandJSONFields(context.Context, *doc.Doc, bool, ...string)
 	SetDebugging(bool)
+	SetLogger(*slog.Logger)
 	ApplySchemas(...string)
 }
 
@@ -0,0 +1,210 @@
+package extractor
+
+import (
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter leaves sets and items out of a sync by name. A pattern is a glob
+// (tmp_*, *_bak) or, between slashes, a regular expression (/^stg_\d+$/), and
+// is case-insensitive. Set patterns match the name of the set. Item patterns
+// match the name of the item, or set.item when they contain a dot (an escaped
+// dot, \., in a regular expression).
+//
+// When includes are set, only the matching sets (or items) are synced;
+// excludes then remove sets (or items) from the result. Partition (and
+// inheritance) children are excluded with ExcludePartitions.
+//
+// The filter is applied to both the source and the Datahub metadata, so the
+// excluded objects are neither pushed nor deleted, and the relationships
+// joining them are ignored.
+type Filter struct {
+	IncludeSets       []string
+	ExcludeSets       []string
+	IncludeItems      []string
+	ExcludeItems      []string
+	ExcludePartitions bool
+
+	includeSets  []*pattern
+	excludeSets  []*pattern
+	includeItems []*pattern
+	excludeItems []*pattern
+	// partitions lists the partition children found in the source, which
+	// the Datahub cannot tell apart.
+	partitions map[string]bool
+}
+
+type pattern struct {
+	*regexp.Regexp
+	// qualified patterns match set.item.
+	qualified bool
+}
+
+// Compile validates the patterns of the filter. It must be called before the
+// filter is used.
+func (f *Filter) Compile() error {
+	for _, rules := range []struct {
+		patterns []string
+		compiled *[]*pattern
+	}{
+		{f.IncludeSets, &f.includeSets},
+		{f.ExcludeSets, &f.excludeSets},
+		{f.IncludeItems, &f.includeItems},
+		{f.ExcludeItems, &f.excludeItems},
+	} {
+		*rules.compiled = nil
+		for _, value := range rules.patterns {
+			p, err := compilePattern(value)
+			if err != nil {
+				return err
+			}
+			*rules.compiled = append(*rules.compiled, p)
+		}
+	}
+
+	return nil
+}
+
+func compilePattern(value string) (*pattern, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		re, err := regexp.Compile("(?i)" + value[1:len(value)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %v: %w", value, err)
+		}
+
+		return &pattern{Regexp: re, qualified: strings.Contains(value, `\.`)}, nil
+	}
+
+	if value == util.EmptyString {
+		return nil, errors.New("invalid filter: empty pattern")
+	}
+
+	if _, err := path.Match(value, util.EmptyString); err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", value, err)
+	}
+
+	// Translate the glob, so the patterns are matched the same way.
+	expr := strings.Builder{}
+	expr.WriteString("(?i)^")
+	for _, r := range value {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return &pattern{Regexp: regexp.MustCompile(expr.String()), qualified: strings.Contains(value, ".")}, nil
+}
+
+// Empty determines whether the filter keeps everything.
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.IncludeSets) == 0 && len(f.ExcludeSets) == 0 && len(f.IncludeItems) == 0 && len(f.ExcludeItems) == 0 && !f.ExcludePartitions)
+}
+
+// Set determines whether a set is synced.
+func (f *Filter) Set(name string) bool {
+	if f.Empty() {
+		return true
+	}
+
+	if f.partitions[strings.ToLower(name)] {
+		return false
+	}
+
+	return keep(name, name, f.includeSets, f.excludeSets)
+}
+
+// Item determines whether an item of a set is synced.
+func (f *Filter) Item(set string, name string) bool {
+	if f.Empty() {
+		return true
+	}
+
+	if !f.Set(set) {
+		return false
+	}
+
+	return keep(name, set+"."+name, f.includeItems, f.excludeItems)
+}
+
+func keep(name string, qualified string, include []*pattern, exclude []*pattern) bool {
+	matches := func(patterns []*pattern) bool {
+		for _, p := range patterns {
+			subject := name
+			if p.qualified {
+				subject = qualified
+			}
+
+			if p.MatchString(subject) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(include) > 0 && !matches(include) {
+		return false
+	}
+
+	return !matches(exclude)
+}
+
+// Apply removes the sets and items that are not synced from a document,
+// along with the relationships that join them. It returns the number of sets,
+// items and relationships removed. The partition children are recognized in
+// the source metadata (see doc.Set.Partition), which must be filtered first.
+func (f *Filter) Apply(d *doc.Doc) (int, int, int) {
+	if f.Empty() || d == nil {
+		return 0, 0, 0
+	}
+
+	if f.partitions == nil {
+		f.partitions = make(map[string]bool)
+	}
+
+	sets, items, rels := 0, 0, 0
+	for _, schema := range d.GetSchemas() {
+		for id, set := range schema.Sets {
+			if f.ExcludePartitions && set.Partition {
+				f.partitions[strings.ToLower(set.Name.Physical)] = true
+			}
+
+			if !f.Set(set.Name.Physical) {
+				delete(schema.Sets, id)
+				sets++
+				continue
+			}
+
+			for key, item := range set.Items {
+				if !f.Item(set.Name.Physical, item.Name.Physical) {
+					delete(set.Items, key)
+					items++
+				}
+			}
+		}
+
+		for id, rel := range schema.Relationships {
+			for _, join := range rel.Items {
+				if !f.Item(join.Parent.Set, join.Parent.Item) || !f.Item(join.Child.Set, join.Child.Item) {
+					delete(schema.Relationships, id)
+					rels++
+					break
+				}
+			}
+		}
+	}
+
+	return sets, items, rels
+}
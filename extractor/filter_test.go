@@ -0,0 +1,132 @@
+package extractor
+
+import (
+	"dhs/extractor/doc"
+	"strings"
+	"testing"
+)
+
+func TestFilterSets(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		kept    []string
+		dropped []string
+	}{
+		{"empty", Filter{}, []string{"users", "tmp_users"}, nil},
+		{"glob", Filter{ExcludeSets: []string{"tmp_*", "*_bak"}}, []string{"users", "users_bak2"}, []string{"tmp_users", "TMP_orders", "users_bak"}},
+		{"single character", Filter{ExcludeSets: []string{"log_?"}}, []string{"log_10", "log_"}, []string{"log_1", "log_a"}},
+		{"literal glob", Filter{ExcludeSets: []string{"stg.users"}}, []string{"stgxusers"}, []string{"stg.users"}},
+		{"regex", Filter{ExcludeSets: []string{`/^stg_\d+$/`}}, []string{"stg_users", "my_stg_1"}, []string{"stg_1", "STG_42"}},
+		{"unanchored regex", Filter{ExcludeSets: []string{"/audit/"}}, []string{"users"}, []string{"audit", "user_audit_log"}},
+		{"include", Filter{IncludeSets: []string{"users", "orders"}}, []string{"users", "Orders"}, []string{"invoices"}},
+		{"exclude wins", Filter{IncludeSets: []string{"user*"}, ExcludeSets: []string{"*_bak"}}, []string{"users"}, []string{"users_bak", "orders"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.filter.Compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range test.kept {
+				if !test.filter.Set(name) {
+					t.Errorf("%v was left out", name)
+				}
+			}
+
+			for _, name := range test.dropped {
+				if test.filter.Set(name) {
+					t.Errorf("%v was synced", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterItems(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		kept    []string
+		dropped []string
+	}{
+		{"name", Filter{ExcludeItems: []string{"password*"}}, []string{"users.email"}, []string{"users.password", "orders.password_hash"}},
+		{"qualified glob", Filter{ExcludeItems: []string{"users.password"}}, []string{"admins.password"}, []string{"users.password"}},
+		{"qualified regex", Filter{ExcludeItems: []string{`/^users\.pass/`}}, []string{"admins.password"}, []string{"users.password"}},
+		{"unqualified regex", Filter{ExcludeItems: []string{"/^pass/"}}, []string{"users.email"}, []string{"users.password", "admins.passcode"}},
+		{"include", Filter{IncludeItems: []string{"id", "*_id"}}, []string{"users.id", "orders.user_id"}, []string{"users.email"}},
+		{"exclude wins", Filter{IncludeItems: []string{"*_id"}, ExcludeItems: []string{"orders.user_id"}}, []string{"notes.user_id"}, []string{"orders.user_id"}},
+		{"excluded set", Filter{ExcludeSets: []string{"users"}, IncludeItems: []string{"id"}}, []string{"orders.id"}, []string{"users.id"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.filter.Compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range test.kept {
+				set, item, _ := strings.Cut(name, ".")
+				if !test.filter.Item(set, item) {
+					t.Errorf("%v was left out", name)
+				}
+			}
+
+			for _, name := range test.dropped {
+				set, item, _ := strings.Cut(name, ".")
+				if test.filter.Item(set, item) {
+					t.Errorf("%v was synced", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterRejectsInvalidPatterns(t *testing.T) {
+	for _, value := range []string{"", "  ", "[users", "/(users/"} {
+		f := Filter{ExcludeSets: []string{value}}
+		if err := f.Compile(); err == nil {
+			t.Errorf("%q compiled", value)
+		}
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	d := doc.New(&doc.Source{Name: doc.Name{Physical: "db"}})
+	schema := d.ApplySchema(&doc.Schema{Name: doc.Name{Physical: "public"}, Sets: map[string]*doc.Set{}})
+	for _, set := range []*doc.Set{
+		{Name: doc.Name{Physical: "users"}, Items: map[string]*doc.Item{}},
+		{Name: doc.Name{Physical: "orders"}, Items: map[string]*doc.Item{}},
+		{Name: doc.Name{Physical: "orders_2024"}, Items: map[string]*doc.Item{}, Partition: true},
+		{Name: doc.Name{Physical: "tmp_orders"}, Items: map[string]*doc.Item{}},
+	} {
+		set = schema.UpsertSet(set)
+		for _, name := range []string{"id", "user_id", "secret"} {
+			set.UpsertItem(&doc.Item{Name: doc.Name{Physical: name}})
+		}
+	}
+	schema.Relationships = map[string]*doc.Relationship{
+		"orders_user_fk": {Name: doc.Name{Physical: "orders_user_fk"}, Items: []*doc.Join{{Parent: &doc.RelItem{Set: "users", Item: "id"}, Child: &doc.RelItem{Set: "orders", Item: "user_id"}}}},
+		"tmp_user_fk":    {Name: doc.Name{Physical: "tmp_user_fk"}, Items: []*doc.Join{{Parent: &doc.RelItem{Set: "users", Item: "id"}, Child: &doc.RelItem{Set: "tmp_orders", Item: "user_id"}}}},
+	}
+
+	f := &Filter{ExcludeSets: []string{"tmp_*"}, ExcludeItems: []string{"secret"}, ExcludePartitions: true}
+	if err := f.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, items, rels := f.Apply(d)
+	if sets != 2 || items != 2 || rels != 1 {
+		t.Errorf("removed %v set(s), %v item(s) and %v relationship(s), want 2, 2 and 1", sets, items, rels)
+	}
+
+	if len(schema.Sets) != 2 || len(schema.Relationships) != 1 {
+		t.Errorf("kept %v set(s) and %v relationship(s), want 2 and 1", len(schema.Sets), len(schema.Relationships))
+	}
+
+	// The partition children are recognized in the Datahub metadata too.
+	if f.Set("orders_2024") {
+		t.Error("the partition child was synced")
+	}
+}
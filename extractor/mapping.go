@@ -0,0 +1,244 @@
+package extractor
+
+import (
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Mapping decouples the structure of the Datahub catalog from the database:
+// rules rename (alias) the schemas, prefix the names of their sets and send
+// them to other Datahub data sources. A rule matches the name of a schema with
+// a glob or a /regular expression/ (see Filter), and the first matching rule
+// applies.
+//
+// The prefix may refer to the (aliased) schema name with {schema}, i.e.
+// "{schema}_" names the orders set of the sales schema sales_orders. Schemas
+// without a rule keep their name and use the default prefix.
+//
+// Since the Datahub data source is flat, the sets of the schemas synced into
+// the same data source must have different names.
+type Mapping struct {
+	// SetPrefix is the default prefix of the set names.
+	SetPrefix string
+	Rules     []*MappingRule
+	// Target is the Datahub data source being synced: the schemas sent to
+	// other data sources are left out. Empty is the default data source.
+	Target string
+}
+
+// MappingRule maps the schemas matching a pattern.
+type MappingRule struct {
+	Schema string
+	// Name is the alias of the schema: by default, the name of the schema,
+	// or the Datahub data source the schema is sent to, since the Datahub
+	// identifies the sets of a data source by source.set.
+	Name string
+	// SetPrefix overrides the default prefix of the set names.
+	SetPrefix string
+	// Source is the Datahub data source of the schema (the default data
+	// source when empty).
+	Source string
+
+	pattern *pattern
+}
+
+// Compile validates the rules of the mapping. It must be called before the
+// mapping is used.
+func (m *Mapping) Compile() error {
+	if strings.Contains(m.SetPrefix, ".") {
+		return fmt.Errorf("invalid set prefix %q: set names cannot contain dots", m.SetPrefix)
+	}
+
+	for _, rule := range m.Rules {
+		if strings.TrimSpace(rule.Schema) == util.EmptyString {
+			return errors.New("invalid schema mapping: the schema is required")
+		}
+
+		p, err := compilePattern(rule.Schema)
+		if err != nil {
+			return fmt.Errorf("invalid schema mapping %v: %w", rule.Schema, err)
+		}
+		rule.pattern = p
+
+		if strings.Contains(rule.Name, ".") {
+			return fmt.Errorf("invalid schema mapping %v: the %q alias cannot contain dots", rule.Schema, rule.Name)
+		}
+
+		if strings.Contains(rule.SetPrefix, ".") {
+			return fmt.Errorf("invalid schema mapping %v: set names cannot contain dots (prefix %q)", rule.Schema, rule.SetPrefix)
+		}
+	}
+
+	return nil
+}
+
+// Empty determines whether the mapping keeps the database structure.
+func (m *Mapping) Empty() bool {
+	return m == nil || (m.SetPrefix == util.EmptyString && len(m.Rules) == 0)
+}
+
+// Sources lists the other Datahub data sources the schemas are sent to.
+func (m *Mapping) Sources() []string {
+	sources := make([]string, 0)
+	if m == nil {
+		return sources
+	}
+
+	for _, rule := range m.Rules {
+		if rule.Source != util.EmptyString && !util.InSlice[string](rule.Source, sources) {
+			sources = append(sources, rule.Source)
+		}
+	}
+
+	return sources
+}
+
+// Schema returns the alias, the set prefix and the Datahub data source (empty
+// for the default one) of a schema.
+func (m *Mapping) Schema(name string) (string, string, string) {
+	alias, prefix, source := name, util.EmptyString, util.EmptyString
+	if m == nil {
+		return alias, prefix, source
+	}
+
+	prefix = m.SetPrefix
+	for _, rule := range m.Rules {
+		if rule.pattern == nil || !rule.pattern.MatchString(name) {
+			continue
+		}
+
+		if rule.Name != util.EmptyString {
+			alias = rule.Name
+		} else if rule.Source != util.EmptyString {
+			alias = rule.Source
+		}
+		if rule.SetPrefix != util.EmptyString {
+			prefix = rule.SetPrefix
+		}
+		source = rule.Source
+		break
+	}
+
+	return alias, strings.ReplaceAll(prefix, "{schema}", alias), source
+}
+
+// Split moves the schemas sent to other data sources out of a document, into
+// a document for each of Sources(), so the data sources share a single
+// extraction. Each document is then mapped with the Target of its data
+// source.
+func (m *Mapping) Split(d *doc.Doc) map[string]*doc.Doc {
+	routes := make(map[string]*doc.Doc)
+	if m.Empty() || d == nil {
+		return routes
+	}
+
+	for _, source := range m.Sources() {
+		routes[source] = doc.New(d.Source())
+	}
+
+	for _, schema := range d.GetSchemas() {
+		_, _, source := m.Schema(schema.Name.Physical)
+		if strings.EqualFold(source, m.Target) {
+			continue
+		}
+
+		d.RemoveSchema(schema)
+		if route, exists := routes[source]; exists {
+			route.ApplySchema(schema)
+		}
+	}
+
+	return routes
+}
+
+// Apply maps the schemas of a document: it leaves out the schemas sent to
+// other data sources, renames the schemas and prefixes the sets, and updates
+// the FQDNs of the sets, items and relationship joins. It returns the number
+// of schemas and relationships left out; relationships are left out when
+// they join schemas of different data sources.
+func (m *Mapping) Apply(d *doc.Doc) (int, int, error) {
+	if m.Empty() || d == nil {
+		return 0, 0, nil
+	}
+
+	// The sets are indexed by their database FQDN (schema.set) to update the
+	// joins.
+	sets := make(map[string]*doc.Set)
+	removed := make(map[string]bool)
+	aliases := make(map[*doc.Schema]string)
+	for _, schema := range d.GetSchemas() {
+		alias, prefix, source := m.Schema(schema.Name.Physical)
+		if !strings.EqualFold(source, m.Target) {
+			removed[strings.ToLower(schema.Name.Physical)] = true
+			d.RemoveSchema(schema)
+			continue
+		}
+
+		for _, set := range schema.Sets {
+			sets[strings.ToLower(schema.Name.Physical+"."+set.Name.Physical)] = set
+		}
+
+		if prefix != util.EmptyString {
+			schema.PrefixSets(prefix)
+		}
+
+		if alias != schema.Name.Physical {
+			aliases[schema] = alias
+		}
+	}
+
+	// The renamed schemas are taken out first, so a schema can take the name
+	// of another renamed schema.
+	for schema := range aliases {
+		d.RemoveSchema(schema)
+	}
+	for schema, alias := range aliases {
+		if err := d.RenameSchema(schema, alias); err != nil {
+			return len(removed), 0, err
+		}
+	}
+
+	rels := 0
+	for _, schema := range d.GetSchemas() {
+		for id, rel := range schema.Relationships {
+			if m.joins(rel) {
+				delete(schema.Relationships, id)
+				rels++
+				continue
+			}
+
+			for _, join := range rel.Items {
+				for _, end := range []*doc.RelItem{join.Parent, join.Child} {
+					if set, exists := sets[strings.ToLower(end.Schema+"."+end.Set)]; exists {
+						end.Schema = set.Schema
+						end.Set = set.Name.Physical
+						end.FQDN = set.FQDN + "." + end.Item
+					}
+				}
+			}
+		}
+	}
+
+	return len(removed), rels, nil
+}
+
+// joins determines whether a relationship joins a schema sent to another data
+// source. The schema may not be in the document (see Split).
+func (m *Mapping) joins(rel *doc.Relationship) bool {
+	for _, join := range rel.Items {
+		for _, end := range []*doc.RelItem{join.Parent, join.Child} {
+			if end == nil || end.Schema == util.EmptyString {
+				continue
+			}
+
+			if _, _, source := m.Schema(end.Schema); !strings.EqualFold(source, m.Target) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
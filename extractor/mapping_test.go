@@ -0,0 +1,218 @@
+package extractor
+
+import (
+	"dhs/extractor/doc"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mapped compiles a mapping of the rules.
+func mapped(t *testing.T, prefix string, target string, rules ...*MappingRule) *Mapping {
+	t.Helper()
+
+	m := &Mapping{SetPrefix: prefix, Rules: rules, Target: target}
+	if err := m.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// database builds a document of the schemas, each with tables that have an
+// id column, and a relationship from the first table of each schema to the
+// first table of the public schema.
+func database(schemas map[string][]string) *doc.Doc {
+	d := doc.New(&doc.Source{Name: doc.Name{Physical: "db"}})
+	for name, tables := range schemas {
+		schema := d.ApplySchema(&doc.Schema{Name: doc.Name{Physical: name}, Sets: map[string]*doc.Set{}})
+		for _, table := range tables {
+			set := schema.UpsertSet(&doc.Set{Name: doc.Name{Physical: table}, FQDN: name + "." + table, Items: map[string]*doc.Item{}})
+			set.UpsertItem(&doc.Item{Name: doc.Name{Physical: "id"}, FQDN: name + "." + table + ".id"})
+		}
+	}
+
+	for name, tables := range schemas {
+		if name == "public" || len(tables) == 0 || len(schemas["public"]) == 0 {
+			continue
+		}
+
+		schema, _ := d.GetSchema(name)
+		parent := schemas["public"][0]
+		schema.Relationships = map[string]*doc.Relationship{
+			name + "_fk": {Name: doc.Name{Physical: name + "_fk"}, Items: []*doc.Join{{
+				Parent: &doc.RelItem{Schema: name, Set: tables[0], Item: "id", FQDN: name + "." + tables[0] + ".id"},
+				Child:  &doc.RelItem{Schema: "public", Set: parent, Item: "id", FQDN: "public." + parent + ".id"},
+			}}},
+		}
+	}
+
+	return d
+}
+
+// names lists the schema.set names of a document.
+func names(d *doc.Doc) string {
+	list := make([]string, 0)
+	for _, schema := range d.GetSchemas() {
+		for _, set := range schema.Sets {
+			list = append(list, schema.Name.Physical+"."+set.Name.Physical)
+		}
+	}
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
+
+func TestMappingSchema(t *testing.T) {
+	m := mapped(t, "db_", "",
+		&MappingRule{Schema: "analytics_v2", Name: "analytics", SetPrefix: "{schema}_"},
+		&MappingRule{Schema: `/^audit_\d+$/`, Source: "audit"},
+		&MappingRule{Schema: "hr", Name: "people", Source: "staff", SetPrefix: "hr_"},
+		&MappingRule{Schema: "*", SetPrefix: "ignored_"},
+	)
+
+	tests := []struct {
+		schema string
+		alias  string
+		prefix string
+		source string
+	}{
+		{"analytics_v2", "analytics", "analytics_", ""},
+		{"ANALYTICS_V2", "analytics", "analytics_", ""},
+		{"audit_2024", "audit", "db_", "audit"},
+		{"audit_old", "audit_old", "ignored_", ""},
+		{"hr", "people", "hr_", "staff"},
+		{"public", "public", "ignored_", ""},
+	}
+
+	for _, test := range tests {
+		alias, prefix, source := m.Schema(test.schema)
+		if alias != test.alias || prefix != test.prefix || source != test.source {
+			t.Errorf("%v is mapped to %v, %v, %v, want %v, %v, %v", test.schema, alias, prefix, source, test.alias, test.prefix, test.source)
+		}
+	}
+
+	var none *Mapping
+	if alias, prefix, source := none.Schema("public"); alias != "public" || prefix != "" || source != "" {
+		t.Errorf("without a mapping, public is mapped to %v, %v, %v", alias, prefix, source)
+	}
+}
+
+func TestMappingSources(t *testing.T) {
+	m := mapped(t, "", "",
+		&MappingRule{Schema: "audit_*", Source: "audit"},
+		&MappingRule{Schema: "public"},
+		&MappingRule{Schema: "hr", Source: "staff"},
+		&MappingRule{Schema: "log_*", Source: "audit"},
+	)
+
+	if got := strings.Join(m.Sources(), ","); got != "audit,staff" {
+		t.Errorf("sources = %v, want audit,staff", got)
+	}
+
+	var none *Mapping
+	if len(none.Sources()) != 0 {
+		t.Error("a missing mapping has sources")
+	}
+}
+
+func TestMappingRejectsDots(t *testing.T) {
+	for _, m := range []*Mapping{
+		{SetPrefix: "db."},
+		{Rules: []*MappingRule{{Schema: "public", Name: "my.public"}}},
+		{Rules: []*MappingRule{{Schema: "public", SetPrefix: "p."}}},
+		{Rules: []*MappingRule{{Schema: " "}}},
+	} {
+		if err := m.Compile(); err == nil {
+			t.Errorf("%+v compiled", m)
+		}
+	}
+}
+
+func TestMappingApply(t *testing.T) {
+	d := database(map[string][]string{"public": {"users"}, "analytics_v2": {"events"}, "audit_2024": {"logins"}})
+	m := mapped(t, "", "",
+		&MappingRule{Schema: "analytics_v2", Name: "analytics", SetPrefix: "{schema}_"},
+		&MappingRule{Schema: "audit_*", Source: "audit"},
+	)
+
+	schemas, rels, err := m.Apply(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemas != 1 || rels != 0 {
+		t.Errorf("left out %v schema(s) and %v relationship(s), want 1 and 0", schemas, rels)
+	}
+
+	if got := names(d); got != "analytics.analytics_events,public.users" {
+		t.Errorf("sets = %v", got)
+	}
+
+	schema, _ := d.GetSchema("analytics")
+	set, _ := schema.GetSet("analytics_events")
+	item, _ := set.GetItem("id")
+	if set.FQDN != "analytics.analytics_events" || item.FQDN != "analytics.analytics_events.id" {
+		t.Errorf("FQDNs = %v and %v", set.FQDN, item.FQDN)
+	}
+
+	join := schema.Relationships["analytics_v2_fk"].Items[0]
+	if join.Parent.Schema != "analytics" || join.Parent.Set != "analytics_events" || join.Parent.FQDN != "analytics.analytics_events.id" {
+		t.Errorf("the join parent is %+v", join.Parent)
+	}
+}
+
+func TestMappingApplyToARoute(t *testing.T) {
+	d := database(map[string][]string{"public": {"users"}, "audit_2024": {"logins"}})
+	m := mapped(t, "", "audit", &MappingRule{Schema: "audit_*", Source: "audit"})
+
+	schemas, rels, err := m.Apply(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The relationship joins the public schema of the default data source.
+	if schemas != 1 || rels != 1 {
+		t.Errorf("left out %v schema(s) and %v relationship(s), want 1 and 1", schemas, rels)
+	}
+
+	if got := names(d); got != "audit.logins" {
+		t.Errorf("sets = %v, want audit.logins", got)
+	}
+}
+
+func TestMappingSplit(t *testing.T) {
+	d := database(map[string][]string{"public": {"users"}, "audit_2024": {"logins"}, "hr": {"staff"}, "archive": {}})
+	rules := []*MappingRule{{Schema: "audit_*", Source: "audit"}, {Schema: "hr", Source: "staff"}, {Schema: "tmp", Source: "scratch"}}
+	m := mapped(t, "", "", rules...)
+
+	routes := m.Split(d)
+	if got := names(d); got != "public.users" {
+		t.Errorf("kept %v, want public.users", got)
+	}
+
+	for source, want := range map[string]string{"audit": "audit_2024.logins", "staff": "hr.staff", "scratch": ""} {
+		route, exists := routes[source]
+		if !exists {
+			t.Errorf("no document for %v", source)
+			continue
+		}
+
+		if got := names(route); got != want {
+			t.Errorf("%v sets = %v, want %v", source, got, want)
+		}
+	}
+
+	// Each document is then mapped for its data source, which leaves out
+	// the relationships to the other data sources.
+	audit := mapped(t, "", "audit", rules...)
+	schemas, rels, err := audit.Apply(routes["audit"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemas != 0 || rels != 1 {
+		t.Errorf("left out %v schema(s) and %v relationship(s), want 0 and 1", schemas, rels)
+	}
+	if got := names(routes["audit"]); got != "audit.logins" {
+		t.Errorf("audit sets = %v, want audit.logins", got)
+	}
+}
@@ -0,0 +1,128 @@
+package postgresql
+
+import (
+	"context"
+	"dhs/extractor"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+//go:embed sql/schema_privileges.sql
+var SCHEMA_PRIVILEGES_SQL string
+
+const STATS_COUNT_SQL = `SELECT count(*) FROM pg_catalog.pg_stats WHERE [SCHEMA_FILTER]`
+
+// Check verifies the connection and the privileges of the role: the catalogs
+// read by the extraction, and the tables and views of the configured schemas
+// (or every schema when none is configured).
+func (e *Extractor) Check(ctx context.Context) []*extractor.Check {
+	e.ctx = ctx
+	checks := []*extractor.Check{}
+
+	conn, err := e.connect()
+	connection := &extractor.Check{Name: "connect to the database", Err: err}
+	if err != nil {
+		connection.Hint = connectionHint(err)
+		return append(checks, connection)
+	}
+	defer conn.Close(context.Background())
+
+	var role, database, version string
+	conn.QueryRow(ctx, "SELECT current_user, current_database(), current_setting('server_version')").Scan(&role, &database, &version)
+	connection.Detail = fmt.Sprintf("%v as %v (server %v)", database, role, version)
+	checks = append(checks, connection)
+	grantee := pgx.Identifier{role}.Sanitize()
+
+	catalogs := []struct {
+		name string
+		sql  string
+		hint string
+	}{
+		{"information_schema", e.SQL("SELECT count(*) FROM information_schema.columns WHERE [SCHEMA_FILTER]", "table_schema"), "GRANT USAGE ON SCHEMA information_schema TO " + grantee + ";"},
+		{"pg_stats", e.SQL(STATS_COUNT_SQL, "schemaname"), "GRANT SELECT ON pg_catalog.pg_stats TO " + grantee + ";"},
+		{"pg_description", "SELECT count(*) FROM pg_catalog.pg_description", "GRANT SELECT ON pg_catalog.pg_description TO " + grantee + ";"},
+	}
+
+	for _, catalog := range catalogs {
+		var count int64
+		err := conn.QueryRow(ctx, catalog.sql).Scan(&count)
+		check := &extractor.Check{Name: "read " + catalog.name, Err: err, Detail: fmt.Sprintf("%v row(s)", count)}
+		if err != nil {
+			check.Hint = catalog.hint
+		} else if catalog.name == "pg_stats" && count == 0 {
+			check.Err = errors.New("no statistics are visible, so the items have no example values")
+			check.Hint = "run ANALYZE on the tables; pg_stats only lists the columns the role can SELECT"
+			check.Warning = true
+		}
+		checks = append(checks, check)
+	}
+
+	patterns := e.schemas
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+
+	for _, pattern := range patterns {
+		rows, err := conn.Query(ctx, SCHEMA_PRIVILEGES_SQL, strings.ReplaceAll(pattern, "*", "%"))
+		if err != nil {
+			checks = append(checks, &extractor.Check{Name: "read schema " + pattern, Err: err})
+			continue
+		}
+
+		found := 0
+		for rows.Next() {
+			var schema string
+			var usage bool
+			var tables, denied int64
+			if err := rows.Scan(&schema, &usage, &tables, &denied); err != nil {
+				checks = append(checks, &extractor.Check{Name: "read schema " + pattern, Err: err})
+				break
+			}
+			found++
+
+			check := &extractor.Check{Name: "read schema " + schema, Detail: fmt.Sprintf("%v table(s) and view(s)", tables)}
+			identifier := pgx.Identifier{schema}.Sanitize()
+			if !usage {
+				check.Err = errors.New("the role has no USAGE privilege on the schema, so it is left out of the sync")
+				check.Hint = fmt.Sprintf("GRANT USAGE ON SCHEMA %v TO %v;", identifier, grantee)
+			} else if denied > 0 {
+				check.Err = fmt.Errorf("%v of %v table(s) and view(s) cannot be read, so they are left out of the sync (and removed from the Datahub)", denied, tables)
+				check.Hint = fmt.Sprintf("GRANT SELECT ON ALL TABLES IN SCHEMA %v TO %v;", identifier, grantee)
+			}
+			checks = append(checks, check)
+		}
+		rows.Close()
+
+		if found == 0 && rows.Err() == nil {
+			checks = append(checks, &extractor.Check{
+				Name: "read schema " + pattern,
+				Err:  fmt.Errorf("no schema of %v matches %q", database, pattern),
+				Hint: "check the schemas setting (* matches any characters)",
+			})
+		}
+	}
+
+	return checks
+}
+
+// connectionHint suggests how to fix a connection failure.
+func connectionHint(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "28P01", "28000":
+			return "check the user and password (or password_file/password_command), and the pg_hba.conf rules for this host"
+		case "3D000":
+			return "check the database name"
+		case "42501":
+			return "grant the role the CONNECT privilege on the database"
+		}
+	}
+
+	return "check the host and port, that the server accepts connections from this host, and the sslmode of the connection string"
+}
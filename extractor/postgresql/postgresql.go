@@ -6,7 +6,7 @@ import (
 	"dhs/util"
 	_ "embed"
 	"errors"
-	"fmt"
+	"log/slog"
 	"net/url"
 	"strings"
 
@@ -20,6 +20,8 @@ type Extractor struct {
 	conn       *pgx.Conn
 	doc        *doc.Doc
 	debug      bool
+	ctx        context.Context
+	logger     *slog.Logger
 }
 
 //go:embed sql/entities.sql
@@ -37,19 +39,33 @@ var STATS_SQL string
 //go:embed sql/introspect.sql
 var DB_SQL string
 
-func New(conn string, schemas []string) Extractor {
-	e := Extractor{connstring: conn, schemas: schemas}
+func New(conn string, schemas []string) *Extractor {
+	e := &Extractor{connstring: conn, schemas: schemas}
 	e.SetConnectionString(conn)
 	e.debug = false
 
 	return e
 }
 
-func (e Extractor) SetDebugging(ok bool) {
+func (e *Extractor) SetDebugging(ok bool) {
 	e.debug = ok
 }
 
-// func (e Extractor) Query(statement string) ([]map[string]interface{}, error) {
+// SetLogger sets the logger of the extractor (slog.Default by default).
+// Debugging messages are logged at the debug level.
+func (e *Extractor) SetLogger(logger *slog.Logger) {
+	e.logger = logger
+}
+
+func (e *Extractor) log() *slog.Logger {
+	if e.logger == nil {
+		return slog.Default()
+	}
+
+	return e.logger
+}
+
+// func (e *Extractor) Query(statement string) ([]map[string]interface{}, error) {
 // 	result := make([]map[string]interface{}, 0)
 // 	err := e.forEachRecord(e.conn, statement, func(record map[string]interface{}) error {
 // 		result = append(result, record)
@@ -59,7 +75,7 @@ func (e Extractor) SetDebugging(ok bool) {
 // 	return result, err
 // }
 
-func (e Extractor) ApplySchemas(names ...string) {
+func (e *Extractor) ApplySchemas(names ...string) {
 	for _, name := range names {
 		if !util.InSlice[string](name, e.schemas) {
 			e.schemas = append(e.schemas, name)
@@ -67,7 +83,8 @@ func (e Extractor) ApplySchemas(names ...string) {
 	}
 }
 
-func (e Extractor) ExpandJSONFields(d *doc.Doc, skipviews bool, fields ...string) {
+func (e *Extractor) ExpandJSONFields(ctx context.Context, d *doc.Doc, skipviews bool, fields ...string) {
+	e.ctx = ctx
 	all := false
 	if len(fields) == 0 || util.InSlice[string]("*", fields) {
 		all = true
@@ -75,13 +92,17 @@ func (e Extractor) ExpandJSONFields(d *doc.Doc, skipviews bool, fields ...string
 
 	conn, err := e.connect()
 	if err != nil {
-		fmt.Println(err)
+		e.log().Error("failed to expand the JSON fields", "error", err)
 		return
 	}
 	defer conn.Close(context.Background())
 
 	for _, items := range d.GetItemsByType("json", "jsonb") {
 		for _, item := range items {
+			if ctx.Err() != nil {
+				return
+			}
+
 			if !skipviews || !strings.Contains(strings.ToUpper(item.Set().Type), "VIEW") {
 				if all || util.InSlice[string](strings.ToLower(item.FQDN), fields) {
 					table := strings.Join(util.Map[string](strings.Split(item.Set().FQDN, "."), func(el string) string { return `"` + el + `"` }), ".")
@@ -112,7 +133,7 @@ func (e Extractor) ExpandJSONFields(d *doc.Doc, skipviews bool, fields ...string
 						WHERE rn = 1;
 					`
 
-					err := forEachRecord(conn, expandsql, func(record map[string]interface{}) error {
+					err := forEachRecord(ctx, conn, expandsql, func(record map[string]interface{}) error {
 						// j, _ := json.MarshalIndent(record, "", "  ")
 						jsonItem := &doc.Item{
 							Name: doc.Name{Physical: item.Name.Physical + "::" + record["attribute"].(string)},
@@ -137,8 +158,7 @@ func (e Extractor) ExpandJSONFields(d *doc.Doc, skipviews bool, fields ...string
 					})
 
 					if err != nil {
-						fmt.Println(expandsql)
-						fmt.Println(err)
+						e.log().Warn("failed to expand a JSON field", "item", item.FQDN, "error", err, "sql", expandsql)
 					}
 				}
 			}
@@ -146,7 +166,7 @@ func (e Extractor) ExpandJSONFields(d *doc.Doc, skipviews bool, fields ...string
 	}
 }
 
-func (e Extractor) SQL(statement string, alias ...string) string {
+func (e *Extractor) SQL(statement string, alias ...string) string {
 	a := "t.table_schema"
 	if len(alias) > 0 {
 		a = string(alias[0])
@@ -170,7 +190,7 @@ func (e Extractor) SQL(statement string, alias ...string) string {
 	return statement
 }
 
-func (e Extractor) SetConnectionString(conn string) error {
+func (e *Extractor) SetConnectionString(conn string) error {
 	e.connstring = util.EncodeURL(conn)
 
 	schema := strings.ToLower(strings.Split(conn, ":")[0])
@@ -181,13 +201,22 @@ func (e Extractor) SetConnectionString(conn string) error {
 	return nil
 }
 
-func (e Extractor) Type() string {
+func (e *Extractor) Type() string {
 	return "PostgreSQL"
 }
 
-func (e Extractor) connect() (*pgx.Conn, error) {
+// context is the context of the current extraction.
+func (e *Extractor) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+
+	return e.ctx
+}
+
+func (e *Extractor) connect() (*pgx.Conn, error) {
 	if e.debug {
-		fmt.Println("establishing connection...")
+		e.log().Debug("establishing connection...")
 	}
 
 	e.connstring = strings.Replace(strings.Replace(e.connstring, "postgresql://", "postgres://", 1), "greenplum://", "postgres://", 1)
@@ -205,10 +234,11 @@ func (e Extractor) connect() (*pgx.Conn, error) {
 		uri.RawQuery = q.Encode()
 	}
 
-	return pgx.Connect(context.Background(), uri.String())
+	return pgx.Connect(e.context(), uri.String())
 }
 
-func (e Extractor) ExtractRelationships(sourcename ...string) (map[string]interface{}, error) {
+func (e *Extractor) ExtractRelationships(ctx context.Context, sourcename ...string) (map[string]interface{}, error) {
+	e.ctx = ctx
 	rels := make(map[string]interface{})
 	sql := e.SQL(RELATIONSHIP_SQL, "col.table_schema")
 	source := ""
@@ -224,7 +254,7 @@ func (e Extractor) ExtractRelationships(sourcename ...string) (map[string]interf
 
 	e.conn = conn
 
-	err = forEachRecord(conn, sql, func(record map[string]interface{}) error {
+	err = forEachRecord(ctx, conn, sql, func(record map[string]interface{}) error {
 		name := record["name"].(string)
 		parent_set := record["source_field_schema"].(string) + "." + source + record["source_field_entity"].(string)
 		child_set := record["foreign_field_schema"].(string) + "." + source + record["foreign_field_entity"].(string)
@@ -272,9 +302,12 @@ func (e Extractor) ExtractRelationships(sourcename ...string) (map[string]interf
 	return rels, err
 }
 
-func (e Extractor) Extract(elements ...string) (*doc.Doc, error) {
+// Extract reads the metadata of the database. The queries are cancelled when
+// the context is done.
+func (e *Extractor) Extract(ctx context.Context, elements ...string) (*doc.Doc, error) {
+	e.ctx = ctx
 	if e.debug {
-		fmt.Println("  ... extraction initiated")
+		e.log().Debug("extraction initiated")
 	}
 
 	if len(elements) == 0 {
@@ -284,7 +317,7 @@ func (e Extractor) Extract(elements ...string) (*doc.Doc, error) {
 	var empty *doc.Doc
 
 	if e.debug {
-		fmt.Println("  ... connecting to database")
+		e.log().Debug("connecting to database")
 	}
 	conn, err := e.connect()
 	if err != nil {
@@ -297,6 +330,7 @@ func (e Extractor) Extract(elements ...string) (*doc.Doc, error) {
 	uri, _ := url.Parse(e.connstring)
 	e.doc = doc.New(&doc.Source{
 		Name: doc.Name{Physical: strings.Replace(uri.Path, "/", "", 1)},
+		Type: e.Type(),
 	})
 
 	if util.InSlice[string]("entities", elements) {
@@ -335,21 +369,21 @@ func (e Extractor) Extract(elements ...string) (*doc.Doc, error) {
 	}
 
 	if e.debug {
-		fmt.Println("  ... extraction complete")
+		e.log().Debug("extraction complete")
 	}
 
 	return e.doc, nil
 }
 
-func (e Extractor) extractDatabaseDetails() error {
+func (e *Extractor) extractDatabaseDetails() error {
 	if e.debug {
-		fmt.Println("  ... extracting database details")
+		e.log().Debug("extracting database details")
 	}
 
 	db := e.doc.Source()
 	sql := strings.ReplaceAll(DB_SQL, "[DATABASE]", db.Name.Physical)
 
-	return forEachRecord(e.conn, sql, func(record map[string]interface{}) error {
+	return forEachRecord(e.context(), e.conn, sql, func(record map[string]interface{}) error {
 		if record["description"] != nil {
 			db.Comment = forceString(record["description"])
 		}
@@ -358,44 +392,44 @@ func (e Extractor) extractDatabaseDetails() error {
 	})
 }
 
-func (e Extractor) extractEntities() error {
+func (e *Extractor) extractEntities() error {
 	if e.debug {
-		fmt.Println("  ... extracting database entities")
+		e.log().Debug("extracting database entities")
 	}
-	return forEachRecord(e.conn, e.SQL(ENTITY_SQL), func(record map[string]interface{}) error {
+	return forEachRecord(e.context(), e.conn, e.SQL(ENTITY_SQL), func(record map[string]interface{}) error {
 		return mapEntityToDoc(record, e.doc)
 	})
 }
 
-func (e Extractor) extractRelationships() error {
+func (e *Extractor) extractRelationships() error {
 	if e.debug {
-		fmt.Println("  ... extracting database relationships")
+		e.log().Debug("extracting database relationships")
 	}
-	return forEachRecord(e.conn, e.SQL(RELATIONSHIP_SQL, "col.table_schema"), func(record map[string]interface{}) error {
+	return forEachRecord(e.context(), e.conn, e.SQL(RELATIONSHIP_SQL, "col.table_schema"), func(record map[string]interface{}) error {
 		return mapRelationshipToDoc(record, e.doc)
 	})
 }
 
-func (e Extractor) extractMaterializedViews() error {
+func (e *Extractor) extractMaterializedViews() error {
 	if e.debug {
-		fmt.Println("  ... extracting database materialized views")
+		e.log().Debug("extracting database materialized views")
 	}
-	return forEachRecord(e.conn, e.SQL(MATVIEW_SQL, "m.schemaname"), func(record map[string]interface{}) error {
+	return forEachRecord(e.context(), e.conn, e.SQL(MATVIEW_SQL, "m.schemaname"), func(record map[string]interface{}) error {
 		return mapEntityToDoc(record, e.doc)
 	})
 }
 
-func (e Extractor) extractStatistics() error {
+func (e *Extractor) extractStatistics() error {
 	if e.debug {
-		fmt.Println("  ... extracting database statistics")
+		e.log().Debug("extracting database statistics")
 	}
-	return forEachRecord(e.conn, e.SQL(STATS_SQL, "schemaname"), func(record map[string]interface{}) error {
+	return forEachRecord(e.context(), e.conn, e.SQL(STATS_SQL, "schemaname"), func(record map[string]interface{}) error {
 		return mapEntityStats(record, e.doc)
 	})
 }
 
-func forEachRecord(conn *pgx.Conn, sql string, fn func(record map[string]interface{}) error) error {
-	rows, err := conn.Query(context.Background(), sql)
+func forEachRecord(ctx context.Context, conn *pgx.Conn, sql string, fn func(record map[string]interface{}) error) error {
+	rows, err := conn.Query(ctx, sql)
 	if err != nil {
 		return err
 	}
@@ -484,6 +518,10 @@ func mapEntityToDoc(record map[string]interface{}, document *doc.Doc) error {
 		Items:   make(map[string]*doc.Item),
 	})
 
+	if forceBool(record["is_partition"], false) {
+		set.Partition = true
+	}
+
 	if record["definition"] != nil && len(strings.TrimSpace(record["definition"].(string))) > 0 {
 		set.Source = record["definition"].(string)
 
@@ -44,6 +44,14 @@ SELECT
     ELSE false
   END
   as view,
+  EXISTS (
+    SELECT 1
+    FROM pg_catalog.pg_inherits inh
+      INNER JOIN pg_catalog.pg_class child ON child.oid = inh.inhrelid
+      INNER JOIN pg_catalog.pg_namespace ns ON ns.oid = child.relnamespace
+    WHERE ns.nspname = c.table_schema
+      AND child.relname = c.table_name
+  ) as is_partition,
   coalesce(keys.indisprimary, false) as primary_key,
   pg_catalog.obj_description(pgc.oid) as entity_comment,
   pgd.description as comment,
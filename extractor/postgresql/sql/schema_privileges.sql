@@ -0,0 +1,16 @@
+-- Schemas with the USAGE privilege of the role, and their tables and views
+-- that it cannot SELECT
+SELECT
+    n.nspname
+  , has_schema_privilege(n.oid, 'USAGE')
+  , count(c.oid)
+  , coalesce(sum(CASE WHEN c.oid IS NOT NULL AND NOT has_table_privilege(c.oid, 'SELECT') THEN 1 ELSE 0 END), 0)
+FROM pg_catalog.pg_namespace n
+  LEFT JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+WHERE n.nspname LIKE $1
+  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+  AND n.nspname NOT LIKE 'pg\_toast%'
+  AND n.nspname NOT LIKE 'pg\_temp%'
+GROUP BY n.oid, n.nspname
+ORDER BY n.nspname
+;
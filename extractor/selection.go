@@ -0,0 +1,164 @@
+package extractor
+
+import (
+	"dhs/archive"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"fmt"
+	"strings"
+)
+
+// Elements lists what can be synced separately (see Selection).
+var Elements = []string{"sets", "items", "relationships", "stats", "views"}
+
+// Selection restricts a sync to some elements:
+//
+//   - sets: adding, updating and deleting sets (tables and views)
+//   - items: adding, updating and deleting the items of any set
+//   - relationships: adding, updating and deleting relationships
+//   - stats: refreshing the example (most common) values of existing items
+//   - views: sets and items of views and materialized views
+//
+// An empty selection syncs everything.
+type Selection map[string]bool
+
+// NewSelection validates the selected elements, which may also be
+// comma-separated.
+func NewSelection(only ...string) (Selection, error) {
+	s := Selection{}
+	for _, value := range only {
+		for _, element := range strings.Split(value, ",") {
+			element = strings.ToLower(strings.TrimSpace(element))
+			if element == util.EmptyString {
+				continue
+			}
+
+			if !util.InSlice[string](element, Elements) {
+				return s, fmt.Errorf("invalid element %q (expected %v)", element, strings.Join(Elements, ", "))
+			}
+			s[element] = true
+		}
+	}
+
+	return s, nil
+}
+
+// All reports whether everything is synced.
+func (s Selection) All() bool {
+	return len(s) == 0
+}
+
+// Has reports whether an element is synced.
+func (s Selection) Has(element string) bool {
+	return s.All() || s[element]
+}
+
+// List returns the selected elements, in the order of Elements.
+func (s Selection) List() []string {
+	list := make([]string, 0, len(Elements))
+	for _, element := range Elements {
+		if s.Has(element) {
+			list = append(list, element)
+		}
+	}
+
+	return list
+}
+
+// Deletes reports whether every deletion of a kind of object (set, item or
+// relationship) is evaluated. A views selection only evaluates the deletions
+// of some sets and items.
+func (s Selection) Deletes(kind string) bool {
+	return s.Has(kind + "s")
+}
+
+// Extract lists what to extract from the data source (see Extractor.Extract).
+// The structure (entities and views) is always extracted, since objects
+// missing from the extraction are deleted and relationships are diffed
+// against the sets.
+func (s Selection) Extract() []string {
+	elements := []string{"entities", "views"}
+
+	if s.Has("relationships") {
+		elements = append(elements, "relationships")
+	}
+
+	if s.Has("stats") {
+		elements = append(elements, "stats")
+	}
+
+	return append(elements, "info")
+}
+
+// Apply removes the changes to elements that are not selected from the set,
+// item, relationship and join diffs.
+func (s Selection) Apply(sets *archive.Diff, items *archive.Diff, rels *archive.Diff, joins *archive.Diff) {
+	if s.All() {
+		return
+	}
+
+	// Items cannot be added to sets that are not created.
+	skipped := map[*doc.Set]bool{}
+	for _, obj := range sets.Added {
+		if set, ok := obj.(*doc.Set); ok && !s.set(set) {
+			skipped[set] = true
+		}
+	}
+
+	filter(sets, func(obj interface{}) bool {
+		set, ok := obj.(*doc.Set)
+		return ok && s.set(set)
+	})
+
+	// Stats only refresh the example values of existing items.
+	stats := make([]interface{}, 0)
+	if s.Has("stats") {
+		for _, obj := range items.Updated {
+			item, ok := obj.(*doc.Item)
+			if ok && !s.item(item) && util.InSlice[string]("example", item.UpdateFields) {
+				item.UpdateFields = []string{"example"}
+				stats = append(stats, item)
+			}
+		}
+	}
+
+	filter(items, func(obj interface{}) bool {
+		item, ok := obj.(*doc.Item)
+		return ok && s.item(item) && !skipped[item.Set()]
+	})
+	items.Updated = append(items.Updated, stats...)
+
+	if !s.Has("relationships") {
+		for _, d := range []*archive.Diff{rels, joins} {
+			filter(d, func(obj interface{}) bool { return false })
+		}
+	}
+}
+
+func (s Selection) set(set *doc.Set) bool {
+	return s.Has("sets") || (s.Has("views") && view(set))
+}
+
+func (s Selection) item(item *doc.Item) bool {
+	return s.Has("items") || (s.Has("views") && item.Set() != nil && view(item.Set()))
+}
+
+func view(set *doc.Set) bool {
+	return strings.Contains(strings.ToUpper(set.Type), "VIEW")
+}
+
+func filter(d *archive.Diff, keep func(obj interface{}) bool) {
+	if d == nil {
+		return
+	}
+
+	for _, list := range []*[]interface{}{&d.Added, &d.Deleted, &d.Updated} {
+		remaining := make([]interface{}, 0, len(*list))
+		for _, obj := range *list {
+			if keep(obj) {
+				remaining = append(remaining, obj)
+			}
+		}
+		*list = remaining
+	}
+}
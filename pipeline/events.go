@@ -0,0 +1,41 @@
+package pipeline
+
+import "time"
+
+// Stage is a step of the sync pipeline.
+type Stage string
+
+const (
+	// Extract reads the metadata from the data source.
+	Extract Stage = "extract"
+	// Stash stores the source metadata in the archive.
+	Stash Stage = "stash"
+	// Diff reads the Datahub metadata and compares it with the source.
+	Diff Stage = "diff"
+	// Plan applies the selection, ownership, deletion policy and
+	// mass-deletion guard to the diffs.
+	Plan Stage = "plan"
+	// Commit previews (dry run) or pushes the changes to the Datahub.
+	Commit Stage = "commit"
+)
+
+// Stages lists the pipeline stages in the order they run.
+var Stages = []Stage{Extract, Stash, Diff, Plan, Commit}
+
+// Listener receives the progress of a sync. The callbacks are called from
+// the goroutine running the sync.
+type Listener interface {
+	// Started is called when a stage begins.
+	Started(stage Stage)
+	// Progress reports a step within a stage, i.e. "stashing 12 set(s)".
+	Progress(stage Stage, message string)
+	// Finished is called when a stage ends, with its duration and error.
+	Finished(stage Stage, elapsed time.Duration, err error)
+}
+
+// NopListener discards every event.
+type NopListener struct{}
+
+func (NopListener) Started(stage Stage)                                    {}
+func (NopListener) Progress(stage Stage, message string)                   {}
+func (NopListener) Finished(stage Stage, elapsed time.Duration, err error) {}
@@ -0,0 +1,47 @@
+package pipeline_test
+
+import (
+	"dhs/extractor"
+	"dhs/pipeline"
+	"testing"
+)
+
+func TestFilterKeepsTheExcludedObjects(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+	before, rels := h.catalog()
+
+	// The users table is dropped, orders.note is dropped and orders.id
+	// changes type, but they are all excluded.
+	source := fixture()
+	source.tables = []table{{"orders", []string{"id", "user_id"}}}
+	source.types = map[string]string{"orders.id": "int8"}
+	source.rels = map[string][2]string{}
+
+	result := h.sync(source, func(o *pipeline.Options) {
+		o.Filter = &extractor.Filter{ExcludeSets: []string{"users"}, ExcludeItems: []string{"note", "orders.id"}}
+		if err := o.Filter.Compile(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if changes := result.Changes(); changes != 0 {
+		t.Errorf("the filtered sync found %v change(s), want none", changes)
+	}
+	h.expect(before, rels)
+
+	for _, set := range h.server.Source("public").Sets {
+		for _, item := range set.Items {
+			if set.Name.Physical+"."+item.Name.Physical == "orders.id" && item.Type != "int4" {
+				t.Errorf("the excluded orders.id was updated to %v", item.Type)
+			}
+		}
+	}
+
+	// Without the filter, the same sync deletes and updates them.
+	result = h.sync(source)
+	if len(result.Sets.Deleted) != 1 || len(result.Items.Deleted) != 1 || len(result.Items.Updated) != 1 {
+		t.Errorf("the unfiltered sync deleted %v set(s) and %v item(s) and updated %v item(s), want 1, 1 and 1",
+			len(result.Sets.Deleted), len(result.Items.Deleted), len(result.Items.Updated))
+	}
+}
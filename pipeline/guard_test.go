@@ -0,0 +1,42 @@
+package pipeline_test
+
+import (
+	"dhs/extractor/datahub"
+	"dhs/pipeline"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMassDeletionCommitsNothing(t *testing.T) {
+	tables := func(names ...string) *fake {
+		source := &fake{}
+		for _, name := range names {
+			source.tables = append(source.tables, table{name, []string{"id"}})
+		}
+		return source
+	}
+
+	many := []string{}
+	for i := 0; i < 12; i++ {
+		many = append(many, fmt.Sprintf("table%02d", i))
+	}
+
+	h := newHarness(t)
+	h.sync(tables(many...))
+	before, _ := h.catalog()
+
+	// The wrong database: most tables are missing, and another one is new.
+	_, err := h.run(tables("table00", "other"))
+	var mass *datahub.MassDeletionError
+	if !errors.As(err, &mass) {
+		t.Fatalf("err = %v, want a mass deletion", err)
+	}
+
+	if after, _ := h.catalog(); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("the aborted sync changed the Datahub: %v, want %v", after, before)
+	}
+
+	h.sync(tables("table00", "other"), func(o *pipeline.Options) { o.AllowMassDelete = true })
+	h.expect([]string{"other.id", "table00.id"}, []string{})
+}
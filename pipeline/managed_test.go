@@ -0,0 +1,149 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"dhs/extractor/datahub"
+	"dhs/pipeline"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// post sends a request to the mock Datahub, like a data steward using the
+// Datahub directly, and returns the response.
+func (h *harness) post(path string, body interface{}) map[string]interface{} {
+	h.t.Helper()
+
+	data, _ := json.Marshal(body)
+	res, err := http.Post(h.url+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		h.t.Fatalf("POST %v: HTTP %v", path, res.StatusCode)
+	}
+
+	result := map[string]interface{}{}
+	json.NewDecoder(res.Body).Decode(&result)
+
+	return result
+}
+
+func fingerprint(value string) func(*pipeline.Options) {
+	return func(o *pipeline.Options) { o.Fingerprint = value }
+}
+
+func adopt(o *pipeline.Options) { o.Adopt = true }
+
+func owned(metadata map[string]interface{}) bool {
+	return metadata[datahub.ManagedByKey] == datahub.ManagedBy && metadata[datahub.FingerprintKey] == "fixture"
+}
+
+func TestSyncStampsWhatItCreates(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+
+	for _, set := range h.server.Source("public").Sets {
+		if !owned(set.Metadata) {
+			t.Errorf("the %v set is not stamped: %v", set.Name.Physical, set.Metadata)
+		}
+
+		for _, item := range set.Items {
+			if !owned(item.Metadata) {
+				t.Errorf("the %v.%v item is not stamped: %v", set.Name.Physical, item.Name.Physical, item.Metadata)
+			}
+		}
+	}
+
+	for _, rel := range h.server.Relationships("public") {
+		if !owned(rel.Metadata) {
+			t.Errorf("the %v relationship is not stamped: %v", rel.Name.Physical, rel.Metadata)
+		}
+	}
+}
+
+func TestRelationshipsSyncKeepsTheStewardRelationships(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+
+	h.post("/catalog/relationships", map[string]interface{}{"relationships": []interface{}{map[string]interface{}{
+		"name":       map[string]interface{}{"physical": "steward_link"},
+		"parent_set": "public.users",
+		"child_set":  "public.orders",
+		"items":      []interface{}{map[string]interface{}{"parent": "public.users.id", "child": "public.orders.note"}},
+	}}})
+
+	result := h.sync(fixture(), only("relationships"))
+
+	h.expect(
+		[]string{"orders.id", "orders.note", "orders.user_id", "users.email", "users.id"},
+		[]string{"orders_user_fk: public.orders.user_id -> public.users.id", "steward_link: public.users.id -> public.orders.note"},
+	)
+	if !strings.Contains(strings.Join(result.Ownership.Unmanaged, ","), "relationship steward_link") {
+		t.Errorf("the steward relationship is not reported as unmanaged: %v", result.Ownership.Unmanaged)
+	}
+}
+
+func TestSyncKeepsTheObjectsOfAnotherDatabase(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+
+	// Another database synced into the same data source does not have the
+	// orders table.
+	result := h.sync(withoutOrders(), fingerprint("other"))
+	if !strings.Contains(strings.Join(result.Ownership.Foreign, ","), "set orders") {
+		t.Errorf("the orders set is not reported as foreign: %v", result.Ownership.Foreign)
+	}
+	h.expect(
+		[]string{"orders.id", "orders.note", "orders.user_id", "users.email", "users.id"},
+		[]string{"orders_user_fk: public.orders.user_id -> public.users.id"},
+	)
+
+	// The database that created it deletes it.
+	h.sync(withoutOrders())
+	h.expect([]string{"users.email", "users.id"}, []string{})
+}
+
+func TestAdoptTagsAndUpdatesUnmanagedObjects(t *testing.T) {
+	h := newHarness(t)
+
+	// A set created by an earlier version, without the marker, with an
+	// outdated item type.
+	set := h.post("/catalog/source/"+h.server.Source("public").ID+"/set", map[string]interface{}{"name": map[string]interface{}{"physical": "legacy"}})
+	h.post("/catalog/set/"+set["id"].(string)+"/items", map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"name": map[string]interface{}{"physical": "id"}, "type": "text", "nullable": true},
+	}})
+
+	source := fixture()
+	source.tables = append(source.tables, table{"legacy", []string{"id"}})
+	legacy := func() (map[string]interface{}, string, map[string]interface{}) {
+		for _, set := range h.server.Source("public").Sets {
+			if set.Name.Physical == "legacy" {
+				return set.Metadata, set.Items[0].Type, set.Items[0].Metadata
+			}
+		}
+
+		t.Fatal("the legacy set was deleted")
+		return nil, "", nil
+	}
+
+	result := h.sync(source)
+	if metadata, itemType, _ := legacy(); itemType != "text" || owned(metadata) {
+		t.Errorf("the unmanaged set was updated: type = %v, metadata = %v", itemType, metadata)
+	}
+	if !strings.Contains(strings.Join(result.Ownership.Unmanaged, ","), "item legacy.id") {
+		t.Errorf("the legacy item is not reported as unmanaged: %v", result.Ownership.Unmanaged)
+	}
+
+	h.sync(source, adopt)
+	if metadata, itemType, itemMetadata := legacy(); itemType != "int4" || !owned(metadata) || !owned(itemMetadata) {
+		t.Errorf("the set was not adopted: type = %v, set metadata = %v, item metadata = %v", itemType, metadata, itemMetadata)
+	}
+
+	if changes := h.sync(source).Changes(); changes != 0 {
+		t.Errorf("the sync after the adoption found %v change(s)", changes)
+	}
+}
@@ -0,0 +1,52 @@
+package pipeline_test
+
+import (
+	"dhs/extractor/datahub"
+	"dhs/pipeline"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// A sync replayed from a cassette makes the same requests and gets the same
+// outcome as the recorded sync, without a Datahub.
+func TestReplayMatchesTheRecording(t *testing.T) {
+	h := newHarness(t)
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+
+	var recorder *datahub.Recorder
+	recorded := h.sync(fixture(), func(o *pipeline.Options) {
+		recorder = datahub.NewRecorder(cassette, o.Datahub.Transport())
+		o.Datahub.SetTransport(recorder)
+	})
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c datahub.Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		t.Fatalf("invalid cassette: %v", err)
+	}
+	if len(c.Interactions) != len(h.server.Requests()) {
+		t.Errorf("recorded %v interactions for %v requests", len(c.Interactions), len(h.server.Requests()))
+	}
+
+	// Replay into a fresh archive, against a Datahub that does not exist.
+	replayer, err := datahub.NewReplayer(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.url = "http://datahub.invalid"
+	h.cache = open(t, "replay.db")
+	replayed := h.sync(fixture(), func(o *pipeline.Options) { o.Datahub.SetTransport(replayer) })
+
+	if replayed.Changes() != recorded.Changes() || replayed.Committed.Succeeded != recorded.Committed.Succeeded || replayed.Committed.Failed != 0 {
+		t.Errorf("replayed %v change(s), %v committed and %v failed; recorded %v change(s) and %v committed", replayed.Changes(), replayed.Committed.Succeeded, replayed.Committed.Failed, recorded.Changes(), recorded.Committed.Succeeded)
+	}
+}
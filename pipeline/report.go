@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"context"
+	"dhs/extractor"
+	"dhs/extractor/datahub"
+	"dhs/extractor/doc"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Report summarizes a sync for machines, i.e. an orchestrator that branches
+// on the outcome.
+type Report struct {
+	Source   string    `json:"source"`
+	DryRun   bool      `json:"dry_run"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Error is the error that stopped the sync (or made it partial), and
+	// Stage the stage that failed.
+	Error string `json:"error,omitempty"`
+	Stage Stage  `json:"failed_stage,omitempty"`
+	// Durations records how long each stage took, in milliseconds.
+	Durations map[Stage]int64 `json:"durations_ms"`
+	// Objects counts the sets, items and relationships read from the
+	// "source" and the "datahub".
+	Objects map[string]Counts `json:"objects"`
+	// Diffs counts the additions, deletions and updates of each kind of
+	// object ("set", "item", "relationship" and "join").
+	Diffs map[string]map[string]int `json:"diffs"`
+	// Deletions counts the objects deprecated, reinstated, purged and
+	// ignored by the deletion policy.
+	Deletions map[string]int `json:"deletions"`
+	Adoptions int            `json:"adoptions"`
+	// Changes is the number of changes the sync commits (or would commit,
+	// in a dry run).
+	Changes  int                    `json:"changes"`
+	Commit   *datahub.CommitResults `json:"commit,omitempty"`
+	Warnings []string               `json:"warnings"`
+}
+
+// Counts is the number of sets, items and relationships in a document.
+type Counts struct {
+	Sets          int `json:"sets"`
+	Items         int `json:"items"`
+	Relationships int `json:"relationships"`
+}
+
+func count(d *doc.Doc) Counts {
+	if d == nil {
+		return Counts{}
+	}
+
+	return Counts{
+		Sets:          len(extractor.GetAllSets(d)),
+		Items:         len(extractor.GetAllItems(d)),
+		Relationships: len(extractor.GetAllRelationships(d)),
+	}
+}
+
+// Changes is the number of changes in the diffs, the deletion plan and the
+// adoptions, i.e. what the commit stage pushes to the Datahub.
+func (r *Result) Changes() int {
+	changes := 0
+	for _, summary := range r.diffs() {
+		for _, n := range summary {
+			changes += n
+		}
+	}
+
+	if r.Deletions != nil {
+		changes += len(r.Deletions.Deprecate) + len(r.Deletions.Reinstate)
+	}
+
+	return changes + r.adoptions()
+}
+
+// diffs summarizes the committed diffs (the joins are part of the
+// relationships).
+func (r *Result) diffs() map[string]map[string]int {
+	diffs := map[string]map[string]int{}
+	if r.Sets != nil {
+		diffs["set"] = datahub.DiffSummary(r.Sets)
+	}
+	if r.Items != nil {
+		diffs["item"] = datahub.DiffSummary(r.Items)
+	}
+	if r.Relationships != nil {
+		diffs["relationship"] = datahub.DiffSummary(r.Relationships)
+	}
+
+	return diffs
+}
+
+func (r *Result) adoptions() int {
+	if r.Ownership == nil {
+		return 0
+	}
+
+	return len(r.Ownership.Sets) + len(r.Ownership.Items) + len(r.Ownership.Relationships)
+}
+
+// Report summarizes the result and the error returned by Run.
+func (r *Result) Report(err error) *Report {
+	report := &Report{
+		Source:    r.Source,
+		DryRun:    r.DryRun,
+		Started:   r.Started,
+		Finished:  r.Finished,
+		Durations: make(map[Stage]int64),
+		Objects:   map[string]Counts{"source": count(r.Doc), "datahub": count(r.Datahub)},
+		Diffs:     r.diffs(),
+		Deletions: map[string]int{},
+		Adoptions: r.adoptions(),
+		Changes:   r.Changes(),
+		Commit:    r.Committed,
+		Warnings:  r.Warnings,
+	}
+
+	if report.Warnings == nil {
+		report.Warnings = []string{}
+	}
+
+	for stage, elapsed := range r.Durations {
+		report.Durations[stage] = elapsed.Milliseconds()
+	}
+
+	if r.Joins != nil {
+		report.Diffs["join"] = datahub.DiffSummary(r.Joins)
+	}
+
+	if r.Deletions != nil {
+		report.Deletions["deprecate"] = len(r.Deletions.Deprecate)
+		report.Deletions["reinstate"] = len(r.Deletions.Reinstate)
+		report.Deletions["purge"] = len(r.Deletions.Purge)
+		report.Deletions["ignore"] = r.Deletions.Ignored
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+
+		var stageErr *StageError
+		if errors.As(err, &stageErr) {
+			report.Stage = stageErr.Stage
+		}
+	}
+
+	return report
+}
+
+// warnings is a log handler that records the warnings of a sync (for the
+// report), whatever the level of the underlying handler.
+type warnings struct {
+	slog.Handler
+	messages *messages
+}
+
+type messages struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (w warnings) Enabled(ctx context.Context, level slog.Level) bool {
+	return level == slog.LevelWarn || w.Handler.Enabled(ctx, level)
+}
+
+func (w warnings) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level == slog.LevelWarn {
+		w.messages.mu.Lock()
+		w.messages.list = append(w.messages.list, record.Message)
+		w.messages.mu.Unlock()
+	}
+
+	if !w.Handler.Enabled(ctx, record.Level) {
+		return nil
+	}
+
+	return w.Handler.Handle(ctx, record)
+}
+
+func (w warnings) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return warnings{Handler: w.Handler.WithAttrs(attrs), messages: w.messages}
+}
+
+func (w warnings) WithGroup(name string) slog.Handler {
+	return warnings{Handler: w.Handler.WithGroup(name), messages: w.messages}
+}
@@ -0,0 +1,73 @@
+package pipeline_test
+
+import (
+	"dhs/extractor"
+	"dhs/extractor/datahub"
+	"dhs/pipeline"
+	"dhs/util"
+	"testing"
+	"time"
+)
+
+func deprecate(o *pipeline.Options) {
+	o.Datahub.SetDeletionPolicy(datahub.DeprecatePolicy, 30*24*time.Hour)
+}
+
+func only(elements ...string) func(*pipeline.Options) {
+	return func(o *pipeline.Options) {
+		o.Selection = extractor.Selection{}
+		for _, element := range elements {
+			o.Selection[element] = true
+		}
+	}
+}
+
+func withoutOrders() *fake {
+	source := fixture()
+	source.tables = source.tables[:1]
+	source.rels = map[string][2]string{}
+	return source
+}
+
+func TestPartialSyncKeepsTheDeprecations(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+	h.sync(withoutOrders(), deprecate)
+
+	result := h.sync(withoutOrders(), deprecate, only("stats"))
+	if n := len(result.Deletions.Reinstate); n != 0 {
+		t.Errorf("the stats sync reinstated %v object(s)", n)
+	}
+
+	deprecations, err := h.cache.Deprecations(h.server.Source("public").ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deprecations) == 0 {
+		t.Error("the stats sync dropped the recorded deprecations")
+	}
+
+	for _, set := range h.server.Source("public").Sets {
+		if set.Name.Physical == "orders" && set.Attributes[datahub.DeprecatedAttribute] != "true" {
+			t.Errorf("the orders set is no longer deprecated: %v", set.Attributes)
+		}
+	}
+}
+
+func TestRelationshipsSyncPrunesRelationships(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+
+	source := fixture()
+	source.rels = map[string][2]string{}
+	result := h.sync(source, only("relationships"))
+
+	if result.Committed.Failed != 0 {
+		t.Errorf("%v change(s) failed: %v", result.Committed.Failed, result.Committed.Errors)
+	}
+	if !util.InSlice[string]("entities", source.elements) {
+		t.Errorf("the relationships sync extracted %v, without the entities", source.elements)
+	}
+
+	h.expect([]string{"orders.id", "orders.note", "orders.user_id", "users.email", "users.id"}, []string{})
+}
@@ -0,0 +1,533 @@
+// Package pipeline synchronizes the metadata of a data source with the Datahub.
+// It is the library behind the sync command, so other services can run a
+// sync programmatically (it is not named sync, which would shadow the
+// standard library package):
+//
+//	cache, err := archive.Open("./datahub-sync.db")
+//	...
+//	result, err := pipeline.Run(ctx, pipeline.Options{
+//		Extractor: postgresql.New(connstr, schemas),
+//		Datahub:   dh,
+//		Archive:   cache,
+//	})
+package pipeline
+
+import (
+	"context"
+	"dhs/archive"
+	"dhs/extractor"
+	"dhs/extractor/datahub"
+	"dhs/extractor/doc"
+	"dhs/util"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Options configure a sync.
+type Options struct {
+	// Extractor reads the metadata from the data source.
+	Extractor extractor.Extractor
+	// Doc is the (expanded) metadata already extracted from the data source,
+	// i.e. a route of a mapping (see Result.Routes). The extractor is not run
+	// when it is set.
+	Doc *doc.Doc
+	// Datahub is the (configured) Datahub client.
+	Datahub *datahub.Datahub
+	// Archive is used to diff the source and the Datahub.
+	Archive *archive.Archive
+	// Selection restricts the sync to some elements (empty syncs
+	// everything).
+	Selection extractor.Selection
+	// Mapping renames the schemas and prefixes the sets of the source, and
+	// leaves out the schemas sent to other Datahub data sources (see
+	// extractor.Mapping). It must be compiled, and is applied before the
+	// filter, so the filter matches the mapped names.
+	Mapping *extractor.Mapping
+	// Filter leaves sets and items (and the relationships joining them) out
+	// of the sync, on both the source and the Datahub side. It must be
+	// compiled.
+	Filter *extractor.Filter
+	// Expand lists the JSON fields expanded so each key is treated as an
+	// item. ExpandFast ignores views.
+	Expand     []string
+	ExpandFast bool
+	// Outfile receives the extraction as JSON (when it ends with .json).
+	Outfile string
+	// CreateSource creates the Datahub data source when it does not exist.
+	CreateSource bool
+	// Fingerprint identifies the data source (see datahub.Fingerprint), and
+	// Adopt tags objects created by earlier versions (see Datahub.Manage).
+	Fingerprint string
+	Adopt       bool
+	// AllowMassDelete commits even when the mass-deletion guard trips.
+	AllowMassDelete bool
+	// DryRun previews the changes without pushing them. Max limits the
+	// number of changes previewed for each kind of object.
+	DryRun bool
+	Max    int
+	// System is the Datahub system/job ID where status messages are logged,
+	// along with the extracted Schemas.
+	System  string
+	Schemas []string
+	// Timeouts limit the duration of the stages (no limit by default). When
+	// the commit stage times out, the remaining changes are queued in the
+	// outbox.
+	Timeouts map[Stage]time.Duration
+	// Listener receives the progress of the sync.
+	Listener Listener
+	// Logger is used by the pipeline, the extractor, the Datahub client and
+	// the archive (slog.Default by default).
+	Logger *slog.Logger
+}
+
+// Result is the outcome of a sync.
+type Result struct {
+	// Source is the Datahub data source.
+	Source   string
+	Started  time.Time
+	Finished time.Time
+	// Doc is the extracted source metadata, and Datahub the metadata read
+	// from the Datahub.
+	Doc     *doc.Doc
+	Datahub *doc.Doc
+	// Routes is the metadata of the schemas the mapping sends to other
+	// Datahub data sources, by data source (see extractor.Mapping.Split).
+	Routes map[string]*doc.Doc
+	// The set, item, relationship and join diffs (after the plan).
+	Sets          *archive.Diff
+	Items         *archive.Diff
+	Relationships *archive.Diff
+	Joins         *archive.Diff
+	Ownership     *datahub.Ownership
+	Deletions     *datahub.DeletionPlan
+	// Committed counts the changes pushed, failed and queued.
+	Committed *datahub.CommitResults
+	// Durations records how long each stage took.
+	Durations map[Stage]time.Duration
+	DryRun    bool
+	// Warnings lists the warnings logged during the sync.
+	Warnings []string
+}
+
+// Run extracts the metadata from the data source, diffs it with the Datahub
+// and commits the changes (unless it is a dry run). It stops at the first
+// stage that fails. A sync that commits some changes but fails others returns
+// the result and an error.
+//
+// When the context is done, the current stage stops: queries and reads are
+// cancelled, and during the commit, the requests in flight finish and the
+// remaining changes are queued in the outbox. The context error is returned
+// (wrapped in a StageError).
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Extractor == nil || opts.Datahub == nil || opts.Archive == nil {
+		return nil, errors.New("the extractor, Datahub client and archive are required")
+	}
+
+	if opts.Listener == nil {
+		opts.Listener = NopListener{}
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	collected := &messages{}
+	opts.Logger = slog.New(warnings{Handler: opts.Logger.Handler(), messages: collected})
+	opts.Extractor.SetLogger(opts.Logger)
+	opts.Datahub.SetLogger(opts.Logger)
+	opts.Archive.SetLogger(opts.Logger)
+	opts.Datahub.SetFilter(opts.Filter)
+	opts.Datahub.SetSelection(opts.Selection)
+
+	if opts.Max < 1 {
+		opts.Max = 35
+	}
+
+	p := &pipeline{
+		ctx:   ctx,
+		opts:  opts,
+		dh:    opts.Datahub,
+		cache: opts.Archive,
+		result: &Result{
+			Source:    opts.Datahub.Source(),
+			Started:   time.Now(),
+			DryRun:    opts.DryRun,
+			Durations: make(map[Stage]time.Duration),
+		},
+		warnings: collected,
+	}
+
+	p.dh.Manage(opts.Fingerprint, opts.Adopt)
+	p.job = p.dh.Job(opts.System)
+	p.job.Started(map[string]interface{}{
+		"schemas": opts.Schemas,
+		"dry_run": opts.DryRun,
+		"only":    opts.Selection.List(),
+	})
+
+	for _, stage := range []struct {
+		name Stage
+		run  func() error
+	}{
+		{Extract, p.extract},
+		{Stash, p.stash},
+		{Diff, p.diff},
+		{Plan, p.plan},
+		{Commit, p.commit},
+	} {
+		if err := ctx.Err(); err != nil {
+			return p.finish(&StageError{Stage: stage.name, Err: err})
+		}
+
+		if err := p.run(ctx, stage.name, stage.run); err != nil {
+			return p.finish(err)
+		}
+	}
+
+	return p.finish(nil)
+}
+
+// StageError reports the stage of the pipeline that failed.
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return fmt.Sprintf("the %v stage timed out", e.Stage)
+	}
+
+	if errors.Is(e.Err, context.Canceled) {
+		return fmt.Sprintf("the sync was interrupted during the %v stage", e.Stage)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// run runs a stage with its timeout. The stage fails when its context is done,
+// even if the stage itself completed (i.e. the commit queued the remaining
+// changes).
+func (p *pipeline) run(ctx context.Context, stage Stage, fn func() error) error {
+	if timeout := p.opts.Timeouts[stage]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	p.ctx = ctx
+	p.dh.SetContext(ctx)
+	if stage == Commit {
+		// The archive records the outbox and the deletion states, which
+		// must survive an interruption.
+		p.cache.SetContext(context.WithoutCancel(ctx))
+	} else {
+		p.cache.SetContext(ctx)
+	}
+
+	start := time.Now()
+	p.opts.Logger.Debug("starting the "+string(stage)+" stage", "stage", stage)
+	p.opts.Listener.Started(stage)
+	err := fn()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		err = &StageError{Stage: stage, Err: err}
+	}
+	p.result.Durations[stage] = time.Since(start)
+	if err != nil {
+		p.opts.Logger.Error(err.Error(), "stage", stage, "elapsed", p.result.Durations[stage])
+	} else {
+		p.opts.Logger.Info("the "+string(stage)+" stage finished", "stage", stage, "elapsed", p.result.Durations[stage])
+	}
+	p.opts.Listener.Finished(stage, p.result.Durations[stage], err)
+
+	return err
+}
+
+// finish records the outcome of the sync in the job log.
+func (p *pipeline) finish(err error) (*Result, error) {
+	// The outcome is logged even when the sync was interrupted.
+	p.dh.SetContext(context.WithoutCancel(p.ctx))
+	p.cache.SetContext(context.WithoutCancel(p.ctx))
+
+	p.result.Committed = p.dh.Results()
+	if err == nil {
+		err = p.failure
+	}
+	if err == nil && p.result.Committed.Failed > 0 {
+		err = fmt.Errorf("%v change(s) failed to commit", p.result.Committed.Failed)
+		p.opts.Logger.Error(err.Error(), "failed", p.result.Committed.Failed)
+	}
+	p.job.Finished(err)
+
+	p.result.Finished = time.Now()
+	p.warnings.mu.Lock()
+	p.result.Warnings = append([]string{}, p.warnings.list...)
+	p.warnings.mu.Unlock()
+
+	return p.result, err
+}
+
+type pipeline struct {
+	ctx      context.Context
+	opts     Options
+	dh       *datahub.Datahub
+	cache    *archive.Archive
+	job      *datahub.JobLog
+	result   *Result
+	failure  error
+	warnings *messages
+}
+
+// progress logs a step and reports it to the listener. The attributes
+// (key-value pairs) are only logged.
+func (p *pipeline) progress(stage Stage, level slog.Level, message string, attrs ...any) {
+	p.opts.Logger.Log(p.ctx, level, message, append([]any{"stage", stage}, attrs...)...)
+	p.opts.Listener.Progress(stage, message)
+}
+
+func (p *pipeline) debug(stage Stage, message string) {
+	p.opts.Logger.Debug(message, "stage", stage)
+}
+
+// extract reads the source metadata, expands the JSON fields, maps and
+// filters the metadata, and writes the JSON output file.
+func (p *pipeline) extract() error {
+	start := time.Now()
+	elements := p.opts.Selection.Extract()
+
+	d := p.opts.Doc
+	if d == nil {
+		var err error
+		if d, err = p.opts.Extractor.Extract(p.ctx, elements...); err != nil {
+			return err
+		}
+	}
+	p.result.Doc = d
+	p.cache.SetDoc(d)
+	p.job.Extracted(len(extractor.GetAllSets(d)), len(extractor.GetAllItems(d)), len(extractor.GetAllRelationships(d)), time.Since(start))
+
+	if p.opts.Doc == nil && len(p.opts.Expand) > 0 && (util.InSlice[string]("views", elements) || util.InSlice[string]("entities", elements)) {
+		p.debug(Extract, "enabling JSON field expansion functions...")
+		start := time.Now()
+		p.opts.Extractor.ExpandJSONFields(p.ctx, d, p.opts.ExpandFast, p.opts.Expand...)
+		p.progress(Extract, slog.LevelInfo, fmt.Sprintf("JSON expansion: %s", time.Since(start)), "elapsed", time.Since(start))
+	}
+
+	// The schemas of the other data sources are set aside before the
+	// mapping, so their syncs do not extract them again.
+	p.result.Routes = p.opts.Mapping.Split(d)
+	schemas, rels, err := p.opts.Mapping.Apply(d)
+	if err != nil {
+		return err
+	}
+	for _, route := range p.result.Routes {
+		schemas += len(route.GetSchemas())
+	}
+	if schemas > 0 {
+		p.progress(Extract, slog.LevelInfo, fmt.Sprintf("left out %v schema(s) mapped to other Datahub data sources", schemas), "schemas", schemas)
+	}
+	if rels > 0 {
+		p.progress(Extract, slog.LevelWarn, fmt.Sprintf("left out %v relationship(s) joining schemas mapped to different Datahub data sources", rels), "relationships", rels)
+	}
+
+	if sets, items, rels := p.opts.Filter.Apply(d); sets+items+rels > 0 {
+		p.progress(Extract, slog.LevelInfo, fmt.Sprintf("filtered out %v set(s), %v item(s) and %v relationship(s)", sets, items, rels), "sets", sets, "items", items, "relationships", rels)
+	}
+
+	if strings.ToLower(filepath.Ext(p.opts.Outfile)) == ".json" {
+		p.debug(Extract, "writing metadoc to JSON file...")
+		util.DumpFile(p.opts.Outfile, d.ToJSON())
+		p.progress(Extract, slog.LevelInfo, "created "+p.opts.Outfile, "file", p.opts.Outfile)
+	}
+
+	return nil
+}
+
+// stash stores the source metadata in the archive.
+func (p *pipeline) stash() error {
+	elements := p.opts.Selection.Extract()
+	d := p.result.Doc
+
+	if util.InSlice[string]("entities", elements) || util.InSlice[string]("relationships", elements) {
+		sets := extractor.GetAllSets(d)
+		p.progress(Stash, slog.LevelInfo, fmt.Sprintf("stashing %v set(s)...", len(sets)), "count", len(sets))
+		if err := p.cache.UpsertSets("source", sets); err != nil {
+			return err
+		}
+
+		items := extractor.GetAllItems(d)
+		p.progress(Stash, slog.LevelInfo, fmt.Sprintf("stashing %v item(s)...", len(items)), "count", len(items))
+		if err := p.cache.UpsertItems("source", items); err != nil {
+			return err
+		}
+	}
+
+	if util.InSlice[string]("relationships", elements) {
+		rels := extractor.GetAllRelationships(d)
+		p.progress(Stash, slog.LevelInfo, fmt.Sprintf("stashing %v relationship(s)...", len(rels)), "count", len(rels))
+		if err := p.cache.UpsertRelationships("source", rels); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diff reads the Datahub metadata (after delivering the outbox) into the
+// archive and compares it with the source.
+func (p *pipeline) diff() error {
+	if p.opts.CreateSource {
+		p.dh.CreateMissingSource(p.result.Doc.Source())
+	}
+
+	p.debug(Diff, "populating datahub sources...")
+	if err := p.dh.Flush(); err != nil {
+		return err
+	}
+
+	if err := p.dh.PopulateSources(); err != nil {
+		return err
+	}
+
+	p.result.Datahub = p.dh.GetDoc()
+	sets := extractor.GetAllSets(p.dh.GetDoc())
+	p.progress(Diff, slog.LevelInfo, fmt.Sprintf("stashing %v set(s)...", len(sets)), "count", len(sets))
+	if err := p.cache.UpsertSets("datahub", sets); err != nil {
+		return err
+	}
+
+	p.debug(Diff, "diffing sets...")
+	setdiff, err := p.cache.DiffSets()
+	if err != nil {
+		return err
+	}
+
+	p.debug(Diff, "populating data items...")
+	if err := p.dh.PopulateItems(setdiff); err != nil {
+		return err
+	}
+
+	items := extractor.GetAllItems(p.dh.GetDoc())
+	p.progress(Diff, slog.LevelInfo, fmt.Sprintf("stashing %v item(s)...", len(items)), "count", len(items))
+	if err := p.cache.UpsertItems("datahub", items); err != nil {
+		return err
+	}
+
+	p.debug(Diff, "diffing data items...")
+	itemdiff, err := p.cache.DiffItems(setdiff)
+	if err != nil {
+		return err
+	}
+
+	p.debug(Diff, "populating datahub relationships...")
+	if err := p.dh.PopulateRelationships(setdiff); err != nil {
+		return err
+	}
+
+	rels := extractor.GetAllRelationships(p.dh.GetDoc())
+	p.progress(Diff, slog.LevelInfo, fmt.Sprintf("stashing %v relationship(s)...", len(rels)), "count", len(rels))
+	if err := p.cache.UpsertRelationships("datahub", rels); err != nil {
+		return err
+	}
+
+	p.debug(Diff, "diffing data relationships...")
+	reldiff, err := p.cache.DiffRelationships(setdiff)
+	if err != nil {
+		return err
+	}
+
+	p.debug(Diff, "diffing individual relationship joins...")
+	joindiff, err := p.cache.DiffJoins(setdiff, reldiff)
+	if err != nil {
+		return err
+	}
+
+	p.result.Sets, p.result.Items, p.result.Relationships, p.result.Joins = setdiff, itemdiff, reldiff, joindiff
+
+	return nil
+}
+
+// plan removes the changes that must not be committed from the diffs: the
+// elements that are not selected, the objects the tool does not manage and
+// the deletions that the deletion policy prevents. It fails when the
+// remaining deletions trip the mass-deletion guard (except in a dry run).
+func (p *pipeline) plan() error {
+	r := p.result
+	p.opts.Selection.Apply(r.Sets, r.Items, r.Relationships, r.Joins)
+	r.Ownership = p.dh.ProtectUnmanaged(r.Sets, r.Items, r.Relationships)
+
+	plan, err := p.dh.PlanDeletions(r.Sets, r.Items, r.Relationships)
+	r.Deletions = plan
+	if err != nil {
+		// The deletions were dropped, so the sync can safely continue.
+		p.progress(Plan, slog.LevelError, err.Error())
+		p.failure = err
+	}
+
+	p.job.Diffed(map[string]map[string]int{
+		"set":          datahub.DiffSummary(r.Sets),
+		"item":         datahub.DiffSummary(r.Items),
+		"relationship": datahub.DiffSummary(r.Relationships),
+		"join":         datahub.DiffSummary(r.Joins),
+	})
+
+	// Guard against syncing the wrong database or schemas into the data
+	// source.
+	if err := p.dh.CheckDeletions(r.Sets, r.Items, r.Relationships); err != nil {
+		switch {
+		case p.opts.AllowMassDelete:
+			p.progress(Plan, slog.LevelWarn, err.(*datahub.MassDeletionError).Summary()+"; proceeding because mass deletions are allowed")
+		case p.opts.DryRun:
+			p.progress(Plan, slog.LevelWarn, err.Error())
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit previews the changes and, unless it is a dry run, pushes them to
+// the Datahub.
+func (p *pipeline) commit() error {
+	r := p.result
+	max := p.opts.Max
+
+	p.dh.DryRunOwnership(r.Ownership, max)
+	p.dh.DryRunDeletions(r.Deletions, max)
+
+	if p.opts.DryRun {
+		p.debug(Commit, "running dry run...")
+		p.dh.DryRun(r.Sets, max)
+		p.dh.DryRun(r.Items, max, "item")
+		p.dh.DryRun(r.Relationships, max, "relationship")
+		p.dh.DryRun(r.Joins, max, "join")
+		return nil
+	}
+
+	p.debug(Commit, "syncing...")
+	p.dh.CommitAdoptions(r.Ownership)
+	p.dh.CommitDeletions(r.Deletions)
+	p.dh.DryRun(r.Sets, max)
+	p.dh.Commit(r.Sets)
+	p.dh.DryRun(r.Items, max, "item")
+	p.dh.Commit(r.Items)
+	p.dh.DryRun(r.Relationships, max, "relationship")
+	p.dh.Commit(r.Relationships)
+	p.job.Committed(p.dh.Results())
+
+	p.cache.ResetDatahub()
+	p.cache.ResetDatasource()
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"dhs/archive"
+	"dhs/extractor/datahub"
+	"dhs/extractor/datahub/datahubtest"
+	"dhs/extractor/doc"
+	"dhs/pipeline"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// table describes a set of the fixture database.
+type table struct {
+	name  string
+	items []string
+}
+
+// fake is an extractor serving an in-memory public schema.
+type fake struct {
+	tables []table
+	// rels lists the foreign keys, as parent set.item -> child set.item.
+	rels map[string][2]string
+	// types overrides the int4 type of items, by set.item.
+	types map[string]string
+	// elements lists what the last sync extracted.
+	elements []string
+}
+
+func (f *fake) Extract(_ context.Context, elements ...string) (*doc.Doc, error) {
+	f.elements = elements
+	d := doc.New(&doc.Source{Name: doc.Name{Physical: "db"}})
+	schema := d.ApplySchema(&doc.Schema{Name: doc.Name{Physical: "public"}, Sets: map[string]*doc.Set{}})
+
+	for _, t := range f.tables {
+		set := schema.UpsertSet(&doc.Set{Name: doc.Name{Physical: t.name, Logical: t.name}, Type: "TABLE", Items: map[string]*doc.Item{}})
+		for _, item := range t.items {
+			kind := "int4"
+			if value, exists := f.types[t.name+"."+item]; exists {
+				kind = value
+			}
+			set.UpsertItem(&doc.Item{Name: doc.Name{Physical: item}, Type: kind, Nullable: true, FQDN: "public." + t.name + "." + item})
+		}
+	}
+
+	for name, join := range f.rels {
+		parent := strings.SplitN(join[0], ".", 2)
+		child := strings.SplitN(join[1], ".", 2)
+		set, err := schema.GetSet(parent[0])
+		if err != nil {
+			return d, err
+		}
+
+		rel := set.UpsertRelationship(&doc.Relationship{
+			Name:      doc.Name{Physical: name},
+			Type:      "foreign",
+			Integrity: &doc.ReferentialIntegrity{Update: "NO ACTION", Delete: "NO ACTION", Match: "SIMPLE"},
+		})
+		rel.UpsertJoin(&doc.Join{
+			Parent:   &doc.RelItem{Schema: "public", Set: parent[0], Item: parent[1], FQDN: "public." + join[0]},
+			Child:    &doc.RelItem{Schema: "public", Set: child[0], Item: child[1], FQDN: "public." + join[1]},
+			Position: 1,
+		})
+	}
+
+	return d, nil
+}
+
+func (f *fake) SetConnectionString(string) error { return nil }
+func (f *fake) ExtractRelationships(context.Context, ...string) (map[string]interface{}, error) {
+	return nil, nil
+}
+func (f *fake) Type() string                                                { return "fake" }
+func (f *fake) ExpandJSONFields(context.Context, *doc.Doc, bool, ...string) {}
+func (f *fake) SetDebugging(bool)                                           {}
+func (f *fake) SetLogger(*slog.Logger)                                      {}
+func (f *fake) ApplySchemas(...string)                                      {}
+
+func fixture() *fake {
+	return &fake{
+		tables: []table{
+			{"users", []string{"id", "email"}},
+			{"orders", []string{"id", "user_id", "note"}},
+		},
+		rels: map[string][2]string{"orders_user_fk": {"orders.user_id", "users.id"}},
+	}
+}
+
+// harness runs syncs against a mock Datahub with a public data source.
+type harness struct {
+	t      *testing.T
+	server *datahubtest.Server
+	url    string
+	cache  *archive.Archive
+	// out collects the previews of the changes.
+	out bytes.Buffer
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	server, err := datahubtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddSource("public")
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	return &harness{t: t, server: server, url: ts.URL, cache: open(t, "archive.db")}
+}
+
+// open creates an archive in the temporary directory of the test.
+func open(t *testing.T, name string) *archive.Archive {
+	t.Helper()
+
+	cache, err := archive.Open(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cache
+}
+
+func (h *harness) sync(source *fake, opts ...func(*pipeline.Options)) *pipeline.Result {
+	h.t.Helper()
+
+	result, err := h.run(source, opts...)
+	if err != nil {
+		h.t.Fatalf("sync failed: %v", err)
+	}
+
+	return result
+}
+
+// run syncs the source and returns the error of the sync.
+func (h *harness) run(source *fake, opts ...func(*pipeline.Options)) (*pipeline.Result, error) {
+	h.t.Helper()
+
+	dh, err := datahub.New(h.url, "public", h.cache)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	dh.SetOutput(&h.out)
+
+	options := pipeline.Options{
+		Extractor:   source,
+		Datahub:     dh,
+		Archive:     h.cache,
+		Fingerprint: "fixture",
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return pipeline.Run(context.Background(), options)
+}
+
+// catalog lists the set.item names and the relationships (name: parent ->
+// child) of the mock data source.
+func (h *harness) catalog() ([]string, []string) {
+	items := make([]string, 0)
+	for _, set := range h.server.Source("public").Sets {
+		for _, item := range set.Items {
+			items = append(items, set.Name.Physical+"."+item.Name.Physical)
+		}
+	}
+	sort.Strings(items)
+
+	rels := make([]string, 0)
+	for _, rel := range h.server.Relationships("public") {
+		for _, join := range rel.Items {
+			rels = append(rels, rel.Name.Physical+": "+join.Parent+" -> "+join.Child)
+		}
+	}
+	sort.Strings(rels)
+
+	return items, rels
+}
+
+func (h *harness) expect(items []string, rels []string) {
+	h.t.Helper()
+
+	gotItems, gotRels := h.catalog()
+	if strings.Join(gotItems, ",") != strings.Join(items, ",") {
+		h.t.Errorf("items = %v, want %v", gotItems, items)
+	}
+
+	if strings.Join(gotRels, ",") != strings.Join(rels, ",") {
+		h.t.Errorf("relationships = %v, want %v", gotRels, rels)
+	}
+}
+
+func TestRunPushesTheCatalog(t *testing.T) {
+	h := newHarness(t)
+	result := h.sync(fixture())
+
+	if got := len(result.Sets.Added); got != 2 {
+		t.Errorf("added %v sets, want 2", got)
+	}
+	if result.Committed.Failed != 0 {
+		t.Errorf("%v change(s) failed: %v", result.Committed.Failed, result.Committed.Errors)
+	}
+
+	h.expect(
+		[]string{"orders.id", "orders.note", "orders.user_id", "users.email", "users.id"},
+		[]string{"orders_user_fk: public.orders.user_id -> public.users.id"},
+	)
+
+	src := h.server.Source("public")
+	for _, set := range src.Sets {
+		if set.Metadata["managed_by"] == nil {
+			t.Errorf("the %v set is not tagged as managed: %v", set.Name.Physical, set.Metadata)
+		}
+	}
+}
+
+func TestRunIsIdempotent(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+
+	result := h.sync(fixture())
+	if changes := result.Changes(); changes != 0 {
+		t.Errorf("the second sync found %v change(s), want none", changes)
+	}
+}
+
+func TestRunDeletesWhatTheSourceDropped(t *testing.T) {
+	h := newHarness(t)
+	h.sync(fixture())
+
+	source := fixture()
+	source.tables = []table{
+		{"users", []string{"id", "email", "name"}},
+		{"orders", []string{"id", "user_id"}},
+	}
+	source.rels = map[string][2]string{}
+	h.sync(source)
+
+	h.expect([]string{"orders.id", "orders.user_id", "users.email", "users.id", "users.name"}, []string{})
+}
+
+func TestDryRunPushesNothing(t *testing.T) {
+	h := newHarness(t)
+	result := h.sync(fixture(), func(o *pipeline.Options) { o.DryRun = true })
+
+	if len(result.Sets.Added) != 2 {
+		t.Errorf("the dry run found %v new sets, want 2", len(result.Sets.Added))
+	}
+	for _, line := range []string{"2 set(s) will be added to the Datahub", "+ users", "5 item(s) will be added to the Datahub", "+ users.email"} {
+		if !strings.Contains(h.out.String(), line) {
+			t.Errorf("the preview does not contain %q:\n%v", line, h.out.String())
+		}
+	}
+	h.expect([]string{}, []string{})
+}
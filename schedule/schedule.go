@@ -0,0 +1,232 @@
+// Package schedule parses cron expressions, so syncs can run periodically
+// (see the serve command).
+//
+// A schedule is a standard five-field cron expression (minute, hour, day of
+// the month, month and day of the week), i.e. "*/30 * * * *" or
+// "0 2 * * mon-fri", or one of the descriptors @yearly, @monthly, @weekly,
+// @daily, @hourly and "@every <duration>".
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the activation times of a periodic job.
+type Schedule interface {
+	// Next returns the first activation time after t.
+	Next(t time.Time) time.Time
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	months = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	days   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// Parse parses a cron expression or descriptor.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q (expected a duration of at least 1s, i.e. @every 30m)", spec)
+		}
+
+		return Every(every), nil
+	}
+
+	if expr, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q (expected 5 fields: minute, hour, day of month, month and day of week)", spec)
+	}
+
+	c := &cron{}
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: minute: %w", spec, err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: hour: %w", spec, err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of month: %w", spec, err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, months); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: month: %w", spec, err)
+	}
+	// Sunday is 0 or 7.
+	if c.dow, err = parseField(fields[4], 0, 7, days); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of week: %w", spec, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anydom = strings.HasPrefix(fields[2], "*") || fields[2] == "?"
+	c.anydow = strings.HasPrefix(fields[4], "*") || fields[4] == "?"
+
+	return c, nil
+}
+
+// Every runs a job at a fixed interval.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// cron is a parsed cron expression. Each field is a bit set of the allowed
+// values.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// A day matches the day of month or the day of week when both are
+	// restricted, and both otherwise, like in cron: a field starting with *
+	// (i.e. */2) is not restricted.
+	anydom, anydow bool
+}
+
+// Next returns the first activation time after t, in the location of t. The
+// expression applies to the wall clock: on daylight saving time changes, an
+// activation time skipped by the clock runs once the clock moved forward, and
+// a repeated one only runs once.
+func (c *cron) Next(t time.Time) time.Time {
+	// The wall clock times are walked in UTC, which has no daylight saving
+	// time changes.
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	// Give up after five years (i.e. "0 0 30 2 *" never runs).
+	limit := wall.AddDate(5, 0, 0)
+
+	for wall.Before(limit) {
+		if c.month&(1<<uint(wall.Month())) == 0 {
+			wall = time.Date(wall.Year(), wall.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !c.day(wall) {
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if c.hour&(1<<uint(wall.Hour())) == 0 {
+			wall = wall.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if c.minute&(1<<uint(wall.Minute())) == 0 {
+			wall = wall.Add(time.Minute)
+			continue
+		}
+
+		// The time is after t unless the wall clock was turned back.
+		next := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, t.Location())
+		if next.Hour() != wall.Hour() || next.Minute() != wall.Minute() {
+			// The wall clock skipped the time: it runs as late as the
+			// offset before the change puts it (2:30 is 3:30 when the
+			// clock moves from 2:00 to 3:00).
+			_, offset := next.Zone()
+			if later := wall.Add(-time.Duration(offset) * time.Second).In(t.Location()); later.After(next) {
+				next = later
+			}
+		}
+		if next.After(t) {
+			return next
+		}
+		wall = wall.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
+
+func (c *cron) day(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.anydom || c.anydow {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// parseField parses a comma-separated list of values, ranges (1-5) and steps
+// (*/15 or 0-30/10) into a bit set.
+func parseField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step = n
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			value, err := parseValue(part, names)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range (%v-%v)", part, min, max)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	if bits == 0 {
+		return 0, errors.New("no value")
+	}
+
+	return bits, nil
+}
+
+func parseValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,170 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{"*/30 * * * *", true},
+		{"0 2 * * mon-fri", true},
+		{"0 0 * JAN,jul sun", true},
+		{"0 0 * * 7", true},
+		{"0-30/10 8-18 1,15 * ?", true},
+		{"@daily", true},
+		{"@WEEKLY", true},
+		{"@every 90m", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"x * * * *", false},
+		{"@every 500ms", false},
+		{"@every soon", false},
+		{"@sometimes", false},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.spec)
+		if (err == nil) != test.valid {
+			t.Errorf("Parse(%q) error = %v, want valid = %v", test.spec, err, test.valid)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := func(value string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"*/15 * * * *", "2026-10-16 10:07", "2026-10-16 10:15"},
+		{"@hourly", "2026-10-16 10:00", "2026-10-16 11:00"},
+		{"@every 90m", "2026-10-16 10:07", "2026-10-16 11:37"},
+		{"0 2 * * mon-fri", "2026-10-16 03:00", "2026-10-19 02:00"},
+		{"0 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"0 0 1 1 *", "2026-10-16 00:00", "2027-01-01 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"0 0 30 2 *", "2026-03-01 00:00", ""},
+		// Sunday is 0 or 7.
+		{"0 0 * * 7", "2026-10-16 00:00", "2026-10-18 00:00"},
+		{"0 0 * * sun", "2026-10-16 00:00", "2026-10-18 00:00"},
+		// Restricting both the day of month and the day of week runs on
+		// either: the 13th or a Friday.
+		{"0 0 13 * fri", "2026-10-02 12:00", "2026-10-09 00:00"},
+		{"0 0 13 * fri", "2026-10-09 12:00", "2026-10-13 00:00"},
+		// A field starting with * does not restrict the day: odd days
+		// that are Mondays.
+		{"0 0 */2 * mon", "2026-10-16 00:00", "2026-10-19 00:00"},
+		{"0 0 */2 * mon", "2026-10-19 12:00", "2026-11-09 00:00"},
+		{"0 0 * * mon", "2026-10-16 00:00", "2026-10-19 00:00"},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.spec, err)
+		}
+
+		got := s.Next(utc(test.from))
+		if test.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q from %v = %v, want never", test.spec, test.from, got)
+			}
+			continue
+		}
+
+		if !got.Equal(utc(test.want)) {
+			t.Errorf("%q from %v = %v, want %v", test.spec, test.from, got, test.want)
+		}
+	}
+}
+
+func TestNextAcrossDaylightSavingTime(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The clocks of New York go from 02:00 EST to 03:00 EDT on 2026-03-08,
+	// and from 02:00 EDT back to 01:00 EST on 2026-11-01.
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			"skipped times run once the clock moved forward",
+			"30 2 * * *",
+			time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			[]time.Time{
+				time.Date(2026, 3, 8, 7, 30, 0, 0, time.UTC), // 03:30 EDT
+				time.Date(2026, 3, 9, 6, 30, 0, 0, time.UTC), // 02:30 EDT
+			},
+		},
+		{
+			"intervals resume after the skipped hour",
+			"*/30 * * * *",
+			time.Date(2026, 3, 8, 1, 15, 0, 0, ny),
+			[]time.Time{
+				time.Date(2026, 3, 8, 6, 30, 0, 0, time.UTC), // 01:30 EST
+				time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),  // 03:00 EDT
+				time.Date(2026, 3, 8, 7, 30, 0, 0, time.UTC), // 03:30 EDT
+			},
+		},
+		{
+			"repeated times run once",
+			"30 1 * * *",
+			time.Date(2026, 10, 31, 12, 0, 0, 0, ny),
+			[]time.Time{
+				time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+				time.Date(2026, 11, 2, 6, 30, 0, 0, time.UTC), // 01:30 EST
+			},
+		},
+		{
+			"intervals skip the repeated hour",
+			"*/30 * * * *",
+			time.Date(2026, 11, 1, 1, 15, 0, 0, ny),
+			[]time.Time{
+				time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+				time.Date(2026, 11, 1, 7, 0, 0, 0, time.UTC),  // 02:00 EST
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.spec, err)
+		}
+
+		next := test.from
+		for i, want := range test.want {
+			next = s.Next(next)
+			if !next.Equal(want) {
+				t.Errorf("%v: activation #%v = %v, want %v", test.name, i+1, next, want.In(ny))
+				break
+			}
+		}
+	}
+}
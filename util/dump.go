@@ -3,6 +3,7 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 )
 
@@ -14,7 +15,7 @@ func Dump(obj interface{}) {
 func DumpFile(name string, obj interface{}) {
 	file, err := os.Create(name)
 	if err != nil {
-		fmt.Println("Error creating file", err)
+		slog.Error("Error creating file", "file", name, "error", err)
 		return
 	}
 	defer file.Close()
@@ -26,7 +27,7 @@ func DumpFile(name string, obj interface{}) {
 func DumpLog(name string, obj interface{}) {
 	file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
+		slog.Error("Error opening file", "file", name, "error", err)
 		return
 	}
 	defer file.Close()
@@ -35,11 +36,11 @@ func DumpLog(name string, obj interface{}) {
 
 	_, err = file.Write(j)
 	if err != nil {
-		fmt.Println("Error writing file:", err)
+		slog.Error("Error writing file", "file", name, "error", err)
 	}
 
 	err = file.Close()
 	if err != nil {
-		fmt.Println("Error closing file:", err)
+		slog.Error("Error closing file", "file", name, "error", err)
 	}
 }